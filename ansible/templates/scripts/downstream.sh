@@ -11,7 +11,12 @@ PORT="{{ wirejump.interfaces.downstream.port }}"
 # downstream interface CIDR
 CIDR="{{ wirejump_downstream_cidr }}"
 
-# upstream interface name (needed for killswitch)
+# dual-stack mode and downstream interface IPv6 CIDR
+IPV6="{{ 'yes' if wirejump.ipv6 else 'no' }}"
+CIDR6="{{ wirejump_downstream_cidr6 }}"
+
+# upstream interface name (needed for killswitch); exit interfaces
+# are named after it, so they are matched with a wildcard
 UPSTREAM="{{ wirejump.interfaces.upstream.name }}"
 
 if [[ -z "$THISDIR" ]]; then
@@ -36,7 +41,7 @@ if [[ "$INTERFACE" != "" && "$OPERATION" != "" ]]; then
         iptables -A PREROUTING -t mangle -i "$INTERFACE" ! -d "$CIDR" -j MARK --set-mark "$FWMARK"
 
         # killswitch: reject all outgoing non-local downstream traffic trying to leave the server not via upstream; allow everything else
-        iptables -A forward-allowed -i "$INTERFACE" ! -o "$UPSTREAM" -m mark --mark "$FWMARK" -j REJECT --reject-with icmp-host-unreachable
+        iptables -A forward-allowed -i "$INTERFACE" ! -o "$UPSTREAM+" -m mark --mark "$FWMARK" -j REJECT --reject-with icmp-host-unreachable
         iptables -A forward-allowed -i "$INTERFACE" -j ACCEPT
 
         # allow DNS requests from downstream
@@ -47,17 +52,36 @@ if [[ "$INTERFACE" != "" && "$OPERATION" != "" ]]; then
         # explicitly disallow upstream, even though it's probably filtered already
         iptables -A udp-allowed ! -i "$UPSTREAM" -p udp --dport "$PORT" -j ACCEPT
 
+        # same rules for IPv6
+        if [[ "$IPV6" == "yes" ]]; then
+            ip6tables -A PREROUTING -t mangle -i "$INTERFACE" ! -d "$CIDR6" -j MARK --set-mark "$FWMARK"
+            ip6tables -A forward-allowed -i "$INTERFACE" ! -o "$UPSTREAM+" -m mark --mark "$FWMARK" -j REJECT --reject-with icmp6-addr-unreachable
+            ip6tables -A forward-allowed -i "$INTERFACE" -j ACCEPT
+            ip6tables -A tcp-allowed -p tcp -i "$INTERFACE" --dport 53 -j ACCEPT
+            ip6tables -A udp-allowed -p udp -i "$INTERFACE" --dport 53 -j ACCEPT
+            ip6tables -A udp-allowed ! -i "$UPSTREAM" -p udp --dport "$PORT" -j ACCEPT
+        fi
+
         # adjust MTU
         /sbin/ip link set dev "$INTERFACE" mtu "$MTU"
 
         info "$1 brought up"
     elif [[ "$OPERATION" == "down" ]]; then
+        if [[ "$IPV6" == "yes" ]]; then
+            ip6tables -D udp-allowed ! -i "$UPSTREAM" -p udp --dport "$PORT" -j ACCEPT
+            ip6tables -D udp-allowed -p udp -i "$INTERFACE" --dport 53 -j ACCEPT
+            ip6tables -D tcp-allowed -p tcp -i "$INTERFACE" --dport 53 -j ACCEPT
+            ip6tables -D forward-allowed -i "$INTERFACE" -j ACCEPT
+            ip6tables -D forward-allowed -i "$INTERFACE" ! -o "$UPSTREAM+" -m mark --mark "$FWMARK" -j REJECT --reject-with icmp6-addr-unreachable
+            ip6tables -D PREROUTING -t mangle -i "$INTERFACE" ! -d "$CIDR6" -j MARK --set-mark "$FWMARK"
+        fi
+
         iptables -D udp-allowed ! -i "$UPSTREAM" -p udp --dport "$PORT" -j ACCEPT
         iptables -D udp-allowed -p udp -i "$INTERFACE" --dport 53 -j ACCEPT
         iptables -D tcp-allowed -p tcp -i "$INTERFACE" --dport 53 -j ACCEPT
 
         iptables -D forward-allowed -i "$INTERFACE" -j ACCEPT
-        iptables -D forward-allowed -i "$INTERFACE" ! -o "$UPSTREAM" -m mark --mark "$FWMARK" -j REJECT --reject-with icmp-host-unreachable
+        iptables -D forward-allowed -i "$INTERFACE" ! -o "$UPSTREAM+" -m mark --mark "$FWMARK" -j REJECT --reject-with icmp-host-unreachable
 
         iptables -D PREROUTING -t mangle -i "$INTERFACE" ! -d "$CIDR" -j MARK --set-mark "$FWMARK"
 
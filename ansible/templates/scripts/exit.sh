@@ -0,0 +1,120 @@
+#!/bin/bash
+#
+# this script is run for exit interfaces: additional upstreams,
+# which are used by exit group peers only. Each group has its
+# own routing table, group peers are routed to it by their
+# source addresses (listed in a file written by the daemon)
+
+if [[ -z "$THISDIR" ]]; then
+    THIS=$(readlink -f "${BASH_SOURCE[0]}" 2>/dev/null || echo "$0")
+    THISDIR=$(dirname "${THIS}")
+fi
+
+if [[ -f "$THISDIR/gateway.sh" ]]; then
+    # shellcheck source=gateway.sh
+    source "$THISDIR/gateway.sh"
+else
+    echo "[!] failed to find gateway.sh script"
+    exit 1
+fi
+
+INTERFACE="$1"
+OPERATION="$2"
+TABLE="$3"
+PEERS="{{ wirejump.basedir }}/config/$INTERFACE.peers"
+
+# fallback route has the lowest priority possible, so it's only
+# used when exit interface is down: group peers lose Internet
+# access instead of leaking via main upstream
+UNREACHABLE_METRIC=4294967295
+
+# remove all rules pointing to exit table
+function flush_rules() {
+    while ip -4 rule del lookup "$TABLE" 2>/dev/null; do :; done
+    while ip -6 rule del lookup "$TABLE" 2>/dev/null; do :; done
+}
+
+# route [fwmarked] traffic of group peers to exit table
+function sync_rules() {
+    flush_rules
+
+    ip -4 route replace unreachable default metric "$UNREACHABLE_METRIC" table "$TABLE"
+    ip -6 route replace unreachable default metric "$UNREACHABLE_METRIC" table "$TABLE" 2>/dev/null
+
+    if [[ ! -f "$PEERS" ]]; then
+        return
+    fi
+
+    while read -r ADDRESS; do
+        if [[ "$ADDRESS" == "" ]]; then
+            continue
+        fi
+
+        # exit table is checked before main upstream one
+        if [[ "$ADDRESS" == *:* ]]; then
+            ip -6 rule add from "$ADDRESS" fwmark "$FWMARK" lookup "$TABLE" priority "$TABLE"
+        else
+            ip -4 rule add from "$ADDRESS" fwmark "$FWMARK" lookup "$TABLE" priority "$TABLE"
+        fi
+    done < "$PEERS"
+}
+
+if [[ "$INTERFACE" == "" || "$OPERATION" == "" ]]; then
+    fail "invalid params"
+fi
+
+if [[ ! "$TABLE" =~ ^[0-9]+$ ]]; then
+    fail "invalid routing table"
+fi
+
+# table number is used as rules priority, so it must go before main upstream rule
+if (( TABLE >= UPSTREAM_PRIORITY )); then
+    fail "exit table $TABLE would be checked after upstream table"
+fi
+
+if [[ "$OPERATION" == "up" ]]; then
+    sync_rules
+
+    # interface is point-to-point, so there's no need for a gateway
+    ip -4 route replace 0.0.0.0/0 dev "$INTERFACE" table "$TABLE"
+
+    # masquerade everything for exit
+    iptables -t nat -A POSTROUTING -o "$INTERFACE" -j MASQUERADE
+
+    # same for IPv6, if exit has got IPv6 address
+    if has_ipv6 "$INTERFACE"; then
+        ip -6 route replace ::/0 dev "$INTERFACE" table "$TABLE"
+        ip6tables -t nat -A POSTROUTING -o "$INTERFACE" -j MASQUERADE
+    fi
+
+    info "$1 brought up"
+elif [[ "$OPERATION" == "down" ]]; then
+    # rules are kept on purpose, so group peers stay on exit table
+
+    # remove IPv6 routing first, if any
+    if has_ipv6 "$INTERFACE"; then
+        ip6tables -t nat -D POSTROUTING -o "$INTERFACE" -j MASQUERADE
+        ip -6 route del ::/0 dev "$INTERFACE" table "$TABLE" || info "IPv6 def route already deleted"
+    fi
+
+    # remove masquerade
+    iptables -t nat -D POSTROUTING -o "$INTERFACE" -j MASQUERADE
+
+    # remove default route (exit table)
+    ip -4 route del 0.0.0.0/0 dev "$INTERFACE" table "$TABLE" || info "def route already deleted"
+
+    info "$1 will be brought down"
+elif [[ "$OPERATION" == "sync" ]]; then
+    sync_rules
+
+    info "$1 routing rules updated"
+elif [[ "$OPERATION" == "remove" ]]; then
+    flush_rules
+
+    ip -4 route flush table "$TABLE"
+    ip -6 route flush table "$TABLE" 2>/dev/null
+
+    info "$1 routing removed"
+else
+    fail "invalid operation"
+fi
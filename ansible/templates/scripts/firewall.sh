@@ -5,6 +5,9 @@
 
 PATH="/sbin:/usr/sbin:/bin:/usr/bin"
 
+# dual-stack mode
+IPV6="{{ 'yes' if wirejump.ipv6 else 'no' }}"
+
 firewall_start() {
     # create dedicated *-allowed chains
     iptables -N tcp-allowed
@@ -48,6 +51,41 @@ firewall_start() {
     iptables -P FORWARD DROP
 }
 
+# same essential bits for IPv6
+firewall6_start() {
+    ip6tables -N tcp-allowed
+    ip6tables -N udp-allowed
+    ip6tables -N forward-allowed
+
+    ip6tables -N sshguard
+    ip6tables -A INPUT -j sshguard
+
+    ip6tables -A INPUT -m conntrack --ctstate RELATED,ESTABLISHED -j ACCEPT
+    ip6tables -A INPUT -i lo -j ACCEPT
+
+    # ICMPv6 is essential for IPv6 (neighbor discovery, PMTU), accept it all
+    ip6tables -A INPUT -p ipv6-icmp -j ACCEPT
+
+    ip6tables -A INPUT -p udp -m conntrack --ctstate NEW -j udp-allowed
+    ip6tables -A INPUT -p tcp --syn -m conntrack --ctstate NEW -j tcp-allowed
+
+    ip6tables -A INPUT -m conntrack --ctstate INVALID -j DROP
+    ip6tables -A INPUT -p udp -j REJECT --reject-with icmp6-port-unreachable
+    ip6tables -A INPUT -p tcp -j REJECT --reject-with tcp-reset
+    ip6tables -A INPUT -j REJECT --reject-with icmp6-adm-prohibited
+
+    ip6tables -A FORWARD -p tcp --tcp-flags SYN,RST SYN -j TCPMSS --clamp-mss-to-pmtu
+
+    ip6tables -A FORWARD -m conntrack --ctstate RELATED,ESTABLISHED -j ACCEPT
+    ip6tables -A FORWARD -j forward-allowed
+    ip6tables -A FORWARD -j REJECT --reject-with icmp6-adm-prohibited
+
+    ip6tables -A tcp-allowed -p tcp --dport 22 -j ACCEPT
+
+    ip6tables -P INPUT DROP
+    ip6tables -P FORWARD DROP
+}
+
 # clear iptables configuration
 firewall_stop() {
     iptables -F
@@ -56,15 +94,32 @@ firewall_stop() {
     iptables -P FORWARD ACCEPT
 }
 
+# clear ip6tables configuration
+firewall6_stop() {
+    ip6tables -F
+    ip6tables -X
+    ip6tables -P INPUT   ACCEPT
+    ip6tables -P FORWARD ACCEPT
+}
+
 # process params
 case "$1" in
     start|restart)
         echo "Starting firewall"
         firewall_stop
         firewall_start
+
+        if [ "$IPV6" = "yes" ]; then
+            firewall6_stop
+            firewall6_start
+        fi
         ;;
     stop)
         echo "Stopping firewall"
         firewall_stop
+
+        if [ "$IPV6" = "yes" ]; then
+            firewall6_stop
+        fi
         ;;
 esac
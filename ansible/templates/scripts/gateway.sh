@@ -6,6 +6,15 @@
 # this fwmark will be used to mark all traffic coming from downstream
 export FWMARK=33
 
+# fwmarked traffic is routed via this table; either upstream
+# or standby interface provides default route there
+export UPSTREAM_TABLE="wirejump_table"
+
+# fwmark rule priority of upstream table; it's fixed, so the rule
+# is always checked after exit table ones (which use table number
+# as priority), no matter in which order interfaces were brought up
+export UPSTREAM_PRIORITY=1000
+
 function get_upstream_gw() {
     local UPSTREAM_GW=""
 
@@ -25,6 +34,11 @@ function get_upstream_gw() {
     echo ""
 }
 
+# check if interface has global IPv6 address
+function has_ipv6() {
+    ip -6 addr show dev "$1" scope global 2>/dev/null | grep -q inet6
+}
+
 function fail() {
     if [[ "$1" != "" ]]; then
         echo "[!] $1"
@@ -15,22 +15,52 @@ ZONENAME="wjpeers"
 # output file for unbound
 HOSTSFILE=/etc/unbound/unbound.conf.d/local-peers.conf
 
+# downstream config, which holds peer names set by 'wjcli peer --name'
+CONFIG="{{ wirejump.basedir }}/config/downstream.conf"
+
+# print peer name by its public key; nothing is printed for unnamed peers
+peer_name() {
+    awk -v key="$1" '
+        function check() {
+            if (pubkey == key && name != "") {
+                print name
+                found = 1
+                exit
+            }
+        }
+        function value() {
+            sub(/^[^=]*=[[:blank:]]*/, "")
+            sub(/[[:blank:]\r]+$/, "")
+            return $0
+        }
+        /^[[:blank:]]*\[/ { check(); name = ""; pubkey = "" }
+        /^[[:blank:]]*#@Name[[:blank:]]*=/ { name = value() }
+        /^[[:blank:]]*PublicKey[[:blank:]]*=/ { pubkey = value() }
+        END { if (!found) check() }
+    ' "${CONFIG}"
+}
+
 # ensure interface is up and running
 if ip a | grep -Eq ": ${INTERFACE}:.*UP"; then
     TEMPFILE=$(mktemp)
     PEERSFILE=$(mktemp)
 
-    # each peer will get a DNS name corresponding to first 8 bytes of their public key
+    # each peer will get a DNS name: either its name, or
+    # first 8 bytes of its public key for unnamed peers
     for PEER in $(wg show "${INTERFACE}" peers); do
         ENDPOINT=$(wg show "${INTERFACE}" endpoints | grep "${PEER}")
 
         # during reconnects, peer can become stale; don't process it then
         if ! echo "${ENDPOINT}" | grep -q '(none)'; then
             ADDRESS=$(echo "${ENDPOINT}" | cut -f 2 -d ' ' | cut -f 1 -d ':')
-            KEYPREFIX=$(echo "${PEER}" | base64 -d | hexdump -n 8 -e '1/1 "%02x"')
+            HOSTNAME=$(peer_name "${PEER}")
+
+            if [[ -z "${HOSTNAME}" ]]; then
+                HOSTNAME=$(echo "${PEER}" | base64 -d | hexdump -n 8 -e '1/1 "%02x"')
+            fi
 
-            printf "\tlocal-data: \"%s.%s. %s IN A %s\"\n" "${KEYPREFIX}" "${ZONENAME}" "${ZONETTL}" "${ADDRESS}" >> "${PEERSFILE}"
-            printf "\tlocal-data-ptr: \"%s %s %s.%s\"\n\n" "${ADDRESS}" "${ZONETTL}" "${KEYPREFIX}" "${ZONENAME}" >> "${PEERSFILE}"
+            printf "\tlocal-data: \"%s.%s. %s IN A %s\"\n" "${HOSTNAME}" "${ZONENAME}" "${ZONETTL}" "${ADDRESS}" >> "${PEERSFILE}"
+            printf "\tlocal-data-ptr: \"%s %s %s.%s\"\n\n" "${ADDRESS}" "${ZONETTL}" "${HOSTNAME}" "${ZONENAME}" >> "${PEERSFILE}"
         fi
     done
 
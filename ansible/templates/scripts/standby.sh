@@ -0,0 +1,101 @@
+#!/bin/bash
+#
+# this script is run for standby upstream interface: it's kept up
+# without any routes, and takes upstream table over when promoted
+
+if [[ -z "$THISDIR" ]]; then
+    THIS=$(readlink -f "${BASH_SOURCE[0]}" 2>/dev/null || echo "$0")
+    THISDIR=$(dirname "${THIS}")
+fi
+
+if [[ -f "$THISDIR/gateway.sh" ]]; then
+    # shellcheck source=gateway.sh
+    source "$THISDIR/gateway.sh"
+else
+    echo "[!] failed to find gateway.sh script"
+    exit 1
+fi
+
+INTERFACE="$1"
+OPERATION="$2"
+UPSTREAM_GW=$(get_upstream_gw)
+TABLE="$UPSTREAM_TABLE"
+
+# check if upstream table is routed via this interface
+function owns_table() {
+    ip "$1" route show table "$TABLE" default | grep -qw "dev $INTERFACE"
+}
+
+# add fwmark rule with fixed priority unless it's there already
+function add_rule() {
+    if [[ -z "$(ip "$1" rule show priority "$UPSTREAM_PRIORITY" fwmark "$FWMARK" lookup "$TABLE")" ]]; then
+        # drop rules left with another priority, if any
+        while ip "$1" rule del fwmark "$FWMARK" lookup "$TABLE" 2>/dev/null; do :; done
+
+        ip "$1" rule add from all fwmark "$FWMARK" lookup "$TABLE" priority "$UPSTREAM_PRIORITY"
+    fi
+}
+
+# remove upstream routes pointing to this interface; fwmark rules are
+# kept, so downstream traffic is stopped by killswitch until upstream
+# interface is brought up again
+function release_table() {
+    if owns_table -6; then
+        ip -6 route del ::/0 dev "$INTERFACE" table "$TABLE" || info "IPv6 def route already deleted"
+    fi
+
+    if owns_table -4; then
+        ip -4 route del 0.0.0.0/0 dev "$INTERFACE" table "$TABLE" || info "def route already deleted"
+    fi
+}
+
+if [[ "$INTERFACE" == "" || "$OPERATION" == "" ]]; then
+    fail "invalid params"
+fi
+
+if [[ "$OPERATION" == "up" ]]; then
+    # masquerade is set up in advance, so promotion is quick
+    iptables -t nat -A POSTROUTING -o "$INTERFACE" -j MASQUERADE
+
+    if has_ipv6 "$INTERFACE"; then
+        ip6tables -t nat -A POSTROUTING -o "$INTERFACE" -j MASQUERADE
+    fi
+
+    info "$1 brought up"
+elif [[ "$OPERATION" == "down" ]]; then
+    release_table
+
+    if has_ipv6 "$INTERFACE"; then
+        ip6tables -t nat -D POSTROUTING -o "$INTERFACE" -j MASQUERADE
+    fi
+
+    iptables -t nat -D POSTROUTING -o "$INTERFACE" -j MASQUERADE
+
+    info "$1 will be brought down"
+elif [[ "$OPERATION" == "promote" ]]; then
+    # interface is point-to-point, so there's no need for a gateway;
+    # routes are replaced, so there's no moment without them
+    ip -4 route replace 0.0.0.0/0 dev "$INTERFACE" table "$TABLE"
+    add_rule -4
+
+    # upstream gateway is used for DNS, so it's moved as well
+    if [[ "$UPSTREAM_GW" != "" ]]; then
+        ip -4 route replace "$UPSTREAM_GW" scope link dev "$INTERFACE"
+    fi
+
+    # IPv6 is only routed if standby has got IPv6 address
+    if has_ipv6 "$INTERFACE"; then
+        ip -6 route replace ::/0 dev "$INTERFACE" table "$TABLE"
+        add_rule -6
+    else
+        ip -6 route del ::/0 table "$TABLE" 2>/dev/null
+    fi
+
+    info "$1 has taken over upstream routing"
+elif [[ "$OPERATION" == "demote" ]]; then
+    release_table
+
+    info "$1 has released upstream routing"
+else
+    fail "invalid operation"
+fi
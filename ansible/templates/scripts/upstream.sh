@@ -18,35 +18,75 @@ fi
 INTERFACE="$1"
 OPERATION="$2"
 UPSTREAM_GW=$(get_upstream_gw)
-TABLE="wirejump_table"
+TABLE="$UPSTREAM_TABLE"
+
+# add fwmark rule with fixed priority unless it's there already (kept by standby takeover)
+function add_rule() {
+    if [[ -z "$(ip "$1" rule show priority "$UPSTREAM_PRIORITY" fwmark "$FWMARK" lookup "$TABLE")" ]]; then
+        # drop rules left with another priority, if any
+        while ip "$1" rule del fwmark "$FWMARK" lookup "$TABLE" 2>/dev/null; do :; done
+
+        ip "$1" rule add from all fwmark "$FWMARK" lookup "$TABLE" priority "$UPSTREAM_PRIORITY"
+    fi
+}
+
+# check if upstream table is routed via this interface; it's
+# not the case when standby interface has taken over
+function owns_table() {
+    ip "$1" route show table "$TABLE" default | grep -qw "dev $INTERFACE"
+}
 
 # valid ip is required
 if [[ "$UPSTREAM_GW" != "" ]]; then
     if [[ "$INTERFACE" != "" && "$OPERATION" != "" ]]; then
         if [[ "$OPERATION" == "up" ]]; then
-            # add link route to default gw (main table)
-            ip -4 route add "$UPSTREAM_GW" scope link dev "$INTERFACE"
+            # add link route to default gw (main table); routes are replaced,
+            # since standby interface could have taken them over
+            ip -4 route replace "$UPSTREAM_GW" scope link dev "$INTERFACE"
 
             # add default gw for upstream (upstream table)
-            ip -4 route add 0.0.0.0/0 via "$UPSTREAM_GW" table "$TABLE"
+            ip -4 route replace 0.0.0.0/0 via "$UPSTREAM_GW" table "$TABLE"
 
             # use wgtable for fwmarked traffic [coming from downstream]
-            ip rule add from all fwmark "$FWMARK" lookup "$TABLE"
+            add_rule -4
 
             # masquerade everything for upstream
             iptables -t nat -A POSTROUTING -o "$INTERFACE" -j MASQUERADE
 
+            # same for IPv6, if upstream has got IPv6 address; there's
+            # no need for a gateway, since interface is point-to-point
+            if has_ipv6 "$INTERFACE"; then
+                ip -6 route replace ::/0 dev "$INTERFACE" table "$TABLE"
+                add_rule -6
+                ip6tables -t nat -A POSTROUTING -o "$INTERFACE" -j MASQUERADE
+            fi
+
             info "$1 brought up"
         elif [[ "$OPERATION" == "down" ]]; then
 
+            # remove IPv6 routing first, if any; if standby has taken
+            # over, routing is left to it
+            if has_ipv6 "$INTERFACE"; then
+                ip6tables -t nat -D POSTROUTING -o "$INTERFACE" -j MASQUERADE
+
+                if owns_table -6; then
+                    ip -6 rule del from all fwmark "$FWMARK" lookup "$TABLE" || info "IPv6 rule already deleted"
+                    ip -6 route del ::/0 dev "$INTERFACE" table "$TABLE" || info "IPv6 def route already deleted"
+                fi
+            fi
+
             # remove masquerade
             iptables -t nat -D POSTROUTING -o "$INTERFACE" -j MASQUERADE
 
-            # no need to route traffic [from downstream] anymore
-            ip rule del from all fwmark "$FWMARK" lookup "$TABLE" || info "rule already deleted"
+            if owns_table -4; then
+                # no need to route traffic [from downstream] anymore
+                ip rule del from all fwmark "$FWMARK" lookup "$TABLE" || info "rule already deleted"
 
-            # remove default gw for upstream (upstream table)
-            ip -4 route del 0.0.0.0/0 via "$UPSTREAM_GW" table "$TABLE" || info "def route already deleted"
+                # remove default gw for upstream (upstream table)
+                ip -4 route del 0.0.0.0/0 via "$UPSTREAM_GW" table "$TABLE" || info "def route already deleted"
+            else
+                info "upstream routing is kept for standby"
+            fi
 
             # remove link route to default gw (main table)
             ip -4 route del "$UPSTREAM_GW" scope link dev "$INTERFACE" || info "gw route already deleted"
@@ -0,0 +1,168 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+	"wirejump/cmd/wirejumpd/handlers"
+	"wirejump/internal/ipc"
+	"wirejump/internal/notify"
+	"wirejump/internal/state"
+)
+
+// Check provider account twice a day by default
+const DefaultAccountInterval = 12 * time.Hour
+
+// How often to check whether account check is due
+const accountCheckInterval = time.Minute
+
+// Don't retry failed account check sooner than this
+const accountRetryInterval = 30 * time.Minute
+
+// Account events
+const (
+	accountExpiringEvent = "account_expiring"
+	accountExpiredEvent  = "account_expired"
+	accountRenewedEvent  = "account_renewed"
+)
+
+// Account monitor refreshes provider account info and warns
+// when the account is about to expire or has already expired
+type AccountMonitor struct {
+	Config    state.AccountConfig
+	Notifiers []notify.Notifier
+	lastState string
+}
+
+// Create account monitor with notifiers from config
+func NewAccountMonitor(Config state.AccountConfig) *AccountMonitor {
+	notifiers := []notify.Notifier{notify.LogNotifier{}}
+
+	if Config.NotifyScript != "" {
+		notifiers = append(notifiers, notify.ScriptNotifier{Path: Config.NotifyScript})
+	}
+
+	if Config.NotifyWebhook != "" {
+		notifiers = append(notifiers, notify.WebhookNotifier{URL: Config.NotifyWebhook})
+	}
+
+	return &AccountMonitor{Config: Config, Notifiers: notifiers}
+}
+
+// Create event for account state change, if it's worth notifying about
+func accountEvent(previous string, current string, provider string, expires int64, Now time.Time) *notify.Event {
+	fields := map[string]string{
+		"provider": provider,
+		"expires":  fmt.Sprint(expires),
+	}
+
+	expiryDate := time.Unix(expires, 0).Format(time.RFC1123)
+
+	switch current {
+	case handlers.AccountStateExpiring:
+		days := int(time.Unix(expires, 0).Sub(Now).Hours() / 24)
+		fields["days"] = fmt.Sprint(days)
+
+		return &notify.Event{
+			Name:    accountExpiringEvent,
+			Message: fmt.Sprintf("%s account expires in %d day(s), on %s", provider, days, expiryDate),
+			Fields:  fields,
+		}
+	case handlers.AccountStateExpired:
+		return &notify.Event{
+			Name:    accountExpiredEvent,
+			Message: fmt.Sprintf("%s account has expired on %s", provider, expiryDate),
+			Fields:  fields,
+		}
+	case handlers.AccountStateActive:
+		// Only interesting if there was a warning before
+		if previous != handlers.AccountStateExpiring && previous != handlers.AccountStateExpired {
+			return nil
+		}
+
+		return &notify.Event{
+			Name:    accountRenewedEvent,
+			Message: fmt.Sprintf("%s account has been renewed until %s", provider, expiryDate),
+			Fields:  fields,
+		}
+	}
+
+	return nil
+}
+
+// Run single account check. Returns error if account info could not be fetched
+func (m *AccountMonitor) Tick(Now time.Time) error {
+	var event *notify.Event
+
+	err := ipc.LockedExec(func(State *state.AppState) error {
+		if State.UpstreamProvider == nil || State.UpstreamProvider.Provider == nil {
+			m.lastState = ""
+
+			return nil
+		}
+
+		changed, err := handlers.RefreshAccount(State)
+
+		if changed {
+			if err := state.SaveState(State); err != nil {
+				log.Println("failed to save state:", err)
+			}
+		}
+
+		// Known expiration date is still worth warning about
+		current := handlers.AccountState(State, Now)
+
+		if current != m.lastState {
+			details := State.UpstreamProvider.Provider.Details()
+			event = accountEvent(m.lastState, current, details.ProviderName, details.ValidUntil, Now)
+			m.lastState = current
+		}
+
+		return err
+	})
+
+	// Notifiers can be slow, so they are run without holding the lock
+	if event != nil {
+		notify.Send(m.Notifiers, *event)
+	}
+
+	return err
+}
+
+// Run account checks until context is done. First check is made
+// shortly after start, so warnings are not delayed by a restart
+func (m *AccountMonitor) Run(ctx context.Context) {
+	var nextCheck time.Time
+
+	ticker := time.NewTicker(accountCheckInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			if now.Before(nextCheck) {
+				continue
+			}
+
+			err := m.Tick(now)
+
+			// Busy server will be checked again next time
+			if errors.Is(err, ipc.ErrLocked) {
+				continue
+			}
+
+			if err != nil {
+				log.Println("failed to check provider account:", err)
+				nextCheck = now.Add(accountRetryInterval)
+
+				continue
+			}
+
+			nextCheck = now.Add(m.Config.Interval)
+		}
+	}
+}
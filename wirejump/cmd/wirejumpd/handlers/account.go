@@ -0,0 +1,85 @@
+package handlers
+
+import (
+	"errors"
+	"time"
+	"wirejump/internal/providers"
+	"wirejump/internal/state"
+)
+
+// Provider account states, as shown by 'status'
+const (
+	AccountStateActive   = "active"
+	AccountStateExpiring = "expiring"
+	AccountStateExpired  = "expired"
+)
+
+// Warn about expiring account this many days in advance by default
+const DefaultAccountWarnDays = 7
+
+// Get provider account state. Empty string is returned if there's
+// no provider or its account has no expiration date
+func AccountState(State *state.AppState, Now time.Time) string {
+	if State.UpstreamProvider == nil || State.UpstreamProvider.Provider == nil {
+		return ""
+	}
+
+	expires := State.UpstreamProvider.Provider.Details().ValidUntil
+
+	if expires == 0 {
+		return ""
+	}
+
+	warnDays := DefaultAccountWarnDays
+
+	if State.Config != nil {
+		warnDays = State.Config.Account.WarnDays
+	}
+
+	left := time.Unix(expires, 0).Sub(Now)
+
+	if left <= 0 {
+		return AccountStateExpired
+	}
+
+	if left <= time.Duration(warnDays)*24*time.Hour {
+		return AccountStateExpiring
+	}
+
+	return AccountStateActive
+}
+
+// Check if both provider sessions are the same
+func sameSession(a *providers.ProviderSession, b *providers.ProviderSession) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+
+	return a.Token == b.Token
+}
+
+// Fetch account info from provider and update account expiration date.
+// Returns true if expiration date or provider session has changed
+func RefreshAccount(State *state.AppState) (bool, error) {
+	if State.UpstreamProvider == nil || State.UpstreamProvider.Provider == nil {
+		return false, errors.New("provider is not selected")
+	}
+
+	session := providers.GetSession(State.UpstreamProvider.Provider)
+	acc, err := State.UpstreamProvider.Provider.GetAccountInfo()
+
+	if err != nil {
+		return false, err
+	}
+
+	details := State.UpstreamProvider.Provider.Details()
+
+	// New session has to be saved, otherwise it's lost on restart
+	if details.ValidUntil == acc.Expires {
+		return !sameSession(session, providers.GetSession(State.UpstreamProvider.Provider)), nil
+	}
+
+	details.ValidUntil = acc.Expires
+
+	return true, nil
+}
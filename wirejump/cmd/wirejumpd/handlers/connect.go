@@ -4,14 +4,52 @@ import (
 	"errors"
 	"fmt"
 	"log"
+	"strings"
 	"time"
 	"wirejump/internal/ipc"
+	"wirejump/internal/metrics"
 	"wirejump/internal/network"
 	"wirejump/internal/providers"
 	"wirejump/internal/state"
 	"wirejump/internal/utils"
 )
 
+// Upstream peer keepalive interval, seconds
+const UpstreamKeepalive = 25
+
+// Connection metrics
+const (
+	connectAttemptsMetric = "wirejump_connect_attempts_total"
+	connectFailuresMetric = "wirejump_connect_failures_total"
+)
+
+func init() {
+	metrics.Register(connectAttemptsMetric, metrics.KindCounter, "Upstream connection attempts")
+	metrics.Register(connectFailuresMetric, metrics.KindCounter, "Failed upstream connection attempts")
+}
+
+// Keep only addresses which can be used by upstream interface:
+// IPv6 ones are dropped unless dual-stack is enabled
+func UpstreamAddresses(Addresses string, DualStack bool) []string {
+	addresses := []string{}
+
+	for _, address := range strings.Split(Addresses, ",") {
+		address = strings.TrimSpace(address)
+
+		if address == "" {
+			continue
+		}
+
+		if strings.Contains(address, ":") && !DualStack {
+			continue
+		}
+
+		addresses = append(addresses, address)
+	}
+
+	return addresses
+}
+
 // Shutdown existing connection. Will be reused by Reset command
 func Disconnect(State *state.AppState) error {
 	// Upstream does not exist at all
@@ -46,7 +84,7 @@ func Disconnect(State *state.AppState) error {
 // - create new interace key and update it in the account
 // - select new upstream which != previous upstream
 // - bring connection back up
-func (h *IpcHandler) Connect(State *state.AppState, Params *ipc.ConnectCommandRequest, Reply *interface{}) error {
+func (h *IpcHandler) Connect(State *state.AppState, Params *ipc.ConnectCommandRequest, Reply *interface{}) (err error) {
 	new_location := ""
 	new_upstream := providers.WireguardServer{}
 
@@ -54,7 +92,18 @@ func (h *IpcHandler) Connect(State *state.AppState, Params *ipc.ConnectCommandRe
 		return errors.New("setup a provider first")
 	}
 
-	if !State.UpstreamProvider.Provider.Initialized {
+	// Count actual connection attempts only, not disconnects
+	if !Params.Disconnect {
+		metrics.Add(connectAttemptsMetric, nil, 1)
+
+		defer func() {
+			if err != nil {
+				metrics.Add(connectFailuresMetric, nil, 1)
+			}
+		}()
+	}
+
+	if !State.UpstreamProvider.Provider.Details().Initialized {
 		return errors.New("provider is not initialized")
 	}
 
@@ -79,19 +128,15 @@ func (h *IpcHandler) Connect(State *state.AppState, Params *ipc.ConnectCommandRe
 			}
 		}
 
-		// No location preference provided, go for random one; TODO: debug log it
-		if State.UpstreamProvider.PreferredLocation == nil {
-			randomLocation := providers.GetRandomElement(State.Servers.Locations)
+		// First available preferred location, or random one if there's none
+		location, err := PickLocation(State)
 
-			if randomLocation != nil {
-				new_location = *randomLocation
-			} else {
-				return errors.New("no server locations available, check provider settings")
-			}
-		} else {
-			new_location = *State.UpstreamProvider.PreferredLocation
+		if err != nil {
+			return err
 		}
 
+		new_location = location
+
 		// If location override is specified, try to use it
 		if Params.LocationOverride != nil {
 			override := *Params.LocationOverride
@@ -100,11 +145,35 @@ func (h *IpcHandler) Connect(State *state.AppState, Params *ipc.ConnectCommandRe
 				return fmt.Errorf("location '%s' is not found", override)
 			}
 
+			if !IsAllowedLocation(State, override) {
+				return fmt.Errorf("location '%s' is excluded", override)
+			}
+
 			new_location = override
 		}
 
 		// Determine upstream server
-		upstream, err := providers.GuessNewUpstream(State.Servers, State.UpstreamProvider.Server, new_location)
+		best := BestServers(State, new_location)
+
+		if Params.BestServers != nil {
+			best = *Params.BestServers
+		}
+
+		strategy := SelectionStrategy(State)
+
+		if Params.Strategy != "" {
+			strategy = Params.Strategy
+		}
+
+		params := providers.SelectionParams{
+			Location: new_location,
+			Previous: State.UpstreamProvider.Server,
+			Failed:   Params.Failed,
+			BestN:    best,
+			Filter:   updateFilter(ActiveServerFilter(State), Params.Filter),
+		}
+
+		upstream, err := SelectUpstream(State, strategy, params)
 
 		// Fail early and preserve current connection if there's no new upstream available
 		if err != nil {
@@ -114,6 +183,19 @@ func (h *IpcHandler) Connect(State *state.AppState, Params *ipc.ConnectCommandRe
 		new_upstream = upstream
 	}
 
+	// Standby carries traffic while main upstream is rebuilt, so
+	// reconnect is not noticed by downstream; explicit disconnect
+	// should stop traffic via standby as well
+	if Params.Disconnect {
+		if err := DemoteStandby(State); err != nil {
+			return err
+		}
+	} else if StandbyReady(State) {
+		if err := PromoteStandby(State); err != nil {
+			log.Println("failed to move traffic to standby upstream:", err)
+		}
+	}
+
 	// Shut down existing connection
 	if err := Disconnect(State); err != nil {
 		return fmt.Errorf("failed to shutdown existing connection: %s", err)
@@ -124,48 +206,105 @@ func (h *IpcHandler) Connect(State *state.AppState, Params *ipc.ConnectCommandRe
 		return nil
 	}
 
-	// Rotate keys
-	if !Params.PreserveKeys {
+	if err := ConnectInterface(State, State.Network.Upstream, new_upstream, Params.PreserveKeys); err != nil {
+		return err
+	}
+
+	// Upstream script takes upstream routing back from standby
+	if State.Standby != nil {
+		State.Standby.Promoted = false
+	}
+
+	// Record current time
+	t := time.Now().Unix()
+	State.UpstreamProvider.ActiveSince = &t
+
+	// Finally, new_upstream has proven itself good, so it can be updated
+	State.UpstreamProvider.Server = &new_upstream
+
+	return nil
+}
+
+// Bring upstream interface up using a given server: rotate keys unless asked
+// otherwise, get interface address and write interface config from scratch.
+// Used both by main upstream and exit interfaces; interface should be down
+func ConnectInterface(State *state.AppState, Iface *network.InterfaceConfig, Server providers.WireguardServer, PreserveKeys bool, ScriptArgs ...string) error {
+	// Some providers dictate keys, so there's nothing to rotate
+	if static, ok := State.UpstreamProvider.Provider.(providers.StaticKeysAPI); ok {
+		key, err := static.GetPrivateKey(Server)
+
+		if err != nil {
+			return fmt.Errorf("failed to get upstream private key: %s", err)
+		}
+
+		Iface.PrivateKey = key
+
+		if err := Iface.GeneratePublicKey(); err != nil {
+			return fmt.Errorf("failed to create new public key: %s", err)
+		}
+	} else if !PreserveKeys {
+		// Rotate keys
 		// Remove current key from the account
-		if err := State.UpstreamProvider.Provider.RemovePubkey(State.Network.Upstream.PublicKey); err != nil {
+		if err := State.UpstreamProvider.Provider.RemovePubkey(Iface.PublicKey); err != nil {
 			log.Println("failed to remove old pubkey:", err)
 		}
 
 		// Create new private key or quit. That's pretty important,
 		// since new connection can't be made without a key
-		if err := State.Network.Upstream.GeneratePrivateKey(); err != nil {
+		if err := Iface.GeneratePrivateKey(); err != nil {
 			return fmt.Errorf("failed to create new private key: %s", err)
 		}
 
 		// Create new public key or quit, same restrictions apply
-		if err := State.Network.Upstream.GeneratePublicKey(); err != nil {
+		if err := Iface.GeneratePublicKey(); err != nil {
 			return fmt.Errorf("failed to create new public key: %s", err)
 		}
 
 		// Add generated pubkey to the account
-		if err := State.UpstreamProvider.Provider.AddPubkey(State.Network.Upstream.PublicKey); err != nil {
+		if err := State.UpstreamProvider.Provider.AddPubkey(Iface.PublicKey); err != nil {
 			return fmt.Errorf("failed to add key to the account: %s", err)
 		}
 	}
 
+	// Route all IPv4 traffic, and all IPv6 traffic if upstream supports it
+	allowed := []string{"0.0.0.0/0"}
+
 	// Get interface address
-	if addr, err := State.UpstreamProvider.Provider.GetAddress(State.Network.Upstream.PublicKey); err != nil {
+	if addr, err := State.UpstreamProvider.Provider.GetAddress(Iface.PublicKey); err != nil {
 		return fmt.Errorf("failed to get upstream IP address: %s", err)
 	} else {
-		State.Network.Upstream.Address = addr
+		addresses := UpstreamAddresses(addr, State.Config.DualStack)
+
+		if len(addresses) == 0 {
+			return errors.New("provider has returned no usable upstream addresses")
+		}
+
+		for _, address := range addresses {
+			if strings.Contains(address, ":") {
+				allowed = append(allowed, "::/0")
+				break
+			}
+		}
+
+		Iface.Address = strings.Join(addresses, ", ")
 	}
 
 	// Get interface scripts and ignore errors, as interface and script actions
 	// are certainly defined at this point
-	upscript, _ := State.Network.Upstream.GetInterfaceScriptPath("up")
-	downscript, _ := State.Network.Upstream.GetInterfaceScriptPath("down")
+	upscript, _ := Iface.GetInterfaceScriptPath(network.InterfaceScriptKindUp)
+	downscript, _ := Iface.GetInterfaceScriptPath(network.InterfaceScriptKindDown)
+
+	for _, arg := range ScriptArgs {
+		upscript += fmt.Sprintf(" \"%s\"", arg)
+		downscript += fmt.Sprintf(" \"%s\"", arg)
+	}
 
 	// Assemble final upstream config
 	config := utils.INIFile{
 		"Interface": {
 			utils.INIPair{
-				"Address":    State.Network.Upstream.Address,
-				"PrivateKey": State.Network.Upstream.PrivateKey,
+				"Address":    Iface.Address,
+				"PrivateKey": Iface.PrivateKey,
 				"Table":      "off", // This is needed because custom routing table will be used
 				"PostUp":     upscript,
 				"PreDown":    downscript,
@@ -173,29 +312,26 @@ func (h *IpcHandler) Connect(State *state.AppState, Params *ipc.ConnectCommandRe
 		},
 		"Peer": {
 			utils.INIPair{
-				"PublicKey":  new_upstream.Pubkey,
-				"AllowedIPs": "0.0.0.0/0",
-				"Endpoint":   fmt.Sprintf("%s:%d", new_upstream.IPv4, new_upstream.Port),
+				"PublicKey":  Server.Pubkey,
+				"AllowedIPs": strings.Join(allowed, ", "),
+				"Endpoint":   Server.Endpoint(State.Config.PreferIPv6),
+
+				// Keep handshakes going even if there's no traffic,
+				// so upstream liveness can be judged by them
+				"PersistentKeepalive": fmt.Sprint(UpstreamKeepalive),
 			},
 		},
 	}
 
 	// Write new interface config, since both upstream and address can be new at this point
-	if err := State.Network.Upstream.WriteConfig(config); err != nil {
+	if err := Iface.WriteConfig(config); err != nil {
 		return fmt.Errorf("failed to write interface config: %s", err)
 	}
 
 	// Finally bring interface back up
-	if err := State.Network.Upstream.BringUp(); err != nil {
+	if err := Iface.BringUp(); err != nil {
 		return fmt.Errorf("failed to bring interface up: %s", err)
 	}
 
-	// Record current time
-	t := time.Now().Unix()
-	State.UpstreamProvider.ActiveSince = &t
-
-	// Finally, new_upstream has proven itself good, so it can be updated
-	State.UpstreamProvider.Server = &new_upstream
-
 	return nil
 }
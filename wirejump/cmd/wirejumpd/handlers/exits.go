@@ -0,0 +1,502 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/netip"
+	"os"
+	"path"
+	"strings"
+	"time"
+	"wirejump/internal/ipc"
+	"wirejump/internal/network"
+	"wirejump/internal/providers"
+	"wirejump/internal/state"
+)
+
+// Exit groups let some of the downstream peers use their own upstream:
+// each group has its own interface connected to its own server, using
+// a separate key (device) on the same provider account. Peers are put
+// into groups via metadata in downstream config, and their traffic is
+// routed to group interface by source address rules, which are managed
+// by exit interface script.
+
+// Exit group slots; provider device limit can be lower than that
+const MaxExitGroups = 4
+
+// Exit routing tables start from this one. Table number is used as
+// rules priority as well, so group rules go before main upstream one,
+// which has UPSTREAM_PRIORITY (1000) set by gateway.sh
+const ExitTableBase = 100
+
+// Exit group name which means main upstream
+const MainExitName = "main"
+
+// Peers file suffix; file lists group peer addresses for exit script
+const exitPeersSuffix = "peers"
+
+// Exit interface name is upstream name with slot number appended
+func ExitInterfaceName(State *state.AppState, Slot int) string {
+	return fmt.Sprintf("%s%d", State.Config.UpstreamName, Slot)
+}
+
+// Routing table of the exit group
+func ExitTable(Group *state.ExitGroup) int {
+	return ExitTableBase + Group.Slot
+}
+
+// How many devices exit groups and standby upstream can use along with
+// main upstream; providers which allow a single key per account have none
+func SpareDevices(State *state.AppState) int {
+	if State.UpstreamProvider == nil {
+		return 0
+	}
+
+	multi, ok := State.UpstreamProvider.Provider.(providers.MultiDeviceAPI)
+
+	if !ok {
+		return 0
+	}
+
+	return max(min(multi.MultiDevice()-1, MaxExitGroups), 0)
+}
+
+// Find exit group by name, nil if it's not found
+func FindExit(State *state.AppState, Name string) *state.ExitGroup {
+	for _, group := range State.Exits {
+		if strings.EqualFold(group.Name, Name) {
+			return group
+		}
+	}
+
+	return nil
+}
+
+// Check that exit group requested for a peer exists;
+// main upstream and empty name are accepted as well
+func CheckPeerExit(State *state.AppState, Name string) error {
+	if Name == "" || Name == MainExitName || FindExit(State, Name) != nil {
+		return nil
+	}
+
+	return fmt.Errorf("exit group '%s' is not found", Name)
+}
+
+// Get exit group interface, creating it if needed
+func exitInterface(State *state.AppState, Group *state.ExitGroup) (*network.InterfaceConfig, error) {
+	if Group.Interface != nil {
+		return Group.Interface, nil
+	}
+
+	iface, err := network.CreateInterface(ExitInterfaceName(State, Group.Slot), network.InterfaceKindExit)
+
+	if err != nil {
+		return nil, err
+	}
+
+	Group.Interface = &iface
+
+	return Group.Interface, nil
+}
+
+// Get path of the exit group peers file
+func exitPeersPath(Iface *network.InterfaceConfig) string {
+	return path.Join(network.BasePath, "config", fmt.Sprintf("%s.%s", Iface.Name, exitPeersSuffix))
+}
+
+// Get addresses of all peers which belong to the exit group
+func exitPeerAddresses(State *state.AppState, Name string) ([]string, error) {
+	conf, err := State.Network.Downstream.ReadConfig()
+
+	if err != nil {
+		return nil, err
+	}
+
+	addresses := []string{}
+
+	for _, peer := range conf["Peer"] {
+		if !strings.EqualFold(strings.Trim(peer[peerExitKey], " "), Name) {
+			continue
+		}
+
+		for _, part := range strings.Split(peer["AllowedIPs"], ",") {
+			prefix, err := netip.ParsePrefix(strings.Trim(part, " "))
+
+			if err == nil && prefix.IsSingleIP() {
+				addresses = append(addresses, prefix.Addr().String())
+			}
+		}
+	}
+
+	return addresses, nil
+}
+
+// Update exit group routing rules according to its peers in downstream config
+func SyncExitPeers(State *state.AppState, Group *state.ExitGroup) error {
+	iface, err := exitInterface(State, Group)
+
+	if err != nil {
+		return err
+	}
+
+	addresses, err := exitPeerAddresses(State, Group.Name)
+
+	if err != nil {
+		return err
+	}
+
+	contents := strings.Join(addresses, "\n") + "\n"
+
+	if err := os.WriteFile(exitPeersPath(iface), []byte(contents), 0600); err != nil {
+		return fmt.Errorf("cannot write exit peers: %s", err)
+	}
+
+	if err := iface.RunScript(network.InterfaceScriptKindSync, fmt.Sprint(ExitTable(Group))); err != nil {
+		return fmt.Errorf("cannot update exit routing: %s", err)
+	}
+
+	return nil
+}
+
+// Update routing of the exit groups affected by peer change;
+// main upstream and missing groups are skipped
+func syncPeerExits(State *state.AppState, Names ...string) error {
+	synced := map[string]bool{}
+
+	for _, name := range Names {
+		group := FindExit(State, name)
+
+		if group == nil || synced[group.Name] {
+			continue
+		}
+
+		synced[group.Name] = true
+
+		if err := SyncExitPeers(State, group); err != nil {
+			return fmt.Errorf("exit group '%s': %s", group.Name, err)
+		}
+	}
+
+	return nil
+}
+
+// Bring exit interface down. Routing rules are kept, so group
+// peers lose Internet access instead of using main upstream
+func DisconnectExit(State *state.AppState, Group *state.ExitGroup) error {
+	iface, err := exitInterface(State, Group)
+
+	if err != nil {
+		return err
+	}
+
+	// Same as for main upstream, missing interface is a down one
+	if active, err := iface.IsActive(); active && err == nil {
+		if err := iface.BringDown(); err != nil {
+			return err
+		}
+	}
+
+	Group.Server = nil
+	Group.ActiveSince = nil
+
+	return nil
+}
+
+// Connect exit group to a new server in group location
+func ConnectExit(State *state.AppState, Group *state.ExitGroup) error {
+	if State.UpstreamProvider == nil || !State.UpstreamProvider.Provider.Details().Initialized {
+		return errors.New("setup a provider first")
+	}
+
+	if SpareDevices(State) == 0 {
+		return errors.New("provider allows a single device only, exit groups are not supported")
+	}
+
+	iface, err := exitInterface(State, Group)
+
+	if err != nil {
+		return err
+	}
+
+	if UpstreamCacheIsBad(State) {
+		if err := UpdateUpstreamServers(State); err != nil {
+			return fmt.Errorf("connect needs fresh servers, but update has failed: %s", err)
+		}
+	}
+
+	location := ""
+
+	if Group.Location != nil {
+		location = *Group.Location
+	} else if location, err = RandomLocation(State); err != nil {
+		return err
+	}
+
+	params := providers.SelectionParams{
+		Location: location,
+		Previous: Group.Server,
+		BestN:    BestServers(State, location),
+		Filter:   ActiveServerFilter(State),
+	}
+
+	server, err := SelectUpstream(State, SelectionStrategy(State), params)
+
+	if err != nil {
+		return fmt.Errorf("unable to guess upstream: %s", err)
+	}
+
+	if err := DisconnectExit(State, Group); err != nil {
+		return fmt.Errorf("failed to shutdown existing connection: %s", err)
+	}
+
+	// Rules go first, so group traffic never leaks via main upstream
+	if err := SyncExitPeers(State, Group); err != nil {
+		return err
+	}
+
+	if err := ConnectInterface(State, iface, server, false, fmt.Sprint(ExitTable(Group))); err != nil {
+		return err
+	}
+
+	t := time.Now().Unix()
+	Group.ActiveSince = &t
+	Group.Server = &server
+
+	return nil
+}
+
+// Tear exit group down completely: disconnect it, remove its key from the
+// account, clean up its routing and remove its files. Errors are logged,
+// so that as much as possible is cleaned up
+func cleanupExit(State *state.AppState, Group *state.ExitGroup) {
+	iface, err := exitInterface(State, Group)
+
+	if err != nil {
+		log.Println("failed to cleanup exit group:", err)
+
+		return
+	}
+
+	if err := DisconnectExit(State, Group); err != nil {
+		log.Println("failed to disconnect exit group:", err)
+	}
+
+	if State.UpstreamProvider != nil && iface.PublicKey != "" {
+		if err := State.UpstreamProvider.Provider.RemovePubkey(iface.PublicKey); err != nil {
+			log.Println("failed to remove exit group pubkey:", err)
+		}
+	}
+
+	if err := iface.RunScript(network.InterfaceScriptKindRemove, fmt.Sprint(ExitTable(Group))); err != nil {
+		log.Println("failed to cleanup exit group routing:", err)
+	}
+
+	if config, err := iface.GetInterfaceConfigPath(); err == nil {
+		os.Remove(config)
+	}
+
+	os.Remove(exitPeersPath(iface))
+}
+
+// Create exit group and connect it
+func AddExit(State *state.AppState, Name string, Location string) (*state.ExitGroup, error) {
+	name := strings.ToLower(strings.TrimSpace(Name))
+
+	if !peerNameFormat.MatchString(name) {
+		return nil, errors.New("exit group name should only contain letters, digits and dashes, up to 63 characters")
+	}
+
+	if name == MainExitName {
+		return nil, fmt.Errorf("exit group name '%s' is reserved for main upstream", MainExitName)
+	}
+
+	if FindExit(State, name) != nil {
+		return nil, fmt.Errorf("exit group '%s' already exists", name)
+	}
+
+	if State.UpstreamProvider == nil {
+		return nil, errors.New("setup a provider first")
+	}
+
+	group := state.ExitGroup{Name: name}
+
+	if Location != "" {
+		if !IsValidLocation(State, Location) {
+			return nil, fmt.Errorf("location '%s' is not found", Location)
+		}
+
+		group.Location = &Location
+	}
+
+	// Another key would replace main upstream one
+	if SpareDevices(State) == 0 {
+		return nil, fmt.Errorf("provider '%s' allows a single device only, exit groups are not supported", State.UpstreamProvider.Provider.Details().ProviderName)
+	}
+
+	// Standby takes one of the devices as well
+	limit := SpareDevices(State)
+
+	if State.Standby != nil {
+		limit--
+	}
+
+	if len(State.Exits) >= limit {
+		if State.Standby != nil {
+			return nil, fmt.Errorf("no more than %d exit groups are supported along with standby upstream", limit)
+		}
+
+		return nil, fmt.Errorf("no more than %d exit groups are supported", limit)
+	}
+
+	// Pick the lowest free slot
+	taken := map[int]bool{}
+
+	for _, existing := range State.Exits {
+		taken[existing.Slot] = true
+	}
+
+	for slot := 1; slot <= MaxExitGroups; slot++ {
+		if !taken[slot] {
+			group.Slot = slot
+
+			break
+		}
+	}
+
+	if group.Slot == 0 {
+		return nil, fmt.Errorf("no more than %d exit groups are supported", MaxExitGroups)
+	}
+
+	if err := ConnectExit(State, &group); err != nil {
+		cleanupExit(State, &group)
+
+		return nil, err
+	}
+
+	State.Exits = append(State.Exits, &group)
+
+	return &group, nil
+}
+
+// Remove exit group. Group should have no peers, so
+// they are not moved to main upstream unnoticed
+func RemoveExit(State *state.AppState, Group *state.ExitGroup) error {
+	addresses, err := exitPeerAddresses(State, Group.Name)
+
+	if err != nil {
+		return err
+	}
+
+	if len(addresses) != 0 {
+		return fmt.Errorf("exit group '%s' still has peers, move them to another group first", Group.Name)
+	}
+
+	cleanupExit(State, Group)
+
+	exits := []*state.ExitGroup{}
+
+	for _, existing := range State.Exits {
+		if existing != Group {
+			exits = append(exits, existing)
+		}
+	}
+
+	State.Exits = exits
+
+	return nil
+}
+
+// Remove all exit groups, regardless of their peers
+func ResetExits(State *state.AppState) {
+	for _, group := range State.Exits {
+		cleanupExit(State, group)
+	}
+
+	State.Exits = nil
+}
+
+// Get exit group status
+func ExitInfo(State *state.AppState, Group *state.ExitGroup) ipc.ExitInfo {
+	info := ipc.ExitInfo{
+		Name:        Group.Name,
+		Interface:   ExitInterfaceName(State, Group.Slot),
+		Location:    Group.Location,
+		ActiveSince: Group.ActiveSince,
+	}
+
+	if Group.Interface != nil {
+		info.Online, _ = Group.Interface.IsActive()
+	}
+
+	if Group.Server != nil {
+		info.Country = stringOrNil(Group.Server.Country)
+		info.City = stringOrNil(Group.Server.City)
+	}
+
+	if addresses, err := exitPeerAddresses(State, Group.Name); err == nil {
+		info.Peers = len(addresses)
+	}
+
+	return info
+}
+
+// Manage exit groups
+func (h *IpcHandler) ManageExits(State *state.AppState, Params *ipc.ExitCommandRequest, Reply *interface{}) error {
+	if Params.Operation == ipc.ExitCommandAdd {
+		group, err := AddExit(State, Params.Name, strings.TrimSpace(Params.Location))
+
+		if err != nil {
+			return err
+		}
+
+		*Reply = ExitInfo(State, group)
+
+		return nil
+	}
+
+	group := FindExit(State, strings.TrimSpace(Params.Name))
+
+	if group == nil {
+		return fmt.Errorf("exit group '%s' is not found", Params.Name)
+	}
+
+	switch Params.Operation {
+	case ipc.ExitCommandConnect:
+		// Location is changed for good, same as preferred location
+		if location := strings.TrimSpace(Params.Location); location != "" {
+			if !IsValidLocation(State, location) {
+				return fmt.Errorf("location '%s' is not found", location)
+			}
+
+			group.Location = &location
+		}
+
+		if err := ConnectExit(State, group); err != nil {
+			return err
+		}
+
+		*Reply = ExitInfo(State, group)
+
+		return nil
+	case ipc.ExitCommandDisconnect:
+		return DisconnectExit(State, group)
+	case ipc.ExitCommandRemove:
+		return RemoveExit(State, group)
+	default:
+		return fmt.Errorf("unknown exit operation: %d", Params.Operation)
+	}
+}
+
+// Show all exit groups
+func (h *IpcHandler) ListExits(State *state.AppState, Params *ipc.ExitListRequest, Reply *interface{}) error {
+	exits := ipc.ExitListReply{}
+
+	for _, group := range State.Exits {
+		exits = append(exits, ExitInfo(State, group))
+	}
+
+	*Reply = exits
+
+	return nil
+}
@@ -0,0 +1,131 @@
+package handlers
+
+import (
+	"errors"
+	"log"
+	"strings"
+	"wirejump/internal/ipc"
+	"wirejump/internal/providers"
+	"wirejump/internal/state"
+)
+
+// Probing relays takes a while, so it's never done by commands themselves,
+// which hold server and state locks: servers to probe are collected under
+// the locks, probed without them, and scores are cached under the locks
+// again. Server selection only uses cached scores afterwards.
+
+// Refresh latency scores for a location, which is picked by Locate while
+// state is locked; nothing is probed if it returns false. Does nothing
+// if server is busy, since the command would fail anyway
+func RefreshLatency(Locate func(State *state.AppState) (string, bool), Force bool) {
+	var pending []providers.WireguardServer
+
+	err := ipc.LockedExec(func(State *state.AppState) error {
+		if State.UpstreamProvider == nil || State.Servers == nil {
+			return nil
+		}
+
+		if location, ok := Locate(State); ok {
+			pending = providers.PendingLatency(State.Servers, location, Force)
+		}
+
+		return nil
+	})
+
+	if err != nil || len(pending) == 0 {
+		return
+	}
+
+	scores := providers.ProbeLatency(pending)
+
+	err = ipc.LockedExec(func(State *state.AppState) error {
+		// Servers could be reset in the meantime
+		if State.Servers != nil {
+			State.Servers.ApplyLatency(scores)
+		}
+
+		return nil
+	})
+
+	if err != nil && !errors.Is(err, ipc.ErrLocked) {
+		log.Println("failed to save server latency:", err)
+	}
+}
+
+// Get location to show latency for, first preferred one by default
+func latencyLocation(State *state.AppState, Location string) string {
+	if Location == "" && len(State.UpstreamProvider.PreferredLocations) > 0 {
+		return State.UpstreamProvider.PreferredLocations[0]
+	}
+
+	return Location
+}
+
+// Check if the fastest servers are used for a location
+func usesLatency(State *state.AppState, Location string, Best *int) bool {
+	if Best != nil {
+		return *Best > 0
+	}
+
+	return BestServers(State, Location) > 0
+}
+
+// Probe servers connect is going to pick from. Random location can't be
+// known in advance, so only cached scores are used for it
+func PrepareConnect(Params *ipc.ConnectCommandRequest) {
+	if Params.Disconnect {
+		return
+	}
+
+	RefreshLatency(func(State *state.AppState) (string, bool) {
+		location := ""
+
+		if Params.LocationOverride != nil {
+			location = *Params.LocationOverride
+		} else {
+			for _, preferred := range State.UpstreamProvider.PreferredLocations {
+				if IsAllowedLocation(State, preferred) {
+					location = preferred
+					break
+				}
+			}
+		}
+
+		return location, location != "" && usesLatency(State, location, Params.BestServers)
+	}, false)
+}
+
+// Probe servers exit group is going to pick from
+func prepareExit(Params *ipc.ExitCommandRequest) {
+	if Params.Operation != ipc.ExitCommandAdd && Params.Operation != ipc.ExitCommandConnect {
+		return
+	}
+
+	RefreshLatency(func(State *state.AppState) (string, bool) {
+		location := strings.TrimSpace(Params.Location)
+
+		if group := FindExit(State, strings.TrimSpace(Params.Name)); location == "" && group != nil && group.Location != nil {
+			location = *group.Location
+		}
+
+		return location, location != "" && usesLatency(State, location, nil)
+	}, false)
+}
+
+// Do slow preparations for a command before it's executed, see ipc.IpcPreparer
+func (h *IpcHandler) Prepare(Name string, Params interface{}) {
+	switch params := Params.(type) {
+	case *ipc.ServersCommandRequest:
+		if params.Latency {
+			RefreshLatency(func(State *state.AppState) (string, bool) {
+				location := latencyLocation(State, params.Location)
+
+				return location, IsValidLocation(State, location)
+			}, params.ForceUpdate)
+		}
+	case *ipc.ConnectCommandRequest:
+		PrepareConnect(params)
+	case *ipc.ExitCommandRequest:
+		prepareExit(params)
+	}
+}
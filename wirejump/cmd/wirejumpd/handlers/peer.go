@@ -3,25 +3,206 @@ package handlers
 import (
 	"errors"
 	"fmt"
+	"log"
+	"net"
 	"net/netip"
+	"regexp"
 	"strings"
+	"wirejump/internal/clientconf"
 	"wirejump/internal/ipc"
 	"wirejump/internal/network"
 	"wirejump/internal/state"
 	"wirejump/internal/utils"
 )
 
-// Add downstream peer
-func AddPeer(State *state.AppState, Pubkey string, Isolated bool) (string, error) {
+// Peer metadata keys. Metadata is stored as special comments
+// in downstream config, so WireGuard tools are not affected
+const (
+	peerNameKey        = utils.INIMetaPrefix + "Name"
+	peerDescriptionKey = utils.INIMetaPrefix + "Description"
+	peerOwnerKey       = utils.INIMetaPrefix + "Owner"
+	peerExitKey        = utils.INIMetaPrefix + "Exit"
+)
+
+// Peer names are used in DNS, so they should be valid hostname labels
+var peerNameFormat = regexp.MustCompile(`^[a-z0-9]([a-z0-9-]{0,61}[a-z0-9])?$`)
+
+// Optional peer metadata
+type PeerMetadata struct {
+	Name        string
+	Description string
+	Owner       string
+	Exit        string
+}
+
+// Check metadata values and normalize the name
+func (m *PeerMetadata) Validate() error {
+	m.Name = strings.ToLower(strings.TrimSpace(m.Name))
+	m.Description = strings.TrimSpace(m.Description)
+	m.Owner = strings.TrimSpace(m.Owner)
+	m.Exit = strings.ToLower(strings.TrimSpace(m.Exit))
+
+	if m.Name != "" && !peerNameFormat.MatchString(m.Name) {
+		return errors.New("peer name should only contain letters, digits and dashes, up to 63 characters")
+	}
+
+	if strings.ContainsAny(m.Description+m.Owner, "\r\n") {
+		return errors.New("peer description and owner should be single line")
+	}
+
+	return nil
+}
+
+// Store non-empty metadata values in peer config section;
+// main upstream is the default, so it's not stored
+func (m *PeerMetadata) Apply(peer utils.INIPair) {
+	values := map[string]string{
+		peerNameKey:        m.Name,
+		peerDescriptionKey: m.Description,
+		peerOwnerKey:       m.Owner,
+		peerExitKey:        m.Exit,
+	}
+
+	for key, value := range values {
+		if value != "" {
+			peer[key] = value
+		}
+	}
+
+	if m.Exit == MainExitName {
+		delete(peer, peerExitKey)
+	}
+}
+
+// How peer addresses should be picked. Requested addresses
+// take precedence; other families are allocated using the mode
+type AddressRequest struct {
+	Mode      string
+	Addresses []string
+}
+
+// Parse requested addresses, at most one per family;
+// result is keyed by whether address is IPv4 one
+func (r AddressRequest) parse() (map[bool]netip.Addr, error) {
+	result := map[bool]netip.Addr{}
+
+	if !network.IsValidAllocation(r.Mode) {
+		return nil, fmt.Errorf("unknown allocation mode '%s', should be %s or %s", r.Mode, network.AllocationRandom, network.AllocationNext)
+	}
+
+	for _, address := range r.Addresses {
+		address = strings.TrimSpace(address)
+
+		if address == "" {
+			continue
+		}
+
+		// Prefix length is ignored, since network is defined by the server
+		if prefix, err := netip.ParsePrefix(address); err == nil {
+			address = prefix.Addr().String()
+		}
+
+		addr, err := netip.ParseAddr(address)
+
+		if err != nil {
+			return nil, fmt.Errorf("invalid address '%s'", address)
+		}
+
+		if _, exists := result[addr.Is4()]; exists {
+			return nil, errors.New("only one address per IP family can be requested")
+		}
+
+		result[addr.Is4()] = addr.Unmap()
+	}
+
+	return result, nil
+}
+
+// Find peer index by public key or by name, -1 if it's not found
+func findPeer(peers []utils.INIPair, Pubkey string, Name string) int {
+	for i, peer := range peers {
+		if Pubkey != "" && strings.Trim(peer["PublicKey"], " ") == Pubkey {
+			return i
+		}
+
+		if Name != "" && strings.EqualFold(strings.Trim(peer[peerNameKey], " "), Name) {
+			return i
+		}
+	}
+
+	return -1
+}
+
+// Get downstream interface prefixes: IPv4 one is mandatory and goes first,
+// IPv6 one is optional. Prefix addresses are the server ones
+func downstreamPrefixes(conf utils.INIFile) ([]netip.Prefix, error) {
+	var prefix4, prefix6 *netip.Prefix
+
+	for _, part := range strings.Split(conf["Interface"][0]["Address"], ",") {
+		prefix, err := netip.ParsePrefix(strings.Trim(part, " "))
+
+		if err != nil {
+			return nil, err
+		}
+
+		if prefix.Bits() == -1 {
+			return nil, errors.New("downstream interface has invalid network prefix")
+		}
+
+		if prefix.Addr().Is4() && prefix4 == nil {
+			prefix4 = &prefix
+		}
+
+		if prefix.Addr().Is6() && prefix6 == nil {
+			prefix6 = &prefix
+		}
+	}
+
+	if prefix4 == nil {
+		return nil, errors.New("downstream interface has no IPv4 network prefix")
+	}
+
+	prefixes := []netip.Prefix{*prefix4}
+
+	if prefix6 != nil {
+		prefixes = append(prefixes, *prefix6)
+	}
+
+	return prefixes, nil
+}
+
+// Add downstream peer. Returns IPv4 address along with the network prefix,
+// and the same for IPv6 if downstream network has IPv6 prefix as well.
+// Preshared key is optional
+func AddPeer(State *state.AppState, Pubkey string, PresharedKey string, Isolated bool, Meta PeerMetadata, Request AddressRequest) (string, string, error) {
 	if !network.IsValidKey(Pubkey) {
-		return "", errors.New("invalid public key")
+		return "", "", errors.New("invalid public key")
+	}
+
+	if PresharedKey != "" && !network.IsValidKey(PresharedKey) {
+		return "", "", errors.New("invalid preshared key")
+	}
+
+	if err := Meta.Validate(); err != nil {
+		return "", "", err
+	}
+
+	requested, err := Request.parse()
+
+	if err != nil {
+		return "", "", err
 	}
 
 	pool := []netip.Addr{}
 	conf, err := State.Network.Downstream.ReadConfig()
 
 	if err != nil {
-		return "", err
+		return "", "", err
+	}
+
+	// Names are used for lookups, so they should be unique
+	if Meta.Name != "" && findPeer(conf["Peer"], "", Meta.Name) != -1 {
+		return "", "", fmt.Errorf("peer named '%s' is already registered", Meta.Name)
 	}
 
 	// Iterate all peers
@@ -31,55 +212,42 @@ func AddPeer(State *state.AppState, Pubkey string, Isolated bool) (string, error
 
 		// Check if key is already present
 		if key == Pubkey {
-			return "", errors.New("peer with this key is already registered")
+			return "", "", errors.New("peer with this key is already registered")
 		}
 
-		// Contains server network CIDR
-		if strings.Contains(ips, ",") {
-			parts := strings.Split(ips, ",")
-
-			// Only single IP required
-			for _, part := range parts {
-				prefix, err := netip.ParsePrefix(strings.Trim(part, " "))
-
-				if err != nil {
-					return "", err
-				}
-
-				if prefix.IsSingleIP() {
-					pool = append(pool, prefix.Addr())
-				}
-			}
-		} else {
-			addr, err := netip.ParsePrefix(ips)
+		// Can contain server network CIDRs, only single IPs are required
+		for _, part := range strings.Split(ips, ",") {
+			prefix, err := netip.ParsePrefix(strings.Trim(part, " "))
 
 			if err != nil {
-				return "", err
+				return "", "", err
 			}
 
-			pool = append(pool, addr.Addr())
+			if prefix.IsSingleIP() {
+				pool = append(pool, prefix.Addr())
+			}
 		}
 	}
 
-	// Get interface prefix
-	prefix, err := netip.ParsePrefix(strings.Trim(conf["Interface"][0]["Address"], " "))
+	prefixes, err := downstreamPrefixes(conf)
 
 	if err != nil {
-		return "", err
+		return "", "", err
 	}
 
-	if prefix.Bits() == -1 {
-		return "", errors.New("downstream interface has invalid network prefix")
+	// Interface address itself is taken as well
+	for _, prefix := range prefixes {
+		pool = append(pool, prefix.Addr())
 	}
 
-	free, err := network.GetFreeIP(pool, prefix)
-
-	if err != nil {
-		return "", err
+	if len(prefixes) == 1 {
+		if _, exists := requested[false]; exists {
+			return "", "", errors.New("downstream interface has no IPv6 network prefix")
+		}
 	}
 
-	// Format peer IP address. For the server config file,
-	// /32 netmask is mandatory since it indicates main peer
+	// Format peer IP addresses. For the server config file,
+	// /32 (or /128) netmask is mandatory since it indicates main peer
 	// address. If peer's not isolated, whole downstream network
 	// will be appended along with the prefix. For the client
 	// configuration, however, it doesn't make much sense because
@@ -87,42 +255,83 @@ func AddPeer(State *state.AppState, Pubkey string, Isolated bool) (string, error
 	// WireGuard will control which IPs can be routed for that particular
 	// peer, so there's no harm if a peer tries to communicate outside
 	// it's AllowedIPs range – it will receive a routing error.
-	ipv4 := free.String()
-	addr := []string{fmt.Sprintf("%s/%d", ipv4, 32)}
+	addr := []string{}
+	networks := []string{}
+	formatted := []string{}
+
+	for _, prefix := range prefixes {
+		free, err := allocateIP(pool, prefix, requested, Request.Mode)
+
+		if err != nil {
+			return "", "", err
+		}
+
+		addr = append(addr, fmt.Sprintf("%s/%d", free, free.BitLen()))
+		networks = append(networks, prefix.Masked().String())
+
+		// Client needs address with network prefix
+		formatted = append(formatted, fmt.Sprintf("%s/%d", free, prefix.Bits()))
+	}
 
 	// Create peer
 	peer := utils.INIPair{
 		"PublicKey": Pubkey,
 	}
 
-	// If peer is not isolated (default), add interface network to allowed IPs
+	if PresharedKey != "" {
+		peer["PresharedKey"] = PresharedKey
+	}
+
+	// If peer is not isolated (default), add interface networks to allowed IPs
 	if !Isolated {
-		addr = append(addr, prefix.Masked().String())
+		addr = append(addr, networks...)
 	}
 
 	peer["AllowedIPs"] = strings.Join(addr, ", ")
+	Meta.Apply(peer)
 
 	// Update config
 	conf["Peer"] = append(conf["Peer"], peer)
 
 	// Write new config
 	if err := State.Network.Downstream.WriteConfig(conf); err != nil {
-		return "", err
+		return "", "", err
+	}
+
+	// Exit routing goes first, so peer traffic never uses wrong upstream
+	if err := syncPeerExits(State, Meta.Exit); err != nil {
+		return "", "", fmt.Errorf("peer has been added to config, but exit routing can not be updated: %s", err)
 	}
 
 	// Update interface state for this peer
-	if err := State.Network.Downstream.UpdatePeerConfig("add", Pubkey, addr); err != nil {
-		return "", err
+	if err := State.Network.Downstream.UpdatePeerConfig("add", Pubkey, PresharedKey, addr); err != nil {
+		return "", "", err
+	}
+
+	if len(formatted) > 1 {
+		return formatted[0], formatted[1], nil
 	}
 
-	// Client needs address with network prefix
-	formatted := fmt.Sprintf("%s/%d", ipv4, prefix.Bits())
+	return formatted[0], "", nil
+}
+
+// Get address for the prefix: either requested one, or allocated one
+func allocateIP(pool []netip.Addr, prefix netip.Prefix, requested map[bool]netip.Addr, mode string) (netip.Addr, error) {
+	allocator := network.NewAllocator(prefix, pool)
+
+	if addr, exists := requested[prefix.Addr().Is4()]; exists {
+		return addr, allocator.Check(addr)
+	}
 
-	return formatted, nil
+	return allocator.Allocate(mode)
 }
 
-// Remove downstream peer
-func RemovePeer(State *state.AppState, Pubkey string) error {
+// Remove downstream peer, either by public key or by name
+func RemovePeer(State *state.AppState, Pubkey string, Name string) error {
+	if Pubkey == "" && Name == "" {
+		return errors.New("peer public key or name is required")
+	}
+
 	conf, err := State.Network.Downstream.ReadConfig()
 
 	if err != nil {
@@ -130,21 +339,16 @@ func RemovePeer(State *state.AppState, Pubkey string) error {
 	}
 
 	// Desired peer index
-	index := -1
-
-	// Iterate all peers
-	for i, peer := range conf["Peer"] {
-		if peer["PublicKey"] == Pubkey {
-			index = i
-
-			break
-		}
-	}
+	index := findPeer(conf["Peer"], Pubkey, Name)
 
 	if index == -1 {
-		return errors.New("peer with this key is not found")
+		return errors.New("peer with this key or name is not found")
 	}
 
+	// Name could be used, so get the actual key
+	Pubkey = strings.Trim(conf["Peer"][index]["PublicKey"], " ")
+	exit := strings.Trim(conf["Peer"][index][peerExitKey], " ")
+
 	// Remove peer; not the fastest method, but should be quick enough
 	peers := append([]utils.INIPair{}, conf["Peer"][:index]...)
 	conf["Peer"] = append(peers, conf["Peer"][index+1:]...)
@@ -155,32 +359,559 @@ func RemovePeer(State *state.AppState, Pubkey string) error {
 	}
 
 	// Update interface state for this peer
-	if err := State.Network.Downstream.UpdatePeerConfig("remove", Pubkey, []string{}); err != nil {
+	if err := State.Network.Downstream.UpdatePeerConfig("remove", Pubkey, "", []string{}); err != nil {
+		return err
+	}
+
+	if err := syncPeerExits(State, exit); err != nil {
+		return fmt.Errorf("peer has been removed, but exit routing can not be updated: %s", err)
+	}
+
+	return nil
+}
+
+// Peer changes; empty values are kept as is
+type PeerUpdate struct {
+	Pubkey       string
+	PresharedKey string
+	Isolation    int
+	Meta         PeerMetadata
+}
+
+// Update downstream peer, found either by public key or by name. Peer keeps
+// its addresses; if interface can't be updated, config is left intact, and
+// if config can't be written, interface changes are reverted
+func UpdatePeer(State *state.AppState, Pubkey string, Name string, Update PeerUpdate) (ipc.PeerCommandReply, error) {
+	reply := ipc.PeerCommandReply{}
+
+	if Pubkey == "" && Name == "" {
+		return reply, errors.New("peer public key or name is required")
+	}
+
+	if err := Update.Meta.Validate(); err != nil {
+		return reply, err
+	}
+
+	conf, err := State.Network.Downstream.ReadConfig()
+
+	if err != nil {
+		return reply, err
+	}
+
+	index := findPeer(conf["Peer"], Pubkey, Name)
+
+	if index == -1 {
+		return reply, errors.New("peer with this key or name is not found")
+	}
+
+	current := conf["Peer"][index]
+	oldKey := strings.Trim(current["PublicKey"], " ")
+	newKey := oldKey
+
+	if Update.Pubkey != "" && Update.Pubkey != oldKey {
+		if !network.IsValidKey(Update.Pubkey) {
+			return reply, errors.New("invalid public key")
+		}
+
+		if findPeer(conf["Peer"], Update.Pubkey, "") != -1 {
+			return reply, errors.New("peer with this key is already registered")
+		}
+
+		newKey = Update.Pubkey
+	}
+
+	if Update.Meta.Name != "" {
+		if other := findPeer(conf["Peer"], "", Update.Meta.Name); other != -1 && other != index {
+			return reply, fmt.Errorf("peer named '%s' is already registered", Update.Meta.Name)
+		}
+	}
+
+	psk := strings.Trim(current["PresharedKey"], " ")
+
+	if Update.PresharedKey != "" {
+		if !network.IsValidKey(Update.PresharedKey) {
+			return reply, errors.New("invalid preshared key")
+		}
+
+		psk = Update.PresharedKey
+	}
+
+	prefixes, err := downstreamPrefixes(conf)
+
+	if err != nil {
+		return reply, err
+	}
+
+	// Single IPs are peer addresses, networks mean peer is not isolated
+	oldAllowed := []string{}
+	addresses := []netip.Addr{}
+	isolated := true
+
+	for _, part := range strings.Split(current["AllowedIPs"], ",") {
+		part = strings.Trim(part, " ")
+		prefix, err := netip.ParsePrefix(part)
+
+		if err != nil {
+			return reply, err
+		}
+
+		oldAllowed = append(oldAllowed, part)
+
+		if prefix.IsSingleIP() {
+			addresses = append(addresses, prefix.Addr())
+		} else {
+			isolated = false
+		}
+	}
+
+	// Allowed IPs are only rebuilt if isolation is changed, so any
+	// manually added networks are kept otherwise
+	allowed := oldAllowed
+
+	if (Update.Isolation == ipc.PeerIsolationOn && !isolated) || (Update.Isolation == ipc.PeerIsolationOff && isolated) {
+		isolated = !isolated
+		allowed = []string{}
+
+		for _, addr := range addresses {
+			allowed = append(allowed, fmt.Sprintf("%s/%d", addr, addr.BitLen()))
+		}
+
+		if !isolated {
+			for _, prefix := range prefixes {
+				allowed = append(allowed, prefix.Masked().String())
+			}
+		}
+	}
+
+	updated := utils.INIPair{}
+
+	for key, value := range current {
+		updated[key] = value
+	}
+
+	updated["PublicKey"] = newKey
+	updated["AllowedIPs"] = strings.Join(allowed, ", ")
+	Update.Meta.Apply(updated)
+
+	if psk != "" {
+		updated["PresharedKey"] = psk
+	}
+
+	// Update interface first: new key is added before the old one is
+	// removed, so the peer is reachable by one of them at any moment
+	downstream := State.Network.Downstream
+
+	if err := downstream.UpdatePeerConfig("add", newKey, psk, allowed); err != nil {
+		return reply, err
+	}
+
+	revert := func() {
+		if newKey != oldKey {
+			if err := downstream.UpdatePeerConfig("remove", newKey, "", []string{}); err != nil {
+				log.Println("failed to revert peer update:", err)
+			}
+		}
+
+		if err := downstream.UpdatePeerConfig("add", oldKey, strings.Trim(current["PresharedKey"], " "), oldAllowed); err != nil {
+			log.Println("failed to revert peer update:", err)
+		}
+	}
+
+	if newKey != oldKey {
+		if err := downstream.UpdatePeerConfig("remove", oldKey, "", []string{}); err != nil {
+			revert()
+
+			return reply, err
+		}
+	}
+
+	conf["Peer"][index] = updated
+
+	if err := downstream.WriteConfig(conf); err != nil {
+		revert()
+
+		return reply, err
+	}
+
+	// Peer could be moved between exit groups, so both are updated
+	exit := strings.Trim(updated[peerExitKey], " ")
+
+	if err := syncPeerExits(State, strings.Trim(current[peerExitKey], " "), exit); err != nil {
+		return reply, fmt.Errorf("peer has been updated, but exit routing can not be updated: %s", err)
+	}
+
+	reply.Peer.Name = stringOrNil(strings.Trim(updated[peerNameKey], " "))
+	reply.Peer.Pubkey = newKey
+	reply.Peer.Isolated = isolated
+	reply.Peer.PresharedKey = stringOrNil(psk)
+	reply.Peer.Exit = stringOrNil(exit)
+
+	// Client needs addresses with network prefixes
+	for _, addr := range addresses {
+		for _, prefix := range prefixes {
+			if prefix.Contains(addr) && addr.Is4() {
+				reply.Peer.IPv4Address = fmt.Sprintf("%s/%d", addr, prefix.Bits())
+			} else if prefix.Contains(addr) && reply.Peer.IPv6Address == nil {
+				reply.Peer.IPv6Address = stringOrNil(fmt.Sprintf("%s/%d", addr, prefix.Bits()))
+			}
+		}
+	}
+
+	return reply, nil
+}
+
+// List downstream peers from downstream config along with their runtime
+// stats. If name is provided, only matching peer is listed
+func ListPeers(State *state.AppState, Name string) (ipc.PeerListReply, error) {
+	conf, err := State.Network.Downstream.ReadConfig()
+
+	if err != nil {
+		return nil, err
+	}
+
+	// Interface can be down, then there are no stats to show
+	stats := map[string]network.PeerStatus{}
+
+	if status, err := State.Network.Downstream.GetPeers(); err == nil {
+		for _, peer := range status {
+			stats[peer.PublicKey] = peer
+		}
+	}
+
+	peers := ipc.PeerListReply{}
+
+	for _, peer := range conf["Peer"] {
+		if Name != "" && !strings.EqualFold(strings.Trim(peer[peerNameKey], " "), Name) {
+			continue
+		}
+
+		info := ipc.PeerInfo{
+			Name:        stringOrNil(strings.Trim(peer[peerNameKey], " ")),
+			Pubkey:      strings.Trim(peer["PublicKey"], " "),
+			Description: stringOrNil(strings.Trim(peer[peerDescriptionKey], " ")),
+			Owner:       stringOrNil(strings.Trim(peer[peerOwnerKey], " ")),
+			Exit:        stringOrNil(strings.Trim(peer[peerExitKey], " ")),
+			Isolated:    true,
+		}
+
+		// Single IPs are peer addresses, networks mean peer is not isolated
+		for _, part := range strings.Split(peer["AllowedIPs"], ",") {
+			prefix, err := netip.ParsePrefix(strings.Trim(part, " "))
+
+			if err != nil {
+				continue
+			}
+
+			if !prefix.IsSingleIP() {
+				info.Isolated = false
+			} else if prefix.Addr().Is4() && info.IPv4Address == nil {
+				info.IPv4Address = stringOrNil(prefix.Addr().String())
+			} else if prefix.Addr().Is6() && info.IPv6Address == nil {
+				info.IPv6Address = stringOrNil(prefix.Addr().String())
+			}
+		}
+
+		if status, ok := stats[info.Pubkey]; ok {
+			info.Endpoint = stringOrNil(status.Endpoint)
+			info.RxBytes = status.RxBytes
+			info.TxBytes = status.TxBytes
+
+			if status.LatestHandshake != 0 {
+				handshake := status.LatestHandshake
+				info.LatestHandshake = &handshake
+			}
+		}
+
+		peers = append(peers, info)
+	}
+
+	return peers, nil
+}
+
+// Show all downstream peers
+func (h *IpcHandler) ListPeers(State *state.AppState, Params *ipc.PeerListRequest, Reply *interface{}) error {
+	peers, err := ListPeers(State, strings.TrimSpace(Params.Name))
+
+	if err != nil {
 		return err
 	}
 
+	if Params.Name != "" && len(peers) == 0 {
+		return fmt.Errorf("peer named '%s' is not found", Params.Name)
+	}
+
+	*Reply = peers
+
 	return nil
 }
 
-// Add or remove downstream peers
+// Get server endpoint for client configs. Port can be omitted
+// in config, then downstream listen port is used
+func ClientEndpoint(State *state.AppState) (string, error) {
+	if State.Config == nil || State.Config.Endpoint == "" {
+		return "", errors.New("server 'Endpoint' is not configured")
+	}
+
+	if _, _, err := net.SplitHostPort(State.Config.Endpoint); err == nil {
+		return State.Config.Endpoint, nil
+	}
+
+	conf, err := State.Network.Downstream.ReadConfig()
+
+	if err != nil {
+		return "", err
+	}
+
+	port := strings.Trim(conf["Interface"][0]["ListenPort"], " ")
+
+	if port == "" {
+		return "", errors.New("downstream interface has no listen port")
+	}
+
+	return net.JoinHostPort(strings.Trim(State.Config.Endpoint, "[]"), port), nil
+}
+
+// Create client config for downstream peer. Server is used as DNS,
+// since it's running Unbound on downstream addresses
+func ClientConfig(State *state.AppState, PrivateKey string, PresharedKey string, IPv4 string, IPv6 string) (clientconf.ClientConfig, error) {
+	endpoint, err := ClientEndpoint(State)
+
+	if err != nil {
+		return clientconf.ClientConfig{}, err
+	}
+
+	conf, err := State.Network.Downstream.ReadConfig()
+
+	if err != nil {
+		return clientconf.ClientConfig{}, err
+	}
+
+	config := clientconf.ClientConfig{
+		PrivateKey:   PrivateKey,
+		Addresses:    []string{IPv4},
+		DNS:          []string{},
+		ServerKey:    State.Network.Downstream.PublicKey,
+		PresharedKey: PresharedKey,
+		Endpoint:     endpoint,
+		AllowedIPs:   []string{"0.0.0.0/0"},
+	}
+
+	for _, part := range strings.Split(conf["Interface"][0]["Address"], ",") {
+		if prefix, err := netip.ParsePrefix(strings.Trim(part, " ")); err == nil {
+			config.DNS = append(config.DNS, prefix.Addr().String())
+		}
+	}
+
+	if IPv6 != "" {
+		config.Addresses = append(config.Addresses, IPv6)
+		config.AllowedIPs = append(config.AllowedIPs, "::/0")
+	}
+
+	return config, nil
+}
+
+// Generate peer keys if requested, and check everything needed for
+// client config before peer is changed. Returns public and private keys
+func preparePeerKeys(State *state.AppState, Params *ipc.PeerCommandRequest, Pubkey string) (string, string, error) {
+	if Params.Generate || Params.NeedConfig {
+		if _, err := ClientEndpoint(State); err != nil {
+			return "", "", err
+		}
+	}
+
+	if !Params.Generate {
+		return Pubkey, "", nil
+	}
+
+	if Pubkey != "" {
+		return "", "", errors.New("public key can not be provided when keys are generated")
+	}
+
+	if Params.Format != "" && !clientconf.IsValidFormat(Params.Format) {
+		return "", "", fmt.Errorf("unknown config format '%s', should be one of: %s", Params.Format, strings.Join(clientconf.Formats, ", "))
+	}
+
+	privkey, err := network.GeneratePrivateKey()
+
+	if err != nil {
+		return "", "", fmt.Errorf("failed to generate private key: %s", err)
+	}
+
+	pubkey, err := network.GeneratePublicKey(privkey)
+
+	if err != nil {
+		return "", "", fmt.Errorf("failed to generate public key: %s", err)
+	}
+
+	return pubkey, privkey, nil
+}
+
+// Preshared key adds a layer of symmetric encryption
+// on top of Curve25519, as a post-quantum hardening
+func preparePresharedKey(Params *ipc.PeerCommandRequest) (string, error) {
+	if !Params.Preshared {
+		return "", nil
+	}
+
+	key, err := network.GeneratePresharedKey()
+
+	if err != nil {
+		return "", fmt.Errorf("failed to generate preshared key: %s", err)
+	}
+
+	return key, nil
+}
+
+// Add client config to the reply. Private key is not stored anywhere,
+// so if it's generated, this is the only chance to get it
+func attachClientConfig(State *state.AppState, Params *ipc.PeerCommandRequest, Reply *ipc.PeerCommandReply, PrivateKey string) error {
+	psk := ""
+
+	if Reply.Peer.PresharedKey != nil {
+		psk = *Reply.Peer.PresharedKey
+	}
+
+	ipv6 := ""
+
+	if Reply.Peer.IPv6Address != nil {
+		ipv6 = *Reply.Peer.IPv6Address
+	}
+
+	// Client config is available if server endpoint is known
+	config, err := ClientConfig(State, PrivateKey, psk, Reply.Peer.IPv4Address, ipv6)
+
+	if err == nil {
+		Reply.Client = &config
+	} else if Params.Generate || Params.NeedConfig {
+		return fmt.Errorf("peer has been changed, but client config can not be created: %s", err)
+	}
+
+	if Params.Generate {
+		format := Params.Format
+
+		if format == "" {
+			format = clientconf.FormatWgQuick
+		}
+
+		rendered, err := config.Render(format)
+
+		if err != nil {
+			return fmt.Errorf("peer has been changed, but client config can not be created: %s", err)
+		}
+
+		Reply.Config = &rendered
+	}
+
+	return nil
+}
+
+// Add, update or remove downstream peers
 func (h *IpcHandler) ManagePeers(State *state.AppState, Params *ipc.PeerCommandRequest, Reply *interface{}) error {
 	switch Params.Operation {
 	case ipc.PeerCommandAddPeer:
-		ipv4, err := AddPeer(State, Params.Pubkey, Params.Isolated)
+		meta := PeerMetadata{
+			Name:        Params.Name,
+			Description: Params.Description,
+			Owner:       Params.Owner,
+			Exit:        Params.Exit,
+		}
+
+		if err := meta.Validate(); err != nil {
+			return err
+		}
+
+		if err := CheckPeerExit(State, meta.Exit); err != nil {
+			return err
+		}
+
+		pubkey, privkey, err := preparePeerKeys(State, Params, Params.Pubkey)
+
+		if err != nil {
+			return err
+		}
+
+		psk, err := preparePresharedKey(Params)
+
+		if err != nil {
+			return err
+		}
+
+		request := AddressRequest{
+			Mode:      Params.Allocation,
+			Addresses: Params.Addresses,
+		}
+
+		ipv4, ipv6, err := AddPeer(State, pubkey, psk, Params.Isolated, meta, request)
 
 		if err != nil {
 			return err
 		}
 
 		reply := ipc.PeerCommandReply{}
+		reply.Peer.Name = stringOrNil(meta.Name)
+		reply.Peer.Pubkey = pubkey
 		reply.Peer.Isolated = Params.Isolated
 		reply.Peer.IPv4Address = ipv4
+		reply.Peer.IPv6Address = stringOrNil(ipv6)
+		reply.Peer.PresharedKey = stringOrNil(psk)
+
+		if meta.Exit != MainExitName {
+			reply.Peer.Exit = stringOrNil(meta.Exit)
+		}
+
+		if err := attachClientConfig(State, Params, &reply, privkey); err != nil {
+			return err
+		}
+
+		*Reply = reply
+
+		return nil
+	case ipc.PeerCommandUpdatePeer:
+		update := PeerUpdate{
+			Isolation: Params.Isolation,
+			Meta: PeerMetadata{
+				Name:        Params.NewName,
+				Description: Params.Description,
+				Owner:       Params.Owner,
+				Exit:        Params.Exit,
+			},
+		}
+
+		if err := update.Meta.Validate(); err != nil {
+			return err
+		}
+
+		if err := CheckPeerExit(State, update.Meta.Exit); err != nil {
+			return err
+		}
+
+		pubkey, privkey, err := preparePeerKeys(State, Params, strings.TrimSpace(Params.NewPubkey))
+
+		if err != nil {
+			return err
+		}
+
+		update.Pubkey = pubkey
+
+		if update.PresharedKey, err = preparePresharedKey(Params); err != nil {
+			return err
+		}
+
+		reply, err := UpdatePeer(State, strings.TrimSpace(Params.Pubkey), strings.TrimSpace(Params.Name), update)
+
+		if err != nil {
+			return err
+		}
+
+		if err := attachClientConfig(State, Params, &reply, privkey); err != nil {
+			return err
+		}
 
 		*Reply = reply
 
 		return nil
 	case ipc.PeerCommandDeletePeer:
-		return RemovePeer(State, Params.Pubkey)
+		return RemovePeer(State, strings.TrimSpace(Params.Pubkey), strings.TrimSpace(Params.Name))
 	default:
 		return fmt.Errorf("unknown peer operation: %d", Params.Operation)
 	}
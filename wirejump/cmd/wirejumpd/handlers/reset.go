@@ -15,6 +15,10 @@ func ResetProvider(State *state.AppState) error {
 		return fmt.Errorf("failed to disconnect: %s", err)
 	}
 
+	// Exit groups and standby use the same account, so they go as well
+	ResetExits(State)
+	DisableStandby(State)
+
 	if State.Network.Upstream != nil && State.UpstreamProvider != nil {
 		file, err := State.Network.Upstream.GetInterfaceConfigPath()
 
@@ -0,0 +1,187 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+	"wirejump/internal/ipc"
+	"wirejump/internal/providers"
+	"wirejump/internal/schedule"
+	"wirejump/internal/state"
+)
+
+// Use this value to clear window or locations
+const ScheduleAnyValue = "any"
+
+// Get next rotation time if rotation is enabled and upstream is connected
+func NextRotation(State *state.AppState) *int64 {
+	if State.Rotation == nil || !State.Rotation.Enabled {
+		return nil
+	}
+
+	if State.UpstreamProvider == nil || State.UpstreamProvider.ActiveSince == nil {
+		return nil
+	}
+
+	next := State.Rotation.NextRotation(time.Unix(*State.UpstreamProvider.ActiveSince, 0)).Unix()
+
+	return &next
+}
+
+// Get connect params for upstream rotation if policy says it's due, nil otherwise
+func RotationParams(State *state.AppState, Now time.Time) (*ipc.ConnectCommandRequest, error) {
+	if State.Rotation == nil || State.UpstreamProvider == nil {
+		return nil, nil
+	}
+
+	// Only rotate live connections
+	if State.UpstreamProvider.Server == nil || State.UpstreamProvider.ActiveSince == nil {
+		return nil, nil
+	}
+
+	if !State.Rotation.IsDue(time.Unix(*State.UpstreamProvider.ActiveSince, 0), Now) {
+		return nil, nil
+	}
+
+	params := ipc.ConnectCommandRequest{
+		PreserveKeys: !State.Rotation.RotateKeys,
+	}
+
+	// Stay within allowed locations, if there are any
+	if len(State.Rotation.Locations) > 0 {
+		if UpstreamCacheIsBad(State) {
+			if err := UpdateUpstreamServers(State); err != nil {
+				return nil, fmt.Errorf("rotation needs fresh servers, but update has failed: %s", err)
+			}
+		}
+
+		available := []string{}
+
+		for _, location := range State.Rotation.Locations {
+			if IsAllowedLocation(State, location) {
+				available = append(available, location)
+			}
+		}
+
+		if len(available) == 0 {
+			return nil, errors.New("none of rotation locations are available")
+		}
+
+		params.LocationOverride = providers.GetRandomElement(available)
+	}
+
+	return &params, nil
+}
+
+// Rotate upstream connection using params from RotationParams. Will be called
+// periodically by the server, which refreshes server latency in between.
+// Returns true if rotation has been performed
+func RotateUpstream(State *state.AppState, Now time.Time, Params *ipc.ConnectCommandRequest) (bool, error) {
+	var reply interface{}
+
+	// Policy or connection could be changed in the meantime
+	if due, err := RotationParams(State, Now); due == nil || err != nil {
+		return false, err
+	}
+
+	handler := IpcHandler{}
+
+	if err := handler.Connect(State, Params, &reply); err != nil {
+		return false, err
+	}
+
+	return true, nil
+}
+
+// Display or update upstream rotation policy
+func (h *IpcHandler) ManageSchedule(State *state.AppState, Params *ipc.ScheduleCommandRequest, Reply *interface{}) error {
+	if Params.Enable && Params.Disable {
+		return errors.New("rotation cannot be enabled and disabled at the same time")
+	}
+
+	// Revert to config file settings
+	if Params.Reset {
+		policy := State.Config.Rotation
+		State.Rotation = &policy
+		State.RotationOverride = false
+	}
+
+	if State.Rotation == nil {
+		State.Rotation = &schedule.Policy{}
+	}
+
+	// Work on a copy, so invalid settings are not applied partially
+	policy := *State.Rotation
+
+	if Params.Enable {
+		policy.Enabled = true
+	}
+
+	if Params.Disable {
+		policy.Enabled = false
+	}
+
+	if Params.Interval != nil {
+		interval, err := time.ParseDuration(*Params.Interval)
+
+		if err != nil {
+			return fmt.Errorf("invalid interval '%s'", *Params.Interval)
+		}
+
+		policy.Interval = int64(interval / time.Second)
+	}
+
+	if Params.Window != nil {
+		if strings.ToLower(*Params.Window) == ScheduleAnyValue {
+			policy.Window = ""
+		} else {
+			policy.Window = *Params.Window
+		}
+	}
+
+	if Params.Locations != nil {
+		if strings.ToLower(*Params.Locations) == ScheduleAnyValue {
+			policy.Locations = []string{}
+		} else {
+			policy.Locations = schedule.ParseLocations(*Params.Locations)
+
+			// Locations can only be checked if servers are known
+			if State.Servers != nil {
+				for _, location := range policy.Locations {
+					if !IsValidLocation(State, location) {
+						return fmt.Errorf("location '%s' is not found", location)
+					}
+				}
+			}
+		}
+	}
+
+	if Params.RotateKeys != nil {
+		policy.RotateKeys = *Params.RotateKeys
+	}
+
+	if err := policy.Validate(); err != nil {
+		return err
+	}
+
+	State.Rotation = &policy
+
+	// Policy changed by user is kept over config file settings from now on
+	if Params.Enable || Params.Disable || Params.Interval != nil || Params.Window != nil || Params.Locations != nil || Params.RotateKeys != nil {
+		State.RotationOverride = true
+	}
+
+	reply := ipc.ScheduleCommandReply{
+		Enabled:      policy.Enabled,
+		Interval:     (time.Duration(policy.Interval) * time.Second).String(),
+		Window:       stringOrNil(policy.Window),
+		Locations:    policy.Locations,
+		RotateKeys:   policy.RotateKeys,
+		NextRotation: NextRotation(State),
+	}
+
+	*Reply = reply
+
+	return nil
+}
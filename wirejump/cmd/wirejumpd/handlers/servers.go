@@ -3,15 +3,24 @@ package handlers
 import (
 	"errors"
 	"fmt"
+	"log"
+	"maps"
+	"slices"
+	"sort"
+	"strings"
 	"time"
 	"wirejump/internal/ipc"
 	"wirejump/internal/providers"
+	"wirejump/internal/schedule"
 	"wirejump/internal/state"
 )
 
 // Cache upstream servers for up to this much seconds
 const ServersCacheTime = 3600
 
+// Special list value for exclusions and filters, which clears the list
+const ExcludeNoneValue = "none"
+
 // Update available upstream servers
 func UpdateUpstreamServers(State *state.AppState) error {
 	servers, err := State.UpstreamProvider.Provider.GetAllServers()
@@ -23,7 +32,7 @@ func UpdateUpstreamServers(State *state.AppState) error {
 		available := make(map[string][]providers.WireguardServer)
 
 		for _, serv := range servers {
-			available[serv.Country] = append(available[serv.Country], serv)
+			available[serv.CountryCode()] = append(available[serv.CountryCode()], serv)
 		}
 
 		new_state.Available = available
@@ -33,9 +42,17 @@ func UpdateUpstreamServers(State *state.AppState) error {
 			new_state.Locations = append(new_state.Locations, country)
 		}
 
+		sort.Strings(new_state.Locations)
+
 		// Record last refresh timestamp and provider
 		new_state.LastRefresh = time.Now().Unix()
-		new_state.ProvidedBy = State.UpstreamProvider.Provider.ProviderName
+		new_state.ProvidedBy = State.UpstreamProvider.Provider.Details().ProviderName
+
+		// Latency scores and usage history are still valid for the same provider
+		if State.Servers != nil && State.Servers.ProvidedBy == new_state.ProvidedBy {
+			new_state.Latency = State.Servers.Latency
+			new_state.LastUsed = State.Servers.LastUsed
+		}
 
 		// Finally, update the state
 		State.Servers = &new_state
@@ -50,28 +67,411 @@ func UpstreamCacheIsBad(State *state.AppState) bool {
 		return true
 	}
 
-	status := State.UpstreamProvider.Provider.ProviderName != State.Servers.ProvidedBy ||
+	status := State.UpstreamProvider.Provider.Details().ProviderName != State.Servers.ProvidedBy ||
 		(time.Now().Unix()-State.Servers.LastRefresh > ServersCacheTime)
 
 	return status
 }
 
-// Checks if location is valid
+// Checks if location is valid: it's either a country, a city
+// or a server hostname, see providers.WireguardServer.InLocation
 func IsValidLocation(State *state.AppState, Location string) bool {
-	found := false
-
 	if State.Servers == nil {
 		return false
 	}
 
-	// Iterate all locations
-	for _, location := range State.Servers.Locations {
-		if location == Location {
-			found = true
+	return len(State.Servers.Match(Location)) > 0
+}
+
+// Checks if location has any servers left after exclusions
+func IsAllowedLocation(State *state.AppState, Location string) bool {
+	if State.Servers == nil || State.UpstreamProvider == nil {
+		return false
+	}
+
+	return len(State.Servers.MatchAllowed(Location, State.UpstreamProvider.Excluded, ActiveServerFilter(State))) > 0
+}
+
+// Pick random country which has servers left after exclusions
+func RandomLocation(State *state.AppState) (string, error) {
+	allowed := []string{}
+
+	if State.Servers != nil {
+		for _, location := range State.Servers.Locations {
+			if IsAllowedLocation(State, location) {
+				allowed = append(allowed, location)
+			}
+		}
+	}
+
+	if random := providers.GetRandomElement(allowed); random != nil {
+		return *random, nil
+	}
+
+	return "", errors.New("no server locations available, check provider settings and exclusions")
+}
+
+// Pick location to connect to: first preferred location which has servers
+// left after exclusions, or a random one if there's none
+func PickLocation(State *state.AppState) (string, error) {
+	for _, location := range State.UpstreamProvider.PreferredLocations {
+		if IsAllowedLocation(State, location) {
+			return location, nil
+		}
+	}
+
+	if len(State.UpstreamProvider.PreferredLocations) > 0 {
+		log.Println("none of preferred locations are available, using random one")
+	}
+
+	return RandomLocation(State)
+}
+
+// Get how many fastest servers to pick from for a particular location;
+// city uses its country setting unless it has its own one
+func BestServers(State *state.AppState, Location string) int {
+	if State.UpstreamProvider == nil {
+		return 0
+	}
+
+	if best, ok := State.UpstreamProvider.BestServers[Location]; ok {
+		return best
+	}
+
+	country, _, _ := strings.Cut(Location, providers.LocationSeparator)
+
+	if best, ok := State.UpstreamProvider.BestServers[country]; ok {
+		return best
+	}
+
+	return State.UpstreamProvider.BestServers[""]
+}
+
+// Get server selection strategy name
+func SelectionStrategy(State *state.AppState) string {
+	if State.UpstreamProvider == nil || State.UpstreamProvider.Strategy == "" {
+		return providers.DefaultStrategy
+	}
+
+	return State.UpstreamProvider.Strategy
+}
+
+// Get server filter in use
+func ActiveServerFilter(State *state.AppState) providers.ServerFilter {
+	if State.UpstreamProvider == nil || State.UpstreamProvider.Filter == nil {
+		return providers.DefaultServerFilter()
+	}
+
+	return *State.UpstreamProvider.Filter
+}
+
+// Apply filter changes on top of a filter
+func updateFilter(Filter providers.ServerFilter, Options ipc.FilterOptions) providers.ServerFilter {
+	parse := func(value string) []string {
+		if strings.ToLower(value) == ExcludeNoneValue {
+			return nil
+		}
+
+		return schedule.ParseLocations(value)
+	}
+
+	if Options.OnlyOwned != nil {
+		Filter.OnlyOwned = *Options.OnlyOwned
+	}
+
+	if Options.ExcludeHosting != nil {
+		Filter.ExcludeHosting = parse(*Options.ExcludeHosting)
+	}
+
+	if Options.RequireFeatures != nil {
+		Filter.RequireFeatures = parse(*Options.RequireFeatures)
+	}
+
+	return Filter
+}
+
+// Check if any filter changes are requested
+func filterChanged(Options ipc.FilterOptions) bool {
+	return Options.OnlyOwned != nil || Options.ExcludeHosting != nil || Options.RequireFeatures != nil
+}
+
+// Select new server with a given strategy. Excluded locations are never
+// used, filter and the fastest servers to consider are set by caller;
+// latency should be refreshed beforehand, see RefreshLatency
+func SelectUpstream(State *state.AppState, Strategy string, Params providers.SelectionParams) (providers.WireguardServer, error) {
+	strategy, err := providers.NewStrategy(Strategy, nil)
+
+	if err != nil {
+		return providers.WireguardServer{}, err
+	}
+
+	Params.Excluded = State.UpstreamProvider.Excluded
+
+	return providers.SelectServer(State.Servers, strategy, Params)
+}
+
+// Describe server selection settings
+func selectionInfo(State *state.AppState) []string {
+	describe := func(best int) string {
+		if best > 0 {
+			return fmt.Sprintf("best %d by latency", best)
+		}
+
+		return "random"
+	}
+
+	info := []string{
+		"strategy: " + SelectionStrategy(State),
+		"filter: " + ActiveServerFilter(State).String(),
+		"default: " + describe(State.UpstreamProvider.BestServers[""]),
+	}
+	locations := []string{}
+
+	for location := range State.UpstreamProvider.BestServers {
+		if location != "" {
+			locations = append(locations, location)
+		}
+	}
+
+	sort.Strings(locations)
+
+	for _, location := range locations {
+		info = append(info, location+": "+describe(State.UpstreamProvider.BestServers[location]))
+	}
+
+	return info
+}
+
+// Update how many fastest servers to pick from for a particular
+// location, or the default one if location is empty
+func setBestServers(State *state.AppState, Location string, Best *int, Reset bool) error {
+	if Location != "" && !IsValidLocation(State, Location) {
+		return fmt.Errorf("location '%s' is not found", Location)
+	}
+
+	if State.UpstreamProvider.BestServers == nil {
+		State.UpstreamProvider.BestServers = make(map[string]int)
+	}
+
+	if Reset {
+		delete(State.UpstreamProvider.BestServers, Location)
+
+		return nil
+	}
+
+	if *Best < 0 {
+		return errors.New("number of best servers can not be negative")
+	}
+
+	State.UpstreamProvider.BestServers[Location] = *Best
+
+	return nil
+}
+
+// Update preferred and excluded locations, both comma separated. Exclusions
+// are checked first, so preferred locations can't be excluded completely;
+// nothing is changed if any of the locations is not valid
+func setLocations(State *state.AppState, Preferred string, Exclude *string) error {
+	excluded := State.UpstreamProvider.Excluded
+
+	if Exclude != nil {
+		excluded = nil
+
+		if strings.ToLower(*Exclude) == ExcludeNoneValue {
+			*Exclude = ""
+		}
+
+		for _, location := range schedule.ParseLocations(*Exclude) {
+			if !IsValidLocation(State, location) {
+				return fmt.Errorf("location '%s' is not found", location)
+			}
+
+			excluded = append(excluded, location)
+		}
+
+		allowed := false
+
+		for _, location := range State.Servers.Locations {
+			if len(State.Servers.MatchAllowed(location, excluded, ActiveServerFilter(State))) > 0 {
+				allowed = true
+				break
+			}
+		}
+
+		if !allowed {
+			return errors.New("all servers would be excluded")
+		}
+	}
+
+	preferred := State.UpstreamProvider.PreferredLocations
+
+	if Preferred != "" {
+		preferred = nil
+
+		for _, location := range schedule.ParseLocations(Preferred) {
+			if !IsValidLocation(State, location) {
+				return fmt.Errorf("location '%s' is not found", location)
+			}
+
+			if len(State.Servers.MatchAllowed(location, excluded, ActiveServerFilter(State))) == 0 {
+				return fmt.Errorf("location '%s' is excluded", location)
+			}
+
+			preferred = append(preferred, location)
+		}
+	}
+
+	State.UpstreamProvider.Excluded = excluded
+	State.UpstreamProvider.PreferredLocations = preferred
+
+	return nil
+}
+
+// List servers for a particular location by cached latency, fastest
+// first; unreachable and unmeasured servers go last
+func latencyInfo(State *state.AppState, Location string) ipc.ServersLatencyReply {
+	reply := ipc.ServersLatencyReply{}
+	listed := make(map[string]bool)
+
+	for _, server := range providers.FastestServers(State.Servers, Location) {
+		score, _ := State.Servers.LatencyOf(server)
+		checked := score.Checked
+
+		reply = append(reply, ipc.ServerLatency{
+			Hostname: server.Hostname,
+			City:     server.City,
+			Latency:  fmt.Sprintf("%.1f ms", float64(score.RTT)/float64(time.Millisecond)),
+			Checked:  &checked,
+		})
+
+		listed[server.Hostname] = true
+	}
+
+	for _, server := range State.Servers.Match(Location) {
+		if listed[server.Hostname] {
+			continue
+		}
+
+		entry := ipc.ServerLatency{
+			Hostname: server.Hostname,
+			City:     server.City,
+			Latency:  "unknown",
+		}
+
+		if score, ok := State.Servers.LatencyOf(server); ok {
+			entry.Latency = "unreachable"
+			entry.Checked = &score.Checked
+		}
+
+		reply = append(reply, entry)
+	}
+
+	return reply
+}
+
+// Build location tree: countries, their cities and servers, optionally
+// limited to a particular location
+func locationTree(State *state.AppState, Location string) ipc.ServersTreeReply {
+	tree := ipc.ServersTreeReply{}
+
+	for _, country := range State.Servers.Locations {
+		servers := State.Servers.Match(country)
+
+		if Location != "" {
+			servers = slices.DeleteFunc(servers, func(server providers.WireguardServer) bool {
+				return !server.InLocation(Location)
+			})
+		}
+
+		if len(servers) == 0 {
+			continue
+		}
+
+		country_node := ipc.LocationNode{Location: country, Name: servers[0].Country}
+		cities := make(map[string]int)
+
+		for _, server := range servers {
+			city := server.CityLocation()
+			index, ok := cities[city]
+
+			if !ok {
+				index = len(country_node.Children)
+				cities[city] = index
+				country_node.Children = append(country_node.Children, ipc.LocationNode{Location: city, Name: server.City})
+			}
+
+			city_node := &country_node.Children[index]
+			city_node.Children = append(city_node.Children, ipc.LocationNode{Location: server.Hostname, Name: server.Attributes()})
+		}
+
+		sort.Slice(country_node.Children, func(i, j int) bool {
+			return country_node.Children[i].Location < country_node.Children[j].Location
+		})
+
+		tree = append(tree, country_node)
+	}
+
+	return tree
+}
+
+// Update server selection settings: strategy, best servers, filter and
+// locations. They are changed on a copy of provider state, which replaces
+// current one only if all of them are valid. Returns whether anything has
+// been requested
+func updateSelection(State *state.AppState, Params *ipc.ServersCommandRequest) (bool, error) {
+	original := State.UpstreamProvider
+	updated := *original
+	updated.BestServers = maps.Clone(original.BestServers)
+
+	// Settings are checked against the state, so the copy is put in place
+	State.UpstreamProvider = &updated
+	defer func() { State.UpstreamProvider = original }()
+
+	changed := false
+
+	// Check if server selection has been changed
+	if Params.Strategy != "" {
+		if _, err := providers.NewStrategy(Params.Strategy, nil); err != nil {
+			return false, err
+		}
+
+		updated.Strategy = Params.Strategy
+		changed = true
+	}
+
+	if Params.BestServers != nil || Params.ResetBest {
+		if err := setBestServers(State, Params.Location, Params.BestServers, Params.ResetBest); err != nil {
+			return false, err
 		}
+
+		changed = true
 	}
 
-	return found
+	// Filter goes first, so locations are checked against it
+	if filterChanged(Params.Filter) {
+		filter := updateFilter(ActiveServerFilter(State), Params.Filter)
+		updated.Filter = &filter
+		changed = true
+
+		if _, err := RandomLocation(State); err != nil {
+			return false, errors.New("all servers would be filtered out")
+		}
+	}
+
+	// Check if preferred or excluded locations have been provided
+	if Params.Preferred != "" || Params.Exclude != nil {
+		if err := setLocations(State, Params.Preferred, Params.Exclude); err != nil {
+			return false, err
+		}
+
+		changed = true
+	}
+
+	if changed {
+		*original = updated
+	}
+
+	return changed, nil
 }
 
 // Display available server locations from the list of servers for
@@ -81,13 +481,13 @@ func (h *IpcHandler) ManageServers(State *state.AppState, Params *ipc.ServersCom
 	if State.UpstreamProvider == nil {
 		return errors.New("no provider selected, setup one first")
 	} else {
-		if !State.UpstreamProvider.Provider.Initialized {
+		if !State.UpstreamProvider.Provider.Details().Initialized {
 			return errors.New("provider is set but not initialized")
 		}
 
 		// Check if location has been reset
 		if Params.Reset {
-			State.UpstreamProvider.PreferredLocation = nil
+			State.UpstreamProvider.PreferredLocations = nil
 			*Reply = nil
 
 			return nil
@@ -108,22 +508,48 @@ func (h *IpcHandler) ManageServers(State *state.AppState, Params *ipc.ServersCom
 			return errors.New("servers are still not updated")
 		}
 
-		// Check if a location has been provided
-		if Params.Preferred != "" {
-			if !IsValidLocation(State, Params.Preferred) {
-				return fmt.Errorf("location '%s' is not found", Params.Preferred)
+		// Show server latency for a location, preferred one by default
+		if Params.Latency {
+			location := latencyLocation(State, Params.Location)
+
+			if !IsValidLocation(State, location) {
+				return fmt.Errorf("location '%s' is not found", location)
 			}
 
-			State.UpstreamProvider.PreferredLocation = &Params.Preferred
+			*Reply = latencyInfo(State, location)
+
+			return nil
+		}
+
+		// Show location tree, for a single location if it's given
+		if Params.Tree {
+			if Params.Location != "" && !IsValidLocation(State, Params.Location) {
+				return fmt.Errorf("location '%s' is not found", Params.Location)
+			}
+
+			*Reply = locationTree(State, Params.Location)
+
+			return nil
+		}
+
+		changed, err := updateSelection(State, Params)
+
+		if err != nil {
+			return err
+		}
+
+		if changed {
 			*Reply = nil
 
 			return nil
 		}
 
 		*Reply = ipc.ServersCommandReply{
-			Servers:           State.Servers.Locations,
-			LastUpdated:       &State.Servers.LastRefresh,
-			PreferredLocation: State.UpstreamProvider.PreferredLocation,
+			Servers:            State.Servers.Locations,
+			LastUpdated:        &State.Servers.LastRefresh,
+			PreferredLocations: State.UpstreamProvider.PreferredLocations,
+			Excluded:           State.UpstreamProvider.Excluded,
+			Selection:          selectionInfo(State),
 		}
 
 		return nil
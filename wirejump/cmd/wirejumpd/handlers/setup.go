@@ -36,17 +36,13 @@ func (h *IpcHandler) SetupProvider(State *state.AppState, Params *ipc.SetupComma
 
 	// Redirect to reset
 	if State.UpstreamProvider != nil {
-		return fmt.Errorf("provider '%s' is already selected, run 'reset' first", State.UpstreamProvider.Provider.ProviderName)
+		return fmt.Errorf("provider '%s' is already selected, run 'reset' first", State.UpstreamProvider.Provider.Details().ProviderName)
 	}
 
 	// Select new provider from the list and validate it
 	if initializer, exists := State.AvailableProviders.Available[Params.Provider]; !exists {
 		return fmt.Errorf("provider '%s' does not exist", Params.Provider)
 	} else {
-		if Params.Provider != "mullvad" && len(Params.Password) == 0 {
-			return errors.New("this provider requires a password")
-		}
-
 		provider, err := initializer(providers.WireguardProviderAccount{
 			AccountID: Params.Username,
 			Password:  Params.Password,
@@ -55,26 +51,30 @@ func (h *IpcHandler) SetupProvider(State *state.AppState, Params *ipc.SetupComma
 		if err != nil {
 			return fmt.Errorf("failed to initialize provider: %s", err)
 		} else {
+			details := provider.Details()
+
 			// Try the account right away to ensure its validity
 			if acc, err := provider.GetAccountInfo(); err != nil {
 				return fmt.Errorf("failed to verify provider account: %s", err)
+			} else if !acc.CanAddDevices {
+				return errors.New("this account can not add new devices")
 			} else {
 				// Check upstream gateway...
-				if !network.IsValidIP(provider.UpstreamGateway) {
+				if !network.IsValidIP(details.UpstreamGateway) {
 					return errors.New("upstream gateway IP address is invalid")
 				}
 
 				// ...and write it down
-				if err := State.Network.Upstream.UpdateDefaultGateway(provider.UpstreamGateway); err != nil {
+				if err := State.Network.Upstream.UpdateDefaultGateway(details.UpstreamGateway); err != nil {
 					return fmt.Errorf("failed to update upstream gateway: %s", err)
 				}
 
 				// Update account validity
-				provider.ValidUntil = acc.Expires
+				details.ValidUntil = acc.Expires
 
 				// Finally, update upstream state
 				State.UpstreamProvider = &providers.ProviderState{
-					Provider: &provider,
+					Provider: provider,
 				}
 			}
 		}
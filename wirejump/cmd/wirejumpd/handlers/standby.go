@@ -0,0 +1,370 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"time"
+	"wirejump/internal/ipc"
+	"wirejump/internal/network"
+	"wirejump/internal/providers"
+	"wirejump/internal/state"
+)
+
+// Standby upstream is a second connection to another relay, which is kept
+// up but carries no traffic. When main upstream fails, standby interface
+// takes over upstream routing table by its promote script action, which
+// takes a moment, unlike rebuilding main upstream connection. Standby is
+// one more device on the provider account, same as an exit group.
+
+// Standby interface takes slot 0, exit groups start from 1
+const standbySlot = 0
+
+// Standby interface name is upstream name with slot number appended
+func StandbyInterfaceName(State *state.AppState) string {
+	return fmt.Sprintf("%s%d", State.Config.UpstreamName, standbySlot)
+}
+
+// Get standby interface, creating it if needed
+func standbyInterface(State *state.AppState) (*network.InterfaceConfig, error) {
+	if State.Network.Standby != nil {
+		return State.Network.Standby, nil
+	}
+
+	iface, err := network.CreateInterface(StandbyInterfaceName(State), network.InterfaceKindStandby)
+
+	if err != nil {
+		return nil, err
+	}
+
+	State.Network.Standby = &iface
+
+	return State.Network.Standby, nil
+}
+
+// Check if standby is connected and can take over right now
+func StandbyReady(State *state.AppState) bool {
+	if State.Standby == nil || State.Standby.Server == nil || State.Network.Standby == nil {
+		return false
+	}
+
+	active, err := State.Network.Standby.IsActive()
+
+	return active && err == nil
+}
+
+// Get standby location: its own one, then main upstream preferred one
+func standbyLocation(State *state.AppState) (string, error) {
+	if State.Standby.Location != nil {
+		return *State.Standby.Location, nil
+	}
+
+	// Preferred location can be a single server, which is taken by main upstream
+	for _, preferred := range State.UpstreamProvider.PreferredLocations {
+		if len(State.Servers.MatchAllowed(preferred, State.UpstreamProvider.Excluded, ActiveServerFilter(State))) > 1 {
+			return preferred, nil
+		}
+	}
+
+	// Main upstream could have been connected to a random location
+	if State.UpstreamProvider.Server != nil {
+		return State.UpstreamProvider.Server.CountryCode(), nil
+	}
+
+	return RandomLocation(State)
+}
+
+// Bring standby interface down. If standby carries traffic at the moment,
+// its script removes upstream routes, so killswitch stops downstream traffic
+func DisconnectStandby(State *state.AppState) error {
+	if State.Standby == nil {
+		return nil
+	}
+
+	iface, err := standbyInterface(State)
+
+	if err != nil {
+		return err
+	}
+
+	// Same as for main upstream, missing interface is a down one
+	if active, err := iface.IsActive(); active && err == nil {
+		if err := iface.BringDown(); err != nil {
+			return err
+		}
+	}
+
+	State.Standby.Server = nil
+	State.Standby.ActiveSince = nil
+	State.Standby.Promoted = false
+
+	return nil
+}
+
+// Connect standby to a new server, which is not the one main upstream uses
+func ConnectStandby(State *state.AppState) error {
+	if State.Standby == nil {
+		return errors.New("standby upstream is not enabled")
+	}
+
+	if State.UpstreamProvider == nil || !State.UpstreamProvider.Provider.Details().Initialized {
+		return errors.New("setup a provider first")
+	}
+
+	if SpareDevices(State) == 0 {
+		return errors.New("provider allows a single device only, standby upstream is not supported")
+	}
+
+	if State.Standby.Promoted {
+		return errors.New("standby upstream carries traffic right now, reconnect main upstream first")
+	}
+
+	iface, err := standbyInterface(State)
+
+	if err != nil {
+		return err
+	}
+
+	if UpstreamCacheIsBad(State) {
+		if err := UpdateUpstreamServers(State); err != nil {
+			return fmt.Errorf("connect needs fresh servers, but update has failed: %s", err)
+		}
+	}
+
+	location, err := standbyLocation(State)
+
+	if err != nil {
+		return err
+	}
+
+	server, err := providers.GuessStandbyUpstream(State.Servers, State.UpstreamProvider.Server, location, State.UpstreamProvider.Excluded, ActiveServerFilter(State))
+
+	if err != nil {
+		return fmt.Errorf("unable to guess standby upstream: %s", err)
+	}
+
+	if err := DisconnectStandby(State); err != nil {
+		return fmt.Errorf("failed to shutdown existing standby connection: %s", err)
+	}
+
+	if err := ConnectInterface(State, iface, server, false); err != nil {
+		return err
+	}
+
+	t := time.Now().Unix()
+	State.Standby.ActiveSince = &t
+	State.Standby.Server = &server
+
+	return nil
+}
+
+// Tear standby down completely: disconnect it, remove its key from
+// the account and remove its config. Errors are logged, so that as
+// much as possible is cleaned up
+func cleanupStandby(State *state.AppState) {
+	iface, err := standbyInterface(State)
+
+	if err != nil {
+		log.Println("failed to cleanup standby upstream:", err)
+
+		return
+	}
+
+	if err := DisconnectStandby(State); err != nil {
+		log.Println("failed to disconnect standby upstream:", err)
+	}
+
+	if State.UpstreamProvider != nil && iface.PublicKey != "" {
+		if err := State.UpstreamProvider.Provider.RemovePubkey(iface.PublicKey); err != nil {
+			log.Println("failed to remove standby upstream pubkey:", err)
+		}
+	}
+
+	if config, err := iface.GetInterfaceConfigPath(); err == nil {
+		os.Remove(config)
+	}
+
+	State.Network.Standby = nil
+}
+
+// Enable standby upstream and connect it
+func EnableStandby(State *state.AppState, Location string) error {
+	if State.UpstreamProvider == nil {
+		return errors.New("setup a provider first")
+	}
+
+	if State.Standby != nil {
+		return errors.New("standby upstream is already enabled")
+	}
+
+	// Nothing would move traffic to standby otherwise
+	if !State.Config.Supervisor.Enabled {
+		return errors.New("standby upstream needs supervisor, enable it in 'Supervisor' section of wirejumpd config first")
+	}
+
+	// Another key would replace main upstream one
+	if SpareDevices(State) == 0 {
+		return fmt.Errorf("provider '%s' allows a single device only, standby upstream is not supported", State.UpstreamProvider.Provider.Details().ProviderName)
+	}
+
+	// Standby takes one of the devices exit groups could use
+	if len(State.Exits) >= SpareDevices(State) {
+		return fmt.Errorf("all %d spare devices are used by exit groups, remove one of them first", SpareDevices(State))
+	}
+
+	State.Standby = &state.StandbyUpstream{}
+
+	if Location != "" {
+		if !IsValidLocation(State, Location) {
+			State.Standby = nil
+
+			return fmt.Errorf("location '%s' is not found", Location)
+		}
+
+		State.Standby.Location = &Location
+	}
+
+	if err := ConnectStandby(State); err != nil {
+		cleanupStandby(State)
+		State.Standby = nil
+
+		return err
+	}
+
+	return nil
+}
+
+// Disable standby upstream and remove it completely
+func DisableStandby(State *state.AppState) {
+	if State.Standby == nil {
+		return
+	}
+
+	cleanupStandby(State)
+	State.Standby = nil
+}
+
+// Move downstream traffic to standby upstream
+func PromoteStandby(State *state.AppState) error {
+	if !StandbyReady(State) {
+		return errors.New("standby upstream is not connected")
+	}
+
+	if State.Standby.Promoted {
+		return nil
+	}
+
+	if err := State.Network.Standby.RunScript(network.InterfaceScriptKindPromote); err != nil {
+		return fmt.Errorf("cannot move traffic to standby upstream: %s", err)
+	}
+
+	State.Standby.Promoted = true
+
+	return nil
+}
+
+// Stop sending downstream traffic via standby upstream; nothing is routed
+// via upstream table afterwards, until main upstream is brought up
+func DemoteStandby(State *state.AppState) error {
+	if State.Standby == nil || !State.Standby.Promoted || State.Network.Standby == nil {
+		return nil
+	}
+
+	if err := State.Network.Standby.RunScript(network.InterfaceScriptKindDemote); err != nil {
+		return fmt.Errorf("cannot stop traffic via standby upstream: %s", err)
+	}
+
+	State.Standby.Promoted = false
+
+	return nil
+}
+
+// Get standby upstream status
+func StandbyStatus(State *state.AppState) ipc.StandbyStatus {
+	status := ipc.StandbyStatus{}
+
+	if State.Standby == nil {
+		return status
+	}
+
+	status.Enabled = true
+	status.Promoted = State.Standby.Promoted
+	status.Location = State.Standby.Location
+	status.ActiveSince = State.Standby.ActiveSince
+
+	if State.Network.Standby != nil {
+		status.Online, _ = State.Network.Standby.IsActive()
+	}
+
+	if State.Standby.Server != nil {
+		status.Country = stringOrNil(State.Standby.Server.Country)
+		status.City = stringOrNil(State.Standby.Server.City)
+	}
+
+	return status
+}
+
+// Manage standby upstream; shows its status if nothing is requested
+func (h *IpcHandler) ManageStandby(State *state.AppState, Params *ipc.StandbyCommandRequest, Reply *interface{}) error {
+	operations := 0
+
+	for _, set := range []bool{Params.Enable, Params.Disable, Params.Connect, Params.Failover} {
+		if set {
+			operations++
+		}
+	}
+
+	if operations > 1 {
+		return errors.New("only one standby operation can be requested at once")
+	}
+
+	location := ""
+
+	if Params.Location != nil {
+		location = strings.TrimSpace(*Params.Location)
+	}
+
+	if location != "" && !Params.Enable && !Params.Connect {
+		return errors.New("location can only be set on enable or connect")
+	}
+
+	switch {
+	case Params.Enable:
+		if err := EnableStandby(State, location); err != nil {
+			return err
+		}
+	case Params.Disable:
+		if State.Standby == nil {
+			return errors.New("standby upstream is not enabled")
+		}
+
+		DisableStandby(State)
+	case Params.Connect:
+		if State.Standby == nil {
+			return errors.New("standby upstream is not enabled")
+		}
+
+		// Location is changed for good, same as preferred location
+		if location != "" {
+			if !IsValidLocation(State, location) {
+				return fmt.Errorf("location '%s' is not found", location)
+			}
+
+			State.Standby.Location = &location
+		}
+
+		if err := ConnectStandby(State); err != nil {
+			return err
+		}
+	case Params.Failover:
+		if err := PromoteStandby(State); err != nil {
+			return err
+		}
+	}
+
+	*Reply = StandbyStatus(State)
+
+	return nil
+}
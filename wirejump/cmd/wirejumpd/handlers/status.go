@@ -1,6 +1,7 @@
 package handlers
 
 import (
+	"time"
 	"wirejump/internal/ipc"
 	"wirejump/internal/state"
 )
@@ -24,18 +25,19 @@ func (h *IpcHandler) Status(State *state.AppState, Params *ipc.StatusCommandRequ
 
 	// Create initial provider info
 	provider := ipc.ProviderStatus{
-		Name: stringOrNil(State.UpstreamProvider.Provider.ProviderName),
+		Name: stringOrNil(State.UpstreamProvider.Provider.Details().ProviderName),
 	}
 
 	// Fill preferred location
-	provider.PreferredLocation = State.UpstreamProvider.PreferredLocation
+	provider.PreferredLocations = State.UpstreamProvider.PreferredLocations
 
 	// Get account expiration date
-	expires := State.UpstreamProvider.Provider.ValidUntil
+	expires := State.UpstreamProvider.Provider.Details().ValidUntil
 
 	// In case expiration date is available
 	if expires != 0 {
 		provider.AccountExpires = &expires
+		provider.AccountState = stringOrNil(AccountState(State, time.Now()))
 	}
 
 	// Construct initial upstream status
@@ -56,10 +58,18 @@ func (h *IpcHandler) Status(State *state.AppState, Params *ipc.StatusCommandRequ
 		upstream.Country = stringOrNil(State.UpstreamProvider.Server.Country)
 	}
 
+	// Scheduled rotation details
+	rotation := ipc.RotationStatus{
+		Enabled:      State.Rotation != nil && State.Rotation.Enabled,
+		NextRotation: NextRotation(State),
+	}
+
 	// Create reply
 	*Reply = ipc.StatusCommandReply{
 		Upstream: upstream,
 		Provider: provider,
+		Rotation: rotation,
+		Standby:  StandbyStatus(State),
 	}
 
 	return nil
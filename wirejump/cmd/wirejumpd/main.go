@@ -101,6 +101,26 @@ func main() {
 	fmt.Println("Starting WireJump server...")
 	fmt.Println(version.VersionString())
 
+	// Start upstream supervisor if needed
+	if configState.Supervisor.Enabled {
+		supervisor := Supervisor{Config: configState.Supervisor}
+
+		go supervisor.Run(ctx)
+	}
+
+	// Rotation can be enabled at any time, so always run it
+	go RunRotation(ctx)
+
+	// Start provider account checks if needed
+	if configState.Account.Enabled {
+		go NewAccountMonitor(configState.Account).Run(ctx)
+	}
+
+	// Start metrics listener if needed
+	if configState.Metrics.Enabled {
+		go RunMetrics(ctx, configState.Metrics)
+	}
+
 	if err := startServer(ctx); err != nil {
 		ErrorExit(err)
 	}
@@ -0,0 +1,152 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+	"wirejump/internal/metrics"
+	"wirejump/internal/network"
+	"wirejump/internal/state"
+)
+
+// Listen on localhost by default, so metrics are not exposed accidentally
+const DefaultMetricsListen = "127.0.0.1:9586"
+
+// Metrics path, same as everyone else uses
+const metricsPath = "/metrics"
+
+// State metrics, collected on each scrape
+const (
+	upstreamUpMetric        = "wirejump_upstream_up"
+	upstreamHandshakeMetric = "wirejump_upstream_handshake_age_seconds"
+	upstreamRxMetric        = "wirejump_upstream_receive_bytes_total"
+	upstreamTxMetric        = "wirejump_upstream_transmit_bytes_total"
+	peerHandshakeMetric     = "wirejump_peer_handshake_age_seconds"
+	peerRxMetric            = "wirejump_peer_receive_bytes_total"
+	peerTxMetric            = "wirejump_peer_transmit_bytes_total"
+	serversCacheAgeMetric   = "wirejump_servers_cache_age_seconds"
+	accountExpiryMetric     = "wirejump_account_expiry_timestamp_seconds"
+)
+
+func init() {
+	metrics.Register(upstreamUpMetric, metrics.KindGauge, "Whether upstream interface is up")
+	metrics.Register(upstreamHandshakeMetric, metrics.KindGauge, "Time since latest upstream handshake")
+	metrics.Register(upstreamRxMetric, metrics.KindCounter, "Bytes received from upstream")
+	metrics.Register(upstreamTxMetric, metrics.KindCounter, "Bytes sent to upstream")
+	metrics.Register(peerHandshakeMetric, metrics.KindGauge, "Time since latest downstream peer handshake")
+	metrics.Register(peerRxMetric, metrics.KindCounter, "Bytes received from downstream peer")
+	metrics.Register(peerTxMetric, metrics.KindCounter, "Bytes sent to downstream peer")
+	metrics.Register(serversCacheAgeMetric, metrics.KindGauge, "Time since upstream servers have been updated")
+	metrics.Register(accountExpiryMetric, metrics.KindGauge, "Provider account expiration time")
+}
+
+// Update metrics, which reflect current app state
+func CollectStateMetrics(State *state.AppState, Now time.Time) {
+	metrics.Reset(upstreamHandshakeMetric)
+	metrics.Reset(upstreamRxMetric)
+	metrics.Reset(upstreamTxMetric)
+	metrics.Reset(peerHandshakeMetric)
+	metrics.Reset(peerRxMetric)
+	metrics.Reset(peerTxMetric)
+	metrics.Reset(serversCacheAgeMetric)
+	metrics.Reset(accountExpiryMetric)
+
+	up := 0.0
+
+	if State.Network.Upstream != nil {
+		if active, _ := State.Network.Upstream.IsActive(); active {
+			up = 1
+		}
+
+		if up == 1 {
+			if peers, err := State.Network.Upstream.GetPeers(); err == nil {
+				for _, peer := range peers {
+					collectPeerMetrics(peer, nil, upstreamHandshakeMetric, upstreamRxMetric, upstreamTxMetric, Now)
+				}
+			}
+		}
+	}
+
+	metrics.Set(upstreamUpMetric, nil, up)
+
+	if State.Network.Downstream != nil {
+		if peers, err := State.Network.Downstream.GetPeers(); err == nil {
+			for _, peer := range peers {
+				labels := metrics.Labels{"peer": peer.PublicKey}
+
+				collectPeerMetrics(peer, labels, peerHandshakeMetric, peerRxMetric, peerTxMetric, Now)
+			}
+		}
+	}
+
+	if State.Servers != nil && State.Servers.LastRefresh != 0 {
+		metrics.Set(serversCacheAgeMetric, nil, float64(Now.Unix()-State.Servers.LastRefresh))
+	}
+
+	if State.UpstreamProvider != nil && State.UpstreamProvider.Provider != nil {
+		if expires := State.UpstreamProvider.Provider.Details().ValidUntil; expires != 0 {
+			metrics.Set(accountExpiryMetric, nil, float64(expires))
+		}
+	}
+}
+
+// Update handshake and traffic metrics of a single peer
+func collectPeerMetrics(peer network.PeerStatus, labels metrics.Labels, handshake string, rx string, tx string, Now time.Time) {
+	// Peer without handshake has no age
+	if peer.LatestHandshake != 0 {
+		metrics.Set(handshake, labels, float64(Now.Unix()-peer.LatestHandshake))
+	}
+
+	metrics.Set(rx, labels, float64(peer.RxBytes))
+	metrics.Set(tx, labels, float64(peer.TxBytes))
+}
+
+// Scrapes are serialized, so they never see partially collected metrics
+var scrapeMutex sync.Mutex
+
+// Serve metrics. State metrics are refreshed on each request, unless
+// state is busy: then previously collected values are served. Server
+// lock is not taken, so scrapes never make user commands fail
+func serveMetrics(w http.ResponseWriter, r *http.Request) {
+	scrapeMutex.Lock()
+	defer scrapeMutex.Unlock()
+
+	appState := state.GetStateInstance()
+
+	if appState.Mutex.TryLock() {
+		CollectStateMetrics(&appState.State, time.Now())
+		appState.Mutex.Unlock()
+	}
+
+	w.Header().Set("Content-Type", metrics.ContentType)
+
+	if err := metrics.WriteText(w); err != nil {
+		log.Println("failed to write metrics:", err)
+	}
+}
+
+// Run metrics HTTP listener until context is done
+func RunMetrics(ctx context.Context, Config state.MetricsConfig) {
+	mux := http.NewServeMux()
+	mux.HandleFunc(metricsPath, serveMetrics)
+
+	server := &http.Server{
+		Addr:              Config.Listen,
+		Handler:           mux,
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+
+	go func() {
+		<-ctx.Done()
+		server.Close()
+	}()
+
+	log.Println("serving metrics on", Config.Listen)
+
+	if err := server.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		log.Println("metrics listener has failed:", err)
+	}
+}
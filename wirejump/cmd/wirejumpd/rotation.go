@@ -0,0 +1,74 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log"
+	"time"
+	"wirejump/cmd/wirejumpd/handlers"
+	"wirejump/internal/ipc"
+	"wirejump/internal/state"
+)
+
+// Rotate upstream daily by default
+const DefaultRotationInterval = 24 * 3600
+
+// How often to check whether rotation is due
+const rotationCheckInterval = time.Minute
+
+// Don't retry failed rotation sooner than this
+const rotationRetryInterval = 10 * time.Minute
+
+// Run rotation checks until context is done. Policy itself lives in app
+// state, since it can be changed at runtime by 'schedule' command
+func RunRotation(ctx context.Context) {
+	var lastFailure time.Time
+
+	ticker := time.NewTicker(rotationCheckInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			if now.Sub(lastFailure) < rotationRetryInterval {
+				continue
+			}
+
+			var params *ipc.ConnectCommandRequest
+
+			err := ipc.LockedExec(func(State *state.AppState) error {
+				var err error
+				params, err = handlers.RotationParams(State, now)
+
+				return err
+			})
+
+			// Servers are probed without holding the lock
+			if err == nil && params != nil {
+				handlers.PrepareConnect(params)
+
+				err = ipc.LockedExec(func(State *state.AppState) error {
+					rotated, err := handlers.RotateUpstream(State, now, params)
+
+					if rotated {
+						log.Println("upstream has been rotated")
+
+						if err := state.SaveState(State); err != nil {
+							log.Println("failed to save state:", err)
+						}
+					}
+
+					return err
+				})
+			}
+
+			// Busy server will be checked again next time
+			if err != nil && !errors.Is(err, ipc.ErrLocked) {
+				log.Println("failed to rotate upstream:", err)
+				lastFailure = now
+			}
+		}
+	}
+}
@@ -4,10 +4,17 @@ import (
 	"errors"
 	"fmt"
 	"log"
+	"net"
+	"net/url"
 	"os"
+	"strconv"
+	"strings"
+	"time"
+	"wirejump/cmd/wirejumpd/handlers"
 	"wirejump/internal/cli"
 	"wirejump/internal/network"
 	"wirejump/internal/providers"
+	"wirejump/internal/schedule"
 	"wirejump/internal/state"
 	"wirejump/internal/utils"
 )
@@ -17,6 +24,8 @@ const programDesc = "WireJump connection manager daemon"
 
 var programUsage = []string{
 	"  -c, --config PATH\tUse specified config file (required, no default)",
+	"      --record-fixtures PATH\tRecord provider API responses to a directory",
+	"      --replay-fixtures PATH\tReplay provider API responses from a directory",
 }
 
 func init() {
@@ -34,6 +43,8 @@ func ErrorExit(msg ...any) {
 // Handle default program args
 func HandleProgramArgs() string {
 	var configFile string
+	var recordFixtures string
+	var replayFixtures string
 
 	// If no args are supplied
 	if len(os.Args) == 1 {
@@ -48,6 +59,8 @@ func HandleProgramArgs() string {
 	// Add required options
 	fs.StringVar(&configFile, "c", "", "config")
 	fs.StringVar(&configFile, "config", "", "config")
+	fs.StringVar(&recordFixtures, "record-fixtures", "", "record-fixtures")
+	fs.StringVar(&replayFixtures, "replay-fixtures", "", "replay-fixtures")
 
 	// Parse
 	fs.Parse(os.Args[1:])
@@ -57,6 +70,23 @@ func HandleProgramArgs() string {
 		ErrorExit("Config file is required")
 	}
 
+	// Provider API can be recorded or replayed, but not both
+	if recordFixtures != "" && replayFixtures != "" {
+		ErrorExit("Fixtures can be either recorded or replayed")
+	}
+
+	if recordFixtures != "" {
+		if err := providers.UseFixtures(recordFixtures, true); err != nil {
+			ErrorExit(err)
+		}
+	}
+
+	if replayFixtures != "" {
+		if err := providers.UseFixtures(replayFixtures, false); err != nil {
+			ErrorExit(err)
+		}
+	}
+
 	return configFile
 }
 
@@ -83,9 +113,294 @@ func ParseConfig(configFile string) (state.ConfigurationState, error) {
 		config.DownstreamName = cfg["Config"][0]["Downstream"]
 	}
 
+	// Default to wg/wg-quick tools, as they were used before
+	config.Backend = strings.TrimSpace(cfg["Config"][0]["Backend"])
+
+	if config.Backend == "" {
+		config.Backend = network.BackendExec
+	}
+
+	if err := network.SelectBackend(config.Backend); err != nil {
+		return state.ConfigurationState{}, err
+	}
+
+	// IPv6 upstream is optional, since not every server has IPv6 connectivity
+	if config.DualStack, err = configBool(cfg["Config"][0], "DualStack", false); err != nil {
+		return state.ConfigurationState{}, err
+	}
+
+	// Relays are connected via IPv4 unless told otherwise
+	switch family := strings.ToLower(strings.TrimSpace(cfg["Config"][0]["EndpointFamily"])); family {
+	case "", "ipv4":
+		config.PreferIPv6 = false
+	case "ipv6":
+		config.PreferIPv6 = true
+	default:
+		return state.ConfigurationState{}, fmt.Errorf("'EndpointFamily' should be either ipv4 or ipv6, got '%s'", family)
+	}
+
+	// Public server address for client configs, port is optional
+	config.Endpoint = strings.TrimSpace(cfg["Config"][0]["Endpoint"])
+
+	// Supervisor is optional
+	supervisor, err := ParseSupervisorConfig(cfg["Supervisor"])
+
+	if err != nil {
+		return state.ConfigurationState{}, fmt.Errorf("invalid 'Supervisor' section: %s", err)
+	}
+
+	config.Supervisor = supervisor
+
+	// Rotation is optional as well
+	rotation, err := ParseRotationConfig(cfg["Rotation"])
+
+	if err != nil {
+		return state.ConfigurationState{}, fmt.Errorf("invalid 'Rotation' section: %s", err)
+	}
+
+	config.Rotation = rotation
+
+	// Metrics are optional too
+	metricsConfig, err := ParseMetricsConfig(cfg["Metrics"])
+
+	if err != nil {
+		return state.ConfigurationState{}, fmt.Errorf("invalid 'Metrics' section: %s", err)
+	}
+
+	config.Metrics = metricsConfig
+
+	// And so are account checks
+	account, err := ParseAccountConfig(cfg["Account"])
+
+	if err != nil {
+		return state.ConfigurationState{}, fmt.Errorf("invalid 'Account' section: %s", err)
+	}
+
+	config.Account = account
+
+	return config, nil
+}
+
+// Parse upstream rotation policy, using defaults for missing values
+func ParseRotationConfig(sections []utils.INIPair) (schedule.Policy, error) {
+	var err error
+
+	policy := schedule.Policy{
+		Enabled:    false,
+		Interval:   DefaultRotationInterval,
+		Locations:  []string{},
+		RotateKeys: true,
+	}
+
+	if len(sections) == 0 {
+		return policy, nil
+	}
+
+	section := sections[0]
+
+	if policy.Enabled, err = configBool(section, "Enabled", policy.Enabled); err != nil {
+		return policy, err
+	}
+
+	interval := time.Duration(policy.Interval) * time.Second
+
+	if interval, err = configDuration(section, "Interval", interval); err != nil {
+		return policy, err
+	}
+
+	if policy.RotateKeys, err = configBool(section, "RotateKeys", policy.RotateKeys); err != nil {
+		return policy, err
+	}
+
+	policy.Interval = int64(interval / time.Second)
+	policy.Window = strings.TrimSpace(section["Window"])
+	policy.Locations = schedule.ParseLocations(section["Locations"])
+
+	return policy, policy.Validate()
+}
+
+// Parse supervisor settings, using defaults for missing values
+func ParseSupervisorConfig(sections []utils.INIPair) (state.SupervisorConfig, error) {
+	var err error
+
+	config := state.SupervisorConfig{
+		Enabled:          false,
+		Interval:         DefaultSupervisorInterval,
+		HandshakeTimeout: DefaultHandshakeTimeout,
+		MaxFailures:      DefaultMaxFailures,
+		FailoverInterval: DefaultFailoverInterval,
+		FailoverTimeout:  DefaultFailoverTimeout,
+	}
+
+	if len(sections) == 0 {
+		return config, nil
+	}
+
+	section := sections[0]
+
+	if config.Enabled, err = configBool(section, "Enabled", config.Enabled); err != nil {
+		return config, err
+	}
+
+	if config.Interval, err = configDuration(section, "Interval", config.Interval); err != nil {
+		return config, err
+	}
+
+	if config.HandshakeTimeout, err = configDuration(section, "HandshakeTimeout", config.HandshakeTimeout); err != nil {
+		return config, err
+	}
+
+	if config.MaxFailures, err = configInt(section, "MaxFailures", config.MaxFailures); err != nil {
+		return config, err
+	}
+
+	if config.MaxFailures < 1 {
+		return config, errors.New("'MaxFailures' should be positive")
+	}
+
+	if config.FailoverInterval, err = configDuration(section, "FailoverInterval", config.FailoverInterval); err != nil {
+		return config, err
+	}
+
+	if config.FailoverTimeout, err = configDuration(section, "FailoverTimeout", config.FailoverTimeout); err != nil {
+		return config, err
+	}
+
+	config.ProbeAddress = strings.TrimSpace(section["ProbeAddress"])
+
+	return config, nil
+}
+
+// Parse metrics settings, using defaults for missing values
+func ParseMetricsConfig(sections []utils.INIPair) (state.MetricsConfig, error) {
+	var err error
+
+	config := state.MetricsConfig{
+		Enabled: false,
+		Listen:  DefaultMetricsListen,
+	}
+
+	if len(sections) == 0 {
+		return config, nil
+	}
+
+	section := sections[0]
+
+	if config.Enabled, err = configBool(section, "Enabled", config.Enabled); err != nil {
+		return config, err
+	}
+
+	if listen := strings.TrimSpace(section["Listen"]); listen != "" {
+		config.Listen = listen
+	}
+
+	if _, _, err := net.SplitHostPort(config.Listen); err != nil {
+		return config, fmt.Errorf("'Listen' should be an address with port: %s", err)
+	}
+
+	return config, nil
+}
+
+// Parse account monitor settings, using defaults for missing values
+func ParseAccountConfig(sections []utils.INIPair) (state.AccountConfig, error) {
+	var err error
+
+	config := state.AccountConfig{
+		Enabled:  false,
+		Interval: DefaultAccountInterval,
+		WarnDays: handlers.DefaultAccountWarnDays,
+	}
+
+	if len(sections) == 0 {
+		return config, nil
+	}
+
+	section := sections[0]
+
+	if config.Enabled, err = configBool(section, "Enabled", config.Enabled); err != nil {
+		return config, err
+	}
+
+	if config.Interval, err = configDuration(section, "Interval", config.Interval); err != nil {
+		return config, err
+	}
+
+	if config.WarnDays, err = configInt(section, "WarnDays", config.WarnDays); err != nil {
+		return config, err
+	}
+
+	if config.WarnDays < 0 {
+		return config, errors.New("'WarnDays' should not be negative")
+	}
+
+	config.NotifyScript = strings.TrimSpace(section["NotifyScript"])
+	config.NotifyWebhook = strings.TrimSpace(section["NotifyWebhook"])
+
+	if config.NotifyWebhook != "" {
+		if parsed, err := url.Parse(config.NotifyWebhook); err != nil || (parsed.Scheme != "http" && parsed.Scheme != "https") {
+			return config, errors.New("'NotifyWebhook' should be an http or https URL")
+		}
+	}
+
 	return config, nil
 }
 
+// Get boolean config value or fallback if it's missing
+func configBool(section utils.INIPair, key string, fallback bool) (bool, error) {
+	value, ok := section[key]
+
+	if !ok || strings.TrimSpace(value) == "" {
+		return fallback, nil
+	}
+
+	switch strings.ToLower(strings.TrimSpace(value)) {
+	case "yes", "true", "on", "1":
+		return true, nil
+	case "no", "false", "off", "0":
+		return false, nil
+	default:
+		return fallback, fmt.Errorf("'%s' should be either yes or no", key)
+	}
+}
+
+// Get duration config value (like 30s or 5m) or fallback if it's missing
+func configDuration(section utils.INIPair, key string, fallback time.Duration) (time.Duration, error) {
+	value, ok := section[key]
+
+	if !ok || strings.TrimSpace(value) == "" {
+		return fallback, nil
+	}
+
+	parsed, err := time.ParseDuration(strings.TrimSpace(value))
+
+	if err != nil {
+		return fallback, fmt.Errorf("'%s' is not a valid duration", key)
+	}
+
+	if parsed <= 0 {
+		return fallback, fmt.Errorf("'%s' should be positive", key)
+	}
+
+	return parsed, nil
+}
+
+// Get integer config value or fallback if it's missing
+func configInt(section utils.INIPair, key string, fallback int) (int, error) {
+	value, ok := section[key]
+
+	if !ok || strings.TrimSpace(value) == "" {
+		return fallback, nil
+	}
+
+	parsed, err := strconv.Atoi(strings.TrimSpace(value))
+
+	if err != nil {
+		return fallback, fmt.Errorf("'%s' is not a valid number", key)
+	}
+
+	return parsed, nil
+}
+
 // Update application state based on app config
 func UpdateAppState(State *state.ProtectedState, Conf *state.ConfigurationState) error {
 	if State == nil {
@@ -133,7 +448,176 @@ func UpdateAppState(State *state.ProtectedState, Conf *state.ConfigurationState)
 	State.State.Config = Conf
 	State.State.AvailableProviders = providers
 
+	// Start with configured rotation policy; a copy is made
+	// so that defaults are kept intact for 'schedule --reset'
+	rotation := Conf.Rotation
+	State.State.Rotation = &rotation
+
+	// Restore previously saved state if it's available
+	if err := RestoreAppState(&State.State); err != nil {
+		log.Println("failed to restore saved state:", err)
+	}
+
+	// Exit groups don't depend on main upstream state
+	RestoreExits(&State.State)
+	RestoreStandby(&State.State)
+
 	State.Mutex.Unlock()
 
 	return nil
 }
+
+// Load saved state and reconcile it with actual upstream interface status.
+// If upstream was connected before, but now it's down (after a reboot, for
+// example), try to bring it back up using existing interface config
+func RestoreAppState(State *state.AppState) error {
+	snapshot, err := state.LoadState()
+
+	if err != nil {
+		return err
+	}
+
+	if snapshot == nil {
+		return nil
+	}
+
+	if err := State.Restore(snapshot); err != nil {
+		return err
+	}
+
+	if State.UpstreamProvider == nil {
+		return nil
+	}
+
+	// Interface can be missing, that's the same as being down
+	active, _ := State.Network.Upstream.IsActive()
+
+	if State.UpstreamProvider.Server == nil {
+		if active {
+			log.Println("upstream is up, but its server is unknown; run 'connect' to fix this")
+		}
+
+		State.UpstreamProvider.ActiveSince = nil
+
+		return nil
+	}
+
+	if !active {
+		if err := State.Network.Upstream.BringUp(); err != nil {
+			State.UpstreamProvider.Server = nil
+			State.UpstreamProvider.ActiveSince = nil
+
+			return fmt.Errorf("failed to bring upstream back up: %s", err)
+		}
+
+		t := time.Now().Unix()
+		State.UpstreamProvider.ActiveSince = &t
+	}
+
+	return nil
+}
+
+// Bring exit groups back after a restart. Routing rules are restored
+// for every group, so group peers never fall back to main upstream
+func RestoreExits(State *state.AppState) {
+	for _, group := range State.Exits {
+		name := handlers.ExitInterfaceName(State, group.Slot)
+		iface, err := network.CreateInterfaceFromConfig(name, network.InterfaceKindExit)
+
+		if err != nil {
+			log.Println("failed to load exit group config:", group.Name, err)
+
+			// Interface is still needed for routing
+			if iface, err = network.CreateInterface(name, network.InterfaceKindExit); err != nil {
+				continue
+			}
+
+			group.Server = nil
+		}
+
+		group.Interface = &iface
+
+		if err := handlers.SyncExitPeers(State, group); err != nil {
+			log.Println("failed to restore exit group routing:", group.Name, err)
+		}
+
+		if group.Server == nil {
+			group.ActiveSince = nil
+
+			continue
+		}
+
+		if active, _ := iface.IsActive(); active {
+			continue
+		}
+
+		if err := iface.BringUp(); err != nil {
+			log.Println("failed to bring exit group back up:", group.Name, err)
+
+			group.Server = nil
+			group.ActiveSince = nil
+
+			continue
+		}
+
+		t := time.Now().Unix()
+		group.ActiveSince = &t
+	}
+}
+
+// Bring standby upstream back after a restart. If it has been carrying
+// traffic, it takes upstream routing again, and supervisor will reconnect
+// main upstream later, same as it would without a restart
+func RestoreStandby(State *state.AppState) {
+	if State.Standby == nil {
+		return
+	}
+
+	if !State.Config.Supervisor.Enabled {
+		log.Println("standby upstream is enabled, but supervisor is not, so traffic is never moved to standby automatically")
+	}
+
+	promoted := State.Standby.Promoted
+	State.Standby.Promoted = false
+
+	iface, err := network.CreateInterfaceFromConfig(handlers.StandbyInterfaceName(State), network.InterfaceKindStandby)
+
+	if err != nil {
+		log.Println("failed to load standby upstream config:", err)
+
+		State.Standby.Server = nil
+		State.Standby.ActiveSince = nil
+
+		return
+	}
+
+	State.Network.Standby = &iface
+
+	if State.Standby.Server == nil {
+		State.Standby.ActiveSince = nil
+
+		return
+	}
+
+	if active, _ := iface.IsActive(); !active {
+		if err := iface.BringUp(); err != nil {
+			log.Println("failed to bring standby upstream back up:", err)
+
+			State.Standby.Server = nil
+			State.Standby.ActiveSince = nil
+
+			return
+		}
+
+		t := time.Now().Unix()
+		State.Standby.ActiveSince = &t
+	}
+
+	if !promoted {
+		return
+	}
+
+	if err := handlers.PromoteStandby(State); err != nil {
+		log.Println("failed to move traffic to standby upstream:", err)
+	}
+}
@@ -0,0 +1,356 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"time"
+	"wirejump/cmd/wirejumpd/handlers"
+	"wirejump/internal/ipc"
+	"wirejump/internal/network"
+	"wirejump/internal/state"
+)
+
+// Check upstream every this often by default
+const DefaultSupervisorInterval = 30 * time.Second
+
+// WireGuard renegotiates session every 2 minutes while there's traffic,
+// and upstream peer has keepalive enabled, so 3 minutes without a
+// handshake means there's something wrong with upstream server
+const DefaultHandshakeTimeout = 180 * time.Second
+
+// Reconnect after this many failed checks in a row by default
+const DefaultMaxFailures = 3
+
+// Check upstream this often while standby can take over by default
+const DefaultFailoverInterval = 5 * time.Second
+
+// Handshake is renegotiated every 2 minutes and retried every 5 seconds
+// if it fails, so handshake which is more than a few retries late means
+// upstream is most likely gone. Traffic can be moved back any moment,
+// so standby takes over much earlier than upstream is reconnected
+const DefaultFailoverTimeout = 135 * time.Second
+
+// How long to wait for probe connection
+const probeTimeout = 5 * time.Second
+
+// Supervisor watches upstream connection and reconnects it
+// to another server if current one appears to be dead. If standby
+// upstream is enabled, traffic is moved to it on the first failed
+// check, and standby itself is reconnected if it's dead
+type Supervisor struct {
+	Config          state.SupervisorConfig
+	failures        int
+	standbyFailures int
+}
+
+// Check if upstream is alive. Returns nil if upstream is fine or if it's
+// not supposed to be connected at all (never connected or disconnected
+// explicitly by user)
+func CheckUpstream(State *state.AppState, Timeout time.Duration) error {
+	if State.UpstreamProvider == nil || State.UpstreamProvider.Server == nil {
+		return nil
+	}
+
+	if State.UpstreamProvider.ActiveSince == nil || State.Network.Upstream == nil {
+		return nil
+	}
+
+	return checkInterface(State.Network.Upstream, *State.UpstreamProvider.ActiveSince, Timeout)
+}
+
+// Check if standby upstream is alive, same way as main one. Returns nil
+// if standby is not enabled or not supposed to be connected
+func CheckStandby(State *state.AppState, Timeout time.Duration) error {
+	if State.Standby == nil || State.Standby.Server == nil {
+		return nil
+	}
+
+	if State.Standby.ActiveSince == nil || State.Network.Standby == nil {
+		return nil
+	}
+
+	return checkInterface(State.Network.Standby, *State.Standby.ActiveSince, Timeout)
+}
+
+// Check if interface is up and its peer has made a handshake within timeout
+func checkInterface(Iface *network.InterfaceConfig, ActiveSince int64, Timeout time.Duration) error {
+	if active, _ := Iface.IsActive(); !active {
+		return errors.New("interface is down")
+	}
+
+	peers, err := Iface.GetPeers()
+
+	if err != nil {
+		return fmt.Errorf("cannot query interface peers: %s", err)
+	}
+
+	if len(peers) == 0 {
+		return errors.New("interface has no peers")
+	}
+
+	// Give fresh connection some time to make first handshake
+	now := time.Now()
+	timeout := int64(Timeout / time.Second)
+	handshake := peers[0].LatestHandshake
+
+	if handshake == 0 {
+		handshake = ActiveSince
+	}
+
+	if now.Unix()-handshake > timeout {
+		return fmt.Errorf("latest handshake was %d seconds ago", now.Unix()-handshake)
+	}
+
+	return nil
+}
+
+// Dial probe address; upstream gateway is routed via upstream
+// interface, so it's a good candidate for this check
+func ProbeUpstream(address string) error {
+	conn, err := net.DialTimeout("tcp", address, probeTimeout)
+
+	if err != nil {
+		return fmt.Errorf("probe has failed: %s", err)
+	}
+
+	return conn.Close()
+}
+
+// Reconnect upstream using the same flow as 'connect' command;
+// current server is considered failed, so it's not kept
+func ReconnectUpstream(State *state.AppState) error {
+	var reply interface{}
+
+	handler := handlers.IpcHandler{}
+	params := ipc.ConnectCommandRequest{
+		Failed: true,
+	}
+
+	if err := handler.Connect(State, &params, &reply); err != nil {
+		return err
+	}
+
+	if err := state.SaveState(State); err != nil {
+		log.Println("failed to save state:", err)
+	}
+
+	return nil
+}
+
+// Move traffic to standby upstream right away, if it's ready
+func (s *Supervisor) Failover() {
+	moved := false
+
+	err := ipc.LockedExec(func(State *state.AppState) error {
+		// Upstream could be disconnected by user in the meantime
+		if State.UpstreamProvider == nil || State.UpstreamProvider.Server == nil {
+			return nil
+		}
+
+		if !handlers.StandbyReady(State) || State.Standby.Promoted {
+			return nil
+		}
+
+		if err := handlers.PromoteStandby(State); err != nil {
+			return err
+		}
+
+		moved = true
+
+		if err := state.SaveState(State); err != nil {
+			log.Println("failed to save state:", err)
+		}
+
+		return nil
+	})
+
+	if errors.Is(err, ipc.ErrLocked) {
+		return
+	}
+
+	if err != nil {
+		log.Println("failed to move traffic to standby upstream:", err)
+	} else if moved {
+		log.Println("traffic has been moved to standby upstream")
+	}
+}
+
+// Reconnect standby upstream if it appears to be dead. Standby which
+// carries traffic is left alone, since main upstream is reconnected soon
+func (s *Supervisor) SuperviseStandby(err error, promoted bool) {
+	if err == nil || promoted {
+		s.standbyFailures = 0
+
+		return
+	}
+
+	s.standbyFailures++
+	log.Printf("standby upstream check has failed (%d/%d): %s\n", s.standbyFailures, s.Config.MaxFailures, err)
+
+	if s.standbyFailures < s.Config.MaxFailures {
+		return
+	}
+
+	err = ipc.LockedExec(func(State *state.AppState) error {
+		// Standby could be changed by user in the meantime
+		if State.Standby == nil || State.Standby.Server == nil || State.Standby.Promoted {
+			return nil
+		}
+
+		log.Println("reconnecting standby upstream...")
+
+		if err := handlers.ConnectStandby(State); err != nil {
+			return err
+		}
+
+		if err := state.SaveState(State); err != nil {
+			log.Println("failed to save state:", err)
+		}
+
+		return nil
+	})
+
+	if errors.Is(err, ipc.ErrLocked) {
+		return
+	}
+
+	if err != nil {
+		log.Println("failed to reconnect standby upstream:", err)
+	} else {
+		log.Println("standby upstream has been reconnected")
+	}
+
+	s.standbyFailures = 0
+}
+
+// Move traffic to standby upstream as soon as main upstream looks dead.
+// This runs more often and with a shorter handshake timeout than regular
+// checks, which only reconnect upstream after several failures
+func (s *Supervisor) CheckFailover() {
+	var ready bool
+
+	err := ipc.LockedExec(func(State *state.AppState) error {
+		ready = handlers.StandbyReady(State) && !State.Standby.Promoted
+
+		if !ready {
+			return nil
+		}
+
+		return CheckUpstream(State, s.Config.FailoverTimeout)
+	})
+
+	if errors.Is(err, ipc.ErrLocked) || !ready {
+		return
+	}
+
+	if err == nil && s.Config.ProbeAddress != "" {
+		err = ProbeUpstream(s.Config.ProbeAddress)
+	}
+
+	if err != nil {
+		log.Println("upstream check has failed, moving traffic to standby upstream:", err)
+		s.Failover()
+	}
+}
+
+// Run single supervisor check and reconnect if needed
+func (s *Supervisor) Tick() {
+	var promoted bool
+	var standbyErr error
+
+	// Interface queries are cheap, but probe can take a while;
+	// run it without holding the lock, so users are not blocked
+	err := ipc.LockedExec(func(State *state.AppState) error {
+		promoted = State.Standby != nil && State.Standby.Promoted
+		standbyErr = CheckStandby(State, s.Config.HandshakeTimeout)
+
+		return CheckUpstream(State, s.Config.HandshakeTimeout)
+	})
+
+	// Someone is managing upstream right now, check next time
+	if errors.Is(err, ipc.ErrLocked) {
+		return
+	}
+
+	// Traffic is only moved back by reconnecting main upstream,
+	// so until then it's treated as a failed one. Probe is skipped,
+	// since it would go via standby
+	if err == nil && promoted {
+		err = errors.New("traffic is carried by standby upstream")
+	}
+
+	if err == nil && s.Config.ProbeAddress != "" {
+		err = ProbeUpstream(s.Config.ProbeAddress)
+	}
+
+	s.SuperviseStandby(standbyErr, promoted)
+
+	if err == nil {
+		s.failures = 0
+
+		return
+	}
+
+	s.failures++
+	log.Printf("upstream check has failed (%d/%d): %s\n", s.failures, s.Config.MaxFailures, err)
+
+	// Standby doesn't need to wait for more failures
+	if !promoted {
+		s.Failover()
+	}
+
+	if s.failures < s.Config.MaxFailures {
+		return
+	}
+
+	// Servers are probed without holding the lock
+	handlers.PrepareConnect(&ipc.ConnectCommandRequest{Failed: true})
+
+	err = ipc.LockedExec(func(State *state.AppState) error {
+		// Upstream could be disconnected by user in the meantime
+		if State.UpstreamProvider == nil || State.UpstreamProvider.Server == nil {
+			return nil
+		}
+
+		log.Println("reconnecting upstream...")
+
+		return ReconnectUpstream(State)
+	})
+
+	if errors.Is(err, ipc.ErrLocked) {
+		return
+	}
+
+	if err != nil {
+		log.Println("failed to reconnect upstream:", err)
+	} else {
+		log.Println("upstream has been reconnected")
+	}
+
+	// Start counting again either way, so failing
+	// reconnects are not retried on every tick
+	s.failures = 0
+}
+
+// Run supervisor until context is done
+func (s *Supervisor) Run(ctx context.Context) {
+	ticker := time.NewTicker(s.Config.Interval)
+	defer ticker.Stop()
+
+	failover := time.NewTicker(s.Config.FailoverInterval)
+	defer failover.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			s.Tick()
+		case <-failover.C:
+			s.CheckFailover()
+		}
+	}
+}
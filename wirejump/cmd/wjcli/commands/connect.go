@@ -13,6 +13,9 @@ type ConnectCommand struct {
 
 	LocationOverride string
 	PreserveKeys     bool
+	Best             int
+	Strategy         string
+	Filter           filterFlags
 }
 
 var connectCommandHelp = []string{
@@ -23,13 +26,16 @@ var connectCommandHelp = []string{
 	"current provider using same location (if set) but via different upstream server.",
 	"It will also rotate WireGuard keys, unless -p/--preserve-keys is specified.",
 	"Use 'setup' command to setup a provider and 'servers' command to set default",
-	"location preference.\n",
+	"location preference; --best, --strategy and filter options override server",
+	"selection from 'servers' command this time.\n",
 }
 
-var connectCommandUsage = []string{
+var connectCommandUsage = append([]string{
 	"  -l, --location\tLocation to explicitly use this time",
 	"  -p, --preserve-keys\tDon't rotate WireGuard keys during reconnect",
-}
+	"      --best\tPick from N fastest servers this time, 0 for random",
+	"      --strategy\tServer selection strategy to use this time",
+}, filterUsage...)
 
 func NewConnectCommand() *ConnectCommand {
 	fs, opts := cli.CreateCommand("connect", "Manage upstream connection", connectCommandHelp, connectCommandUsage)
@@ -44,6 +50,10 @@ func NewConnectCommand() *ConnectCommand {
 	fs.BoolVar(&cmd.PreserveKeys, "p", false, "preserve")
 	fs.BoolVar(&cmd.PreserveKeys, "preserve", false, "preserve")
 
+	fs.IntVar(&cmd.Best, "best", -1, "best")
+	fs.StringVar(&cmd.Strategy, "strategy", "", "strategy")
+	cmd.Filter.register(fs)
+
 	return &cmd
 }
 
@@ -71,6 +81,13 @@ func (c *ConnectCommand) Run() error {
 		params.PreserveKeys = true
 	}
 
+	if c.Best >= 0 {
+		params.BestServers = &c.Best
+	}
+
+	params.Strategy = c.Strategy
+	params.Filter = c.Filter.options(c.fs)
+
 	err := cli.ExecuteCommand(c.opts, "Connect", params, &reply)
 
 	return err
@@ -0,0 +1,123 @@
+package commands
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"wirejump/internal/cli"
+	"wirejump/internal/ipc"
+)
+
+type ExitCommand struct {
+	fs   *flag.FlagSet
+	opts *cli.BasicCommand
+
+	Add        bool
+	Remove     bool
+	Connect    bool
+	Disconnect bool
+	List       bool
+	Name       string
+	Location   string
+}
+
+var exitCommandUsage = []string{
+	"      --add\tCreate exit group and connect it\t",
+	"      --remove\tRemove exit group\t",
+	"      --connect\tReconnect exit group to a new server\t",
+	"      --disconnect\tDisconnect exit group\t",
+	"      --list\tList all exit groups\t",
+	"      --name\tExit group name\t",
+	"  -l, --location\tExit group location\t",
+}
+
+var exitCommandHelp = []string{
+	"This command will manage exit groups. Exit group is an additional upstream",
+	"connection with its own server and location; peers put into the group send",
+	"their traffic via that connection instead of main upstream. This way, some",
+	"devices can use one country, while the rest of the network uses another.\n",
+	"Each group uses a separate key on the provider account, same as one more",
+	"device would. Up to 4 groups are supported, since Mullvad allows 5 devices",
+	"per account and one of them is main upstream.\n",
+	"Use --add with --name to create a group, optionally with --location; random",
+	"location is used otherwise. Then assign peers with 'peer --update --exit NAME'.",
+	"Use --connect to switch group to a new server, optionally with new --location.\n",
+	"When group is disconnected, its peers lose Internet access instead of falling",
+	"back to main upstream. Group can only be removed once it has no peers; move",
+	"them back with 'peer --update --exit main' first.\n",
+}
+
+func NewExitCommand() *ExitCommand {
+	fs, opts := cli.CreateCommand("exit", "Manage exit groups", exitCommandHelp, exitCommandUsage)
+	cmd := ExitCommand{
+		fs:   fs,
+		opts: opts,
+	}
+
+	fs.BoolVar(&cmd.Add, "add", false, "add")
+	fs.BoolVar(&cmd.Remove, "remove", false, "remove")
+	fs.BoolVar(&cmd.Connect, "connect", false, "connect")
+	fs.BoolVar(&cmd.Disconnect, "disconnect", false, "disconnect")
+	fs.BoolVar(&cmd.List, "list", false, "list")
+	fs.StringVar(&cmd.Name, "name", "", "name")
+	fs.StringVar(&cmd.Location, "l", "", "location")
+	fs.StringVar(&cmd.Location, "location", "", "location")
+
+	return &cmd
+}
+
+func (c *ExitCommand) Info() (*flag.FlagSet, *cli.BasicCommand) {
+	return c.fs, c.opts
+}
+
+func (c *ExitCommand) Run() error {
+	req := ipc.ExitCommandRequest{}
+	rep := ipc.ExitInfo{}
+
+	operations := 0
+
+	for _, set := range []bool{c.Add, c.Remove, c.Connect, c.Disconnect, c.List} {
+		if set {
+			operations++
+		}
+	}
+
+	if operations > 1 {
+		return errors.New("--add, --remove, --connect, --disconnect and --list cannot be used together")
+	}
+
+	// Listing is the default
+	if operations == 0 || c.List {
+		return cli.ExecuteCommand(c.opts, "ListExits", ipc.ExitListRequest{}, &ipc.ExitListReply{})
+	}
+
+	if c.Location != "" && !c.Add && !c.Connect {
+		return errors.New("--location can only be used with --add or --connect")
+	}
+
+	switch {
+	case c.Add:
+		req.Operation = ipc.ExitCommandAdd
+	case c.Remove:
+		req.Operation = ipc.ExitCommandRemove
+	case c.Connect:
+		req.Operation = ipc.ExitCommandConnect
+	case c.Disconnect:
+		req.Operation = ipc.ExitCommandDisconnect
+	}
+
+	req.Name = c.Name
+	req.Location = c.Location
+
+	if req.Name == "" {
+		if !cli.IsInteractive(c.opts) {
+			fmt.Println("Incomplete options provided, forcing interactive mode")
+		} else {
+			fmt.Println(cli.InteractiveModeBanner)
+		}
+
+		req.Name = cli.GetInputParam("Exit group name : ", req.Name)
+	}
+
+	return cli.ExecuteCommand(c.opts, "ManageExits", req, &rep)
+}
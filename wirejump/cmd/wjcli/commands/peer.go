@@ -3,25 +3,68 @@ package commands
 import (
 	"errors"
 	"flag"
+	"fmt"
+	"os"
+	"strings"
 	"wirejump/internal/cli"
+	"wirejump/internal/clientconf"
 	"wirejump/internal/ipc"
+	"wirejump/internal/network"
+	"wirejump/internal/qrcode"
 )
 
+// Size of a single QR code module in PNG, in pixels
+const qrImageScale = 8
+
 type PeerCommand struct {
 	fs   *flag.FlagSet
 	opts *cli.BasicCommand
 
-	Add      bool
-	Remove   bool
-	Pubkey   string
-	Isolated bool
+	Add         bool
+	Update      bool
+	Remove      bool
+	List        bool
+	Pubkey      string
+	Isolated    bool
+	Name        string
+	Description string
+	Owner       string
+	Generate    bool
+	Format      string
+	QR          bool
+	QRImage     string
+	PrivateKey  string
+	Preshared   bool
+	Address     string
+	Allocation  string
+	NewPubkey   string
+	NewName     string
+	Shared      bool
+	Exit        string
 }
 
 var peerCommandUsage = []string{
 	"      --add\tAdd peer\t",
+	"      --update\tUpdate peer, keeping its addresses\t",
 	"      --remove\tRemove peer\t",
+	"      --list\tList all peers with their stats\t",
 	"      --pubkey\tPeer public key\t",
 	"      --isolated\tIsolate this peer from other peers on the network\t",
+	"      --shared\tLet isolated peer reach other peers again, on update\t",
+	"      --new-pubkey\tNew peer public key, on update\t",
+	"      --new-name\tNew peer name, on update\t",
+	"      --psk\tGenerate preshared key for this peer\t",
+	"      --address\tRequested peer address, or IPv4 and IPv6 ones separated by comma\t",
+	"      --allocation\tAddress allocation mode: random (default) or next\t",
+	"      --name\tPeer name, also used as its DNS name\t",
+	"      --description\tPeer description\t",
+	"      --owner\tPeer owner\t",
+	"      --exit\tExit group for peer traffic, or 'main' for main upstream\t",
+	"      --generate\tGenerate peer keys and print client config\t",
+	"      --format\tClient config format: " + strings.Join(clientconf.Formats, ", ") + "\t",
+	"      --qr\tPrint client config as a QR code\t",
+	"      --qr-png\tSave client config QR code to PNG file\t",
+	"      --private-key\tPeer private key to put into QR code, never sent to server\t",
 }
 
 var peerCommandHelp = []string{
@@ -31,11 +74,42 @@ var peerCommandHelp = []string{
 	"used addresses into account. Another benefit of using this over manual editing",
 	"is that interface configuration will be updated without restart, so other peers",
 	"connections will not be affected.\n",
-	"Use --add to add the peer, and --remove to remove the peer. Both commands require",
-	"a valid public key.\n",
+	"Use --add to add the peer, and --remove to remove the peer. Adding requires",
+	"a valid public key; peer can be removed either by its key or by its name.\n",
+	"Peers can be given a name with --name, along with optional --description and",
+	"--owner. Name should be unique and consist of letters, digits and dashes; it's",
+	"used as peer DNS name in the peers zone instead of the key prefix.\n",
 	"By default, all peers are put into one shared network without any restrictions;",
 	"this allows them to communicate directly should the need arise. If this behaviour",
 	"is undesired, pass --isolated flag.\n",
+	"Peer addresses are picked randomly from free ones in downstream network.",
+	"Use --allocation next to get the lowest free address instead, so re-added",
+	"peer gets the same address, or request specific one with --address.\n",
+	"Pass --psk to generate a preshared key for the peer; it adds symmetric",
+	"encryption layer on top of the usual one, as a post-quantum hardening.",
+	"Preshared key is printed once, and it's already included in generated configs",
+	"and QR codes; otherwise, add it to the client config by hand.\n",
+	"Instead of providing a public key, keys can be generated by the server with",
+	"--generate. Then a complete client config is printed, which includes private",
+	"key; it's not stored on the server, so keep the config safe. By default, config",
+	"is printed in wg-quick format; use --format mikrotik to get RouterOS script",
+	"or --format openwrt to get UCI commands instead. Server public address should",
+	"be set with 'Endpoint' option in server config for this to work.\n",
+	"To set up mobile clients, use --qr to print wg-quick config as a QR code,",
+	"and/or --qr-png to save it as an image. Unless keys are generated, server",
+	"does not know peer private key, so it's replaced with a placeholder; use",
+	"--private-key to put the real one in. The key stays on this machine, and",
+	"public key is derived from it when --pubkey is omitted.\n",
+	"Use --update to change existing peer without changing its addresses. Peer",
+	"is found by --pubkey or --name; --new-pubkey and --new-name replace them.",
+	"Key can also be rotated with --generate or --private-key. Pass --isolated",
+	"or --shared to change peer isolation; --description, --owner and --psk",
+	"work the same way as for new peers.\n",
+	"Pass --exit with a group name to route peer traffic via that exit group",
+	"instead of main upstream; see 'exit' command. On update, --exit main moves",
+	"the peer back to main upstream.\n",
+	"Use --list to show all peers along with their addresses, endpoints, latest",
+	"handshakes and traffic counters. Use it with --name to show a single peer.\n",
 }
 
 func NewPeerCommand() *PeerCommand {
@@ -47,8 +121,25 @@ func NewPeerCommand() *PeerCommand {
 
 	fs.StringVar(&cmd.Pubkey, "pubkey", "", "pubkey")
 	fs.BoolVar(&cmd.Add, "add", false, "add")
+	fs.BoolVar(&cmd.Update, "update", false, "update")
 	fs.BoolVar(&cmd.Remove, "remove", false, "remove")
+	fs.BoolVar(&cmd.List, "list", false, "list")
 	fs.BoolVar(&cmd.Isolated, "isolated", false, "isolated")
+	fs.BoolVar(&cmd.Shared, "shared", false, "shared")
+	fs.StringVar(&cmd.NewPubkey, "new-pubkey", "", "new-pubkey")
+	fs.StringVar(&cmd.NewName, "new-name", "", "new-name")
+	fs.BoolVar(&cmd.Preshared, "psk", false, "psk")
+	fs.StringVar(&cmd.Address, "address", "", "address")
+	fs.StringVar(&cmd.Allocation, "allocation", "", "allocation")
+	fs.StringVar(&cmd.Name, "name", "", "name")
+	fs.StringVar(&cmd.Description, "description", "", "description")
+	fs.StringVar(&cmd.Owner, "owner", "", "owner")
+	fs.StringVar(&cmd.Exit, "exit", "", "exit")
+	fs.BoolVar(&cmd.Generate, "generate", false, "generate")
+	fs.StringVar(&cmd.Format, "format", "", "format")
+	fs.BoolVar(&cmd.QR, "qr", false, "qr")
+	fs.StringVar(&cmd.QRImage, "qr-png", "", "qr-png")
+	fs.StringVar(&cmd.PrivateKey, "private-key", "", "private-key")
 
 	return &cmd
 }
@@ -61,30 +152,223 @@ func (c *PeerCommand) Run() error {
 	req := ipc.PeerCommandRequest{}
 	rep := ipc.PeerCommandReply{}
 
-	if !c.Add && !c.Remove {
-		return errors.New("either --add or --remove is required")
+	operations := 0
+
+	for _, set := range []bool{c.Add, c.Update, c.Remove, c.List} {
+		if set {
+			operations++
+		}
+	}
+
+	if operations == 0 {
+		return errors.New("either --add, --update, --remove or --list is required")
+	}
+
+	if operations > 1 {
+		return errors.New("--add, --update, --remove and --list cannot be used together")
 	}
 
-	if c.Add && c.Remove {
-		return errors.New("--add and --remove cannot be used together")
+	// Options which produce client config
+	changes := c.Add || c.Update
+
+	if c.Format != "" && !c.Generate {
+		return errors.New("--format can only be used with --generate")
+	}
+
+	if c.Generate && !changes {
+		return errors.New("--generate can only be used with --add or --update")
+	}
+
+	withQR := c.QR || c.QRImage != ""
+
+	if (withQR || c.PrivateKey != "" || c.Preshared) && !changes {
+		return errors.New("--qr, --qr-png, --private-key and --psk can only be used with --add or --update")
+	}
+
+	if (c.Address != "" || c.Allocation != "") && !c.Add {
+		return errors.New("--address and --allocation can only be used with --add")
+	}
+
+	if c.Exit != "" && !changes {
+		return errors.New("--exit can only be used with --add or --update")
+	}
+
+	if (c.NewPubkey != "" || c.NewName != "" || c.Shared) && !c.Update {
+		return errors.New("--new-pubkey, --new-name and --shared can only be used with --update")
+	}
+
+	if c.Isolated && c.Shared {
+		return errors.New("--isolated and --shared cannot be used together")
+	}
+
+	if withQR && c.Format != "" && c.Format != clientconf.FormatWgQuick {
+		return errors.New("QR code can only be made for wg-quick config")
+	}
+
+	if withQR && cli.IsJSON(c.opts) {
+		return errors.New("QR code can not be printed in JSON mode")
+	}
+
+	if c.PrivateKey != "" {
+		if c.Generate {
+			return errors.New("--private-key can not be used with --generate")
+		}
+
+		pubkey, err := network.GeneratePublicKey(c.PrivateKey)
+
+		if err != nil {
+			return fmt.Errorf("invalid private key: %s", err)
+		}
+
+		// On update, private key is the new one
+		target := &c.Pubkey
+
+		if c.Update {
+			target = &c.NewPubkey
+		}
+
+		if *target != "" && *target != pubkey {
+			return errors.New("public key does not match private key")
+		}
+
+		*target = pubkey
+	}
+
+	if c.List {
+		return cli.ExecuteCommand(c.opts, "ListPeers", ipc.PeerListRequest{Name: c.Name}, &ipc.PeerListReply{})
 	}
 
 	if c.Add {
 		req.Operation = ipc.PeerCommandAddPeer
 	}
 
+	if c.Update {
+		req.Operation = ipc.PeerCommandUpdatePeer
+	}
+
 	if c.Remove {
 		req.Operation = ipc.PeerCommandDeletePeer
 	}
 
-	// Get pubkey
-	if cli.CheckForInteractive(c) {
-		req.Pubkey = cli.GetInputParam("Public key : ", "")
-	} else {
-		req.Pubkey = c.Pubkey
+	// Metadata is optional, so only missing key (or name
+	// for update and removal) forces interactive mode
+	incomplete := c.Pubkey == "" && ((c.Add && !c.Generate) || (!c.Add && c.Name == ""))
+	interactive := cli.IsInteractive(c.opts)
+
+	if interactive {
+		fmt.Println(cli.InteractiveModeBanner)
+	} else if incomplete {
+		fmt.Println("Incomplete options provided, forcing interactive mode")
+
+		interactive = true
 	}
 
+	req.Pubkey = c.Pubkey
+	req.Name = c.Name
+	req.Description = c.Description
+	req.Owner = c.Owner
+	req.Exit = c.Exit
 	req.Isolated = c.Isolated
+	req.Preshared = c.Preshared
+	req.Allocation = c.Allocation
+	req.NewPubkey = c.NewPubkey
+	req.NewName = c.NewName
+	req.Generate = c.Generate
+	req.Format = c.Format
+	req.NeedConfig = withQR
+
+	if c.Address != "" {
+		req.Addresses = strings.Split(c.Address, ",")
+	}
+
+	if c.Isolated {
+		req.Isolation = ipc.PeerIsolationOn
+	}
+
+	if c.Shared {
+		req.Isolation = ipc.PeerIsolationOff
+	}
+
+	if interactive {
+		if (c.Add && !c.Generate) || (!c.Add && c.Name == "") {
+			req.Pubkey = cli.GetInputParam("Public key : ", req.Pubkey)
+		}
+
+		if c.Add {
+			req.Name = cli.GetInputParam("Name (optional) : ", req.Name)
+		}
+	}
+
+	// Generated config is printed as is, so it can be copied right away
+	if (c.Generate || withQR) && !cli.IsJSON(c.opts) {
+		if err := cli.QueryCommand("ManagePeers", req, &rep); err != nil {
+			return err
+		}
+
+		if err := cli.PrettyFormatter(os.Stdout, &rep); err != nil {
+			return err
+		}
+
+		if rep.Config != nil {
+			fmt.Printf("\n%s", *rep.Config)
+		}
+
+		if withQR {
+			return c.printQR(rep.Client)
+		}
+
+		return nil
+	}
 
 	return cli.ExecuteCommand(c.opts, "ManagePeers", req, &rep)
 }
+
+// Print and/or save client config as a QR code
+func (c *PeerCommand) printQR(Client *clientconf.ClientConfig) error {
+	if Client == nil {
+		return errors.New("peer has been changed, but server did not return client config")
+	}
+
+	config := *Client
+
+	if config.PrivateKey == "" {
+		config.PrivateKey = c.PrivateKey
+	}
+
+	if config.PrivateKey == "" {
+		config.PrivateKey = clientconf.PrivateKeyPlaceholder
+		fmt.Println("\nWarning: private key is not known, replace the placeholder after scanning")
+	}
+
+	code, err := qrcode.Encode([]byte(config.WgQuick()), qrcode.LevelMedium)
+
+	if err != nil {
+		return fmt.Errorf("failed to create QR code: %s", err)
+	}
+
+	if c.QR {
+		fmt.Printf("\n%s", code.Terminal())
+	}
+
+	if c.QRImage != "" {
+		// Image contains private key, so it's only readable by owner
+		file, err := os.OpenFile(c.QRImage, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+
+		if err != nil {
+			return fmt.Errorf("failed to create QR code image: %s", err)
+		}
+
+		if err := code.PNG(file, qrImageScale); err != nil {
+			file.Close()
+			return fmt.Errorf("failed to write QR code image: %s", err)
+		}
+
+		if err := file.Close(); err != nil {
+			return fmt.Errorf("failed to write QR code image: %s", err)
+		}
+
+		fmt.Printf("\nQR code saved to %s\n", c.QRImage)
+	}
+
+	return nil
+}
@@ -0,0 +1,105 @@
+package commands
+
+import (
+	"errors"
+	"flag"
+	"strings"
+	"wirejump/internal/cli"
+	"wirejump/internal/ipc"
+)
+
+type ScheduleCommand struct {
+	fs   *flag.FlagSet
+	opts *cli.BasicCommand
+
+	Enable     bool
+	Disable    bool
+	Reset      bool
+	Interval   string
+	Window     string
+	Locations  string
+	RotateKeys string
+}
+
+var scheduleCommandHelp = []string{
+	"This command will manage scheduled upstream rotation. When rotation is enabled,",
+	"server will reconnect upstream (same as 'connect' command does) once connection",
+	"becomes older than specified interval. Rotation can be limited to a daily time",
+	"window (server local time) and to a list of locations to pick from randomly;",
+	"if no locations are set, current location preference is used.\n",
+	"Default policy is read from the server config file. Changes made by this command",
+	"are saved by the server and override the config file until --reset is used.",
+	"Run without options to display current policy.\n",
+}
+
+var scheduleCommandUsage = []string{
+	"      --enable\tEnable rotation\t",
+	"      --disable\tDisable rotation\t",
+	"      --interval\tRotation interval, like 12h or 90m\t",
+	"      --window\tDaily time window, like 01:00-05:00, or 'any'\t",
+	"      --locations\tComma separated locations to rotate between, or 'any'\t",
+	"      --rotate-keys\tRotate WireGuard keys as well (yes/no)\t",
+	"  -r, --reset\tRevert to the config file policy\t",
+}
+
+func NewScheduleCommand() *ScheduleCommand {
+	fs, opts := cli.CreateCommand("schedule", "Manage scheduled upstream rotation", scheduleCommandHelp, scheduleCommandUsage)
+	cmd := ScheduleCommand{
+		fs:   fs,
+		opts: opts,
+	}
+
+	fs.BoolVar(&cmd.Enable, "enable", false, "enable")
+	fs.BoolVar(&cmd.Disable, "disable", false, "disable")
+	fs.StringVar(&cmd.Interval, "interval", "", "interval")
+	fs.StringVar(&cmd.Window, "window", "", "window")
+	fs.StringVar(&cmd.Locations, "locations", "", "locations")
+	fs.StringVar(&cmd.RotateKeys, "rotate-keys", "", "rotate-keys")
+
+	fs.BoolVar(&cmd.Reset, "r", false, "reset")
+	fs.BoolVar(&cmd.Reset, "reset", false, "reset")
+
+	return &cmd
+}
+
+func (c *ScheduleCommand) Info() (*flag.FlagSet, *cli.BasicCommand) {
+	return c.fs, c.opts
+}
+
+func (c *ScheduleCommand) Run() error {
+	params := ipc.ScheduleCommandRequest{}
+	reply := ipc.ScheduleCommandReply{}
+
+	params.Enable = c.Enable
+	params.Disable = c.Disable
+	params.Reset = c.Reset
+
+	if c.Interval != "" {
+		params.Interval = &c.Interval
+	}
+
+	if c.Window != "" {
+		params.Window = &c.Window
+	}
+
+	if c.Locations != "" {
+		params.Locations = &c.Locations
+	}
+
+	if c.RotateKeys != "" {
+		rotate := false
+
+		switch strings.ToLower(c.RotateKeys) {
+		case "yes":
+			rotate = true
+		case "no":
+			rotate = false
+		default:
+			return errors.New("--rotate-keys should be either 'yes' or 'no'")
+		}
+
+		params.RotateKeys = &rotate
+	}
+
+	return cli.ExecuteCommand(c.opts, "ManageSchedule", params, &reply)
+}
@@ -1,8 +1,10 @@
 package commands
 
 import (
+	"errors"
 	"flag"
 	"fmt"
+	"strconv"
 	"wirejump/internal/cli"
 	"wirejump/internal/ipc"
 )
@@ -13,24 +15,94 @@ type ServersCommand struct {
 
 	ForceUpdate bool
 	Preferred   string
+	Exclude     string
 	Reset       bool
+	Location    string
+	Best        string
+	Latency     bool
+	Strategy    string
+	Tree        bool
+	Filter      filterFlags
+}
+
+// Server filter flags, shared by servers and connect commands
+type filterFlags struct {
+	OnlyOwned       bool
+	ExcludeHosting  string
+	RequireFeatures string
+}
+
+var filterUsage = []string{
+	"      --only-owned\tOnly use servers owned by provider, true or false",
+	"      --exclude-hosting\tHosting providers to avoid, comma separated, or 'none'",
+	"      --require-features\tFeatures servers must have, comma separated, or 'none'",
+}
+
+// Add filter flags to a command
+func (f *filterFlags) register(fs *flag.FlagSet) {
+	fs.BoolVar(&f.OnlyOwned, "only-owned", true, "only-owned")
+	fs.StringVar(&f.ExcludeHosting, "exclude-hosting", "", "exclude-hosting")
+	fs.StringVar(&f.RequireFeatures, "require-features", "", "require-features")
+}
+
+// Get filter changes for the flags which were actually passed
+func (f *filterFlags) options(fs *flag.FlagSet) ipc.FilterOptions {
+	options := ipc.FilterOptions{}
+
+	fs.Visit(func(passed *flag.Flag) {
+		switch passed.Name {
+		case "only-owned":
+			options.OnlyOwned = &f.OnlyOwned
+		case "exclude-hosting":
+			options.ExcludeHosting = &f.ExcludeHosting
+		case "require-features":
+			options.RequireFeatures = &f.RequireFeatures
+		}
+	})
+
+	return options
 }
 
 var serversCommandHelp = []string{
 	"This command will manage desired server location for a particular provider.",
-	"If provider has servers in different countries (that's usually the case), these",
-	"countries are treated as separate locations. Exact upstream server is selected ",
-	"randomly from all servers for a particular location. If location is not set, it",
-	"will be selected randomly. By default, there is no location preference.\n",
+	"Locations are countries (like 'se' or 'Sweden'), cities within them (like",
+	"'se/got') and single servers, referred by their hostnames (like 'se-got-wg-001');",
+	"use --tree to show them all. Exact upstream server is selected from all servers",
+	"for a particular location. If location is not set, a random country will be",
+	"used. By default, there is no location preference.\n",
+	"Several preferred locations can be set, comma separated: first one which has",
+	"servers available is used. Locations passed to -x/--exclude are never used,",
+	"not even for exit groups, standby or rotation; pass 'none' to remove exclusions.\n",
 	"Server locations are cached in memory for 1 hour. To refresh them immediately, ",
 	"pass -f/--force flag to force the update.\n",
+	"Instead of a random server, one of the fastest servers can be used: latency to",
+	"the servers is measured from this machine, and a random one of the best N is",
+	"selected, so load is still spread a bit. Use --best N to set N for all locations",
+	"or, together with -l/--location, for a single one; 0 means random server, and",
+	"'default' removes the setting. Latency is measured before connecting and cached",
+	"for 1 hour; use --latency to measure and show it, -f/--force to measure again.\n",
+	"Server is picked by selection strategy, set with --strategy: 'random' (default)",
+	"prefers another city, 'round-robin' cycles through cities, 'least-used' picks",
+	"server which was not used for the longest time, 'sticky' keeps the same server",
+	"until it fails and 'weighted' prefers servers with higher provider weight.\n",
+	"Servers can be filtered by their attributes, if provider reports them: only",
+	"servers owned by provider are used by default (pass --only-owned=false to use",
+	"rented ones too), hosting providers can be avoided with --exclude-hosting and",
+	"features (like 'daita' or 'multihop') required with --require-features. Server",
+	"attributes are shown by --tree.\n",
 }
 
-var serversCommandUsage = []string{
+var serversCommandUsage = append([]string{
 	"  -f, --force\tForce servers update",
-	"  -p, --preferred\tSet preferred location",
+	"  -p, --preferred\tSet preferred locations, comma separated",
+	"  -x, --exclude\tSet excluded locations, comma separated, or 'none'",
 	"  -r, --reset\tRemove location preference",
-}
+	"  -l, --location\tLocation for --best, --latency and --tree",
+	"      --best\tPick from N fastest servers",
+	"      --latency\tShow server latency",
+	"      --strategy\tSet server selection strategy",
+	"      --tree\tShow countries, cities and servers",
+}, filterUsage...)
 
 func NewServersCommand() *ServersCommand {
 	fs, opts := cli.CreateCommand("servers", "Manage available server locations", serversCommandHelp, serversCommandUsage)
@@ -44,9 +116,21 @@ func NewServersCommand() *ServersCommand {
 	fs.StringVar(&cmd.Preferred, "p", "", "preferred")
 	fs.StringVar(&cmd.Preferred, "preferred", "", "preferred")
 
+	fs.StringVar(&cmd.Exclude, "x", "", "exclude")
+	fs.StringVar(&cmd.Exclude, "exclude", "", "exclude")
+
 	fs.BoolVar(&cmd.Reset, "r", false, "reset")
 	fs.BoolVar(&cmd.Reset, "reset", false, "reset")
 
+	fs.StringVar(&cmd.Location, "l", "", "location")
+	fs.StringVar(&cmd.Location, "location", "", "location")
+
+	fs.StringVar(&cmd.Best, "best", "", "best")
+	fs.BoolVar(&cmd.Latency, "latency", false, "latency")
+	fs.StringVar(&cmd.Strategy, "strategy", "", "strategy")
+	fs.BoolVar(&cmd.Tree, "tree", false, "tree")
+	cmd.Filter.register(fs)
+
 	return &cmd
 }
 
@@ -60,14 +144,79 @@ func (c *ServersCommand) Run() error {
 
 	params.Reset = c.Reset
 	params.Preferred = c.Preferred
+
+	if c.Exclude != "" {
+		params.Exclude = &c.Exclude
+	}
 	params.ForceUpdate = c.ForceUpdate
+	params.Location = c.Location
+	params.Latency = c.Latency
+	params.Strategy = c.Strategy
+	params.Tree = c.Tree
+	params.Filter = c.Filter.options(c.fs)
+
+	if c.Tree {
+		tree := ipc.ServersTreeReply{}
+
+		if cli.IsJSON(c.opts) {
+			return cli.ExecuteCommand(c.opts, "ManageServers", params, &tree)
+		}
+
+		if err := cli.QueryCommand("ManageServers", params, &tree); err != nil {
+			return err
+		}
+
+		printLocationTree(tree, "", true)
+
+		return nil
+	}
+
+	if c.Latency {
+		return cli.ExecuteCommand(c.opts, "ManageServers", params, &ipc.ServersLatencyReply{})
+	}
+
+	if c.Best == "default" {
+		params.ResetBest = true
+	} else if c.Best != "" {
+		best, err := strconv.Atoi(c.Best)
+
+		if err != nil || best < 0 {
+			return errors.New("--best should be a number or 'default'")
+		}
+
+		params.BestServers = &best
+	}
 
 	// Ask for location explicitly if interactive is enabled
 	if cli.IsInteractive(c.opts) {
 		fmt.Println(cli.InteractiveModeBanner)
 
-		params.Preferred = cli.GetInputParam("Preferred location : ", params.Preferred)
+		params.Preferred = cli.GetInputParam("Preferred locations : ", params.Preferred)
 	}
 
 	return cli.ExecuteCommand(c.opts, "ManageServers", params, &reply)
 }
+
+// Print location tree with box drawing characters; countries go without them
+func printLocationTree(nodes []ipc.LocationNode, prefix string, top bool) {
+	for index, node := range nodes {
+		branch, nested := "├── ", "│   "
+
+		if index == len(nodes)-1 {
+			branch, nested = "└── ", "    "
+		}
+
+		if top {
+			branch, nested = "", ""
+		}
+
+		line := node.Location
+
+		if node.Name != "" {
+			line += " (" + node.Name + ")"
+		}
+
+		fmt.Println(prefix + branch + line)
+		printLocationTree(node.Children, prefix+nested, false)
+	}
+}
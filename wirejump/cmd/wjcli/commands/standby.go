@@ -0,0 +1,77 @@
+package commands
+
+import (
+	"flag"
+	"wirejump/internal/cli"
+	"wirejump/internal/ipc"
+)
+
+type StandbyCommand struct {
+	fs   *flag.FlagSet
+	opts *cli.BasicCommand
+
+	Enable   bool
+	Disable  bool
+	Connect  bool
+	Failover bool
+	Location string
+}
+
+var standbyCommandHelp = []string{
+	"This command will manage standby upstream. Standby is a second connection to",
+	"another server, which is kept up but carries no traffic. When upstream supervisor",
+	"finds main upstream dead, downstream traffic is moved to standby right away;",
+	"main upstream is reconnected later as usual, and traffic is moved back to it.",
+	"Planned reconnects ('connect' command and scheduled rotation) use standby while",
+	"main upstream is rebuilt as well.\n",
+	"Standby uses one more key on the provider account, so it takes one of the",
+	"devices exit groups could use. By default, standby uses the same location as",
+	"main upstream, but always a different server; pass --location to change it.\n",
+	"Use --connect to reconnect standby to a new server and --failover to move",
+	"traffic to it right now. Run without options to display standby status.\n",
+}
+
+var standbyCommandUsage = []string{
+	"      --enable\tEnable standby upstream and connect it\t",
+	"      --disable\tDisable standby upstream\t",
+	"      --connect\tReconnect standby upstream to a new server\t",
+	"      --failover\tMove traffic to standby upstream now\t",
+	"  -l, --location\tStandby upstream location\t",
+}
+
+func NewStandbyCommand() *StandbyCommand {
+	fs, opts := cli.CreateCommand("standby", "Manage standby upstream", standbyCommandHelp, standbyCommandUsage)
+	cmd := StandbyCommand{
+		fs:   fs,
+		opts: opts,
+	}
+
+	fs.BoolVar(&cmd.Enable, "enable", false, "enable")
+	fs.BoolVar(&cmd.Disable, "disable", false, "disable")
+	fs.BoolVar(&cmd.Connect, "connect", false, "connect")
+	fs.BoolVar(&cmd.Failover, "failover", false, "failover")
+	fs.StringVar(&cmd.Location, "l", "", "location")
+	fs.StringVar(&cmd.Location, "location", "", "location")
+
+	return &cmd
+}
+
+func (c *StandbyCommand) Info() (*flag.FlagSet, *cli.BasicCommand) {
+	return c.fs, c.opts
+}
+
+func (c *StandbyCommand) Run() error {
+	params := ipc.StandbyCommandRequest{}
+	reply := ipc.StandbyCommandReply{}
+
+	params.Enable = c.Enable
+	params.Disable = c.Disable
+	params.Connect = c.Connect
+	params.Failover = c.Failover
+
+	if c.Location != "" {
+		params.Location = &c.Location
+	}
+
+	return cli.ExecuteCommand(c.opts, "ManageStandby", params, &reply)
+}
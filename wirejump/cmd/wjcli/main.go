@@ -27,6 +27,9 @@ func init() {
 		commands.NewServersCommand(),
 		commands.NewConnectCommand(),
 		commands.NewStatusCommand(),
+		commands.NewExitCommand(),
+		commands.NewStandbyCommand(),
+		commands.NewScheduleCommand(),
 		commands.NewDisconnectCommand(),
 		commands.NewResetCommand(),
 		commands.NewVersionCommand(),
@@ -149,3 +149,21 @@ func ExecuteCommand(cmd *BasicCommand, name string, params IpcCommand, reply int
 
 	return nil
 }
+
+// Execute remote command and decode its reply without printing anything,
+// so command can present the reply on its own
+func QueryCommand(name string, params IpcCommand, reply interface{}) error {
+	var empty bool
+
+	handle := ipc.GetRPCClient()
+
+	if handle == nil {
+		return &ProgramError{Err: errors.New("IPC is not initialized")}
+	}
+
+	if err := ipc.RemoteExec(handle, name, params, reply, &empty); err != nil {
+		return &CommandError{Err: err}
+	}
+
+	return nil
+}
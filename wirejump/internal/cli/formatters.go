@@ -25,6 +25,120 @@ const emptyValue = "N/A"
 // Max nested struct depth
 const maxNestingLevel = 3
 
+// Check if field should not be printed at all
+func isHidden(field reflect.StructField) bool {
+	return field.Tag.Get("pretty") == "-"
+}
+
+// Get field title, using tag hints if possible
+func fieldTitle(field reflect.StructField) string {
+	if lookup, ok := field.Tag.Lookup("pretty"); ok && lookup != "" {
+		return lookup
+	}
+
+	return field.Name
+}
+
+// Format single struct field value according to its type and tags
+func formatField(f reflect.Value, tags reflect.StructTag) interface{} {
+	// Get raw value
+	value := f.Interface()
+
+	// Extract pointer value if needed
+	if f.Kind() == reflect.Pointer {
+		if f.IsNil() {
+			value = nil
+		} else {
+			value = f.Elem().Interface()
+		}
+	}
+
+	// Special formatting for each type
+	switch f.Kind() {
+	case reflect.String:
+		if value == "" {
+			value = emptyValue
+		}
+	case reflect.Bool:
+		if value == true {
+			value = "yes"
+		} else {
+			value = "no"
+		}
+	case reflect.Slice:
+		value = strings.Join(value.([]string), ", ")
+
+		if value == "" {
+			value = emptyValue
+		}
+	}
+
+	// Format time fields
+	if _, ok := tags.Lookup("timefield"); ok && value != nil {
+		value = prettyTime(value.(int64))
+	}
+
+	// Format byte counters
+	if _, ok := tags.Lookup("bytesfield"); ok && value != nil {
+		value = prettyBytes(value.(int64))
+	}
+
+	// Format nil pointers
+	if value == nil {
+		value = emptyValue
+	}
+
+	return value
+}
+
+// Get slice of structs as table rows, first one being a header
+func sliceToTable(v reflect.Value) []string {
+	var output []string
+
+	t := v.Type().Elem()
+	header := ""
+
+	for i := 0; i < t.NumField(); i++ {
+		if !isHidden(t.Field(i)) {
+			header += fieldTitle(t.Field(i)) + "\t"
+		}
+	}
+
+	output = append(output, header+"\n")
+
+	for i := 0; i < v.Len(); i++ {
+		row := ""
+		item := v.Index(i)
+
+		for j := 0; j < item.NumField(); j++ {
+			if isHidden(t.Field(j)) {
+				continue
+			}
+
+			row += fmt.Sprintf("%v\t", formatField(item.Field(j), t.Field(j).Tag))
+		}
+
+		output = append(output, row+"\n")
+	}
+
+	return output
+}
+
+// Check if data is a list of structs, which should be printed as a table
+func isTable(data interface{}) bool {
+	if data == nil {
+		return false
+	}
+
+	v := reflect.ValueOf(data)
+
+	if v.Kind() == reflect.Pointer {
+		v = v.Elem()
+	}
+
+	return v.Kind() == reflect.Slice && v.Type().Elem().Kind() == reflect.Struct
+}
+
 // Get struct fields and values as strings, up to maxNestingLevel levels of recursion
 func dataToStringArray(data interface{}, nestingLevel int) ([]string, error) {
 	var output []string
@@ -52,13 +166,10 @@ func dataToStringArray(data interface{}, nestingLevel int) ([]string, error) {
 		for i := 0; i < v.NumField(); i++ {
 			f := v.Field(i)
 			tags := t.Field(i).Tag
-			title := t.Field(i).Name
+			title := fieldTitle(t.Field(i))
 
-			// Use tag hints if possible
-			if tags != "" {
-				if lookup, ok := tags.Lookup("pretty"); ok && lookup != "" {
-					title = lookup
-				}
+			if isHidden(t.Field(i)) {
+				continue
 			}
 
 			if f.Kind() == reflect.Struct {
@@ -72,52 +183,13 @@ func dataToStringArray(data interface{}, nestingLevel int) ([]string, error) {
 				output = append(output, nested...)
 				// output = append(outp)
 			} else {
-				// Get raw value
-				value := f.Interface()
-
-				// Extract pointer value if needed
-				if f.Kind() == reflect.Pointer {
-					if f.IsNil() {
-						value = nil
-					} else {
-						value = f.Elem().Interface()
-					}
-				}
-
-				// Special formatting for each type
-				switch f.Kind() {
-				case reflect.String:
-					if value == "" {
-						value = emptyValue
-					}
-				case reflect.Bool:
-					if value == true {
-						value = "yes"
-					} else {
-						value = "no"
-					}
-				case reflect.Slice:
-					value = strings.Join(value.([]string), ", ")
-				}
-
-				// Format time fields
-				if tags != "" {
-					if _, ok := tags.Lookup("timefield"); ok {
-						if value != nil {
-							value = prettyTime(value.(int64))
-						}
-					}
-				}
-
-				// Format nil pointers
-				if value == nil {
-					value = emptyValue
-				}
-
+				value := formatField(f, tags)
 				padding := strings.Repeat(" ", nestingLevel*2)
 				output = append(output, fmt.Sprintf("%s%s:\t%v\t\n", padding, title, value))
 			}
 		}
+	} else if isTable(data) {
+		output = append(output, sliceToTable(v)...)
 	} else {
 		// Just forward data for non-structs
 		output = append(output, fmt.Sprintf("%v\n", v.Interface()))
@@ -135,7 +207,12 @@ func PrettyFormatter(dest io.Writer, data interface{}) error {
 		return err
 	}
 
-	writer.Init(dest, 24, 4, 1, ' ', 0)
+	// Tables have many columns, so keep them narrow
+	if isTable(data) {
+		writer.Init(dest, 0, 4, 2, ' ', 0)
+	} else {
+		writer.Init(dest, 24, 4, 1, ' ', 0)
+	}
 
 	for _, line := range as_lines {
 		fmt.Fprint(writer, line)
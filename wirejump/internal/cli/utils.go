@@ -39,3 +39,21 @@ func defaultVersionHandler(f string) error {
 func prettyTime(timestamp int64) string {
 	return time.Unix(timestamp, 0).Format(time.RFC1123)
 }
+
+// Format byte counter using binary units, like 1.5 MiB
+func prettyBytes(bytes int64) string {
+	const unit = 1024
+
+	if bytes < unit {
+		return fmt.Sprintf("%d B", bytes)
+	}
+
+	div, exp := int64(unit), 0
+
+	for n := bytes / unit; n >= unit; n /= unit {
+		div *= unit
+		exp++
+	}
+
+	return fmt.Sprintf("%.1f %ciB", float64(bytes)/float64(div), "KMGTPE"[exp])
+}
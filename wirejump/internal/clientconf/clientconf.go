@@ -0,0 +1,205 @@
+package clientconf
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+)
+
+// Client configs for downstream peers. Besides plain wg-quick config,
+// RouterOS script and OpenWRT UCI commands are supported; they follow
+// router setup guides from the docs, so interface names are the same.
+
+// Supported formats
+const (
+	FormatWgQuick  = "wg-quick"
+	FormatMikroTik = "mikrotik"
+	FormatOpenWRT  = "openwrt"
+)
+
+// All supported formats, for help and error messages
+var Formats = []string{FormatWgQuick, FormatMikroTik, FormatOpenWRT}
+
+// Interface names used by router guides
+const (
+	mikrotikInterface = "wirejump1"
+	openwrtInterface  = "wirejump"
+)
+
+// Used in configs instead of private key, which is not known to the server
+const PrivateKeyPlaceholder = "REPLACE_WITH_PRIVATE_KEY"
+
+// Keep NAT mappings alive; most of the clients are behind NAT
+const defaultKeepalive = 25
+
+// Everything needed to connect downstream peer to the server
+type ClientConfig struct {
+	// Peer private key, empty if it's only known to the peer
+	PrivateKey string `json:"private_key,omitempty"`
+
+	// Peer addresses with network prefix, like 172.16.1.2/24
+	Addresses []string `json:"addresses"`
+
+	// DNS servers, which is server itself
+	DNS []string `json:"dns"`
+
+	// Server public key
+	ServerKey string `json:"server_key"`
+
+	// Optional preshared key
+	PresharedKey string `json:"preshared_key,omitempty"`
+
+	// Server endpoint, like 1.2.3.4:51820
+	Endpoint string `json:"endpoint"`
+
+	// Addresses routed via server
+	AllowedIPs []string `json:"allowed_ips"`
+}
+
+// Check if format is supported
+func IsValidFormat(format string) bool {
+	for _, known := range Formats {
+		if format == known {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Render config in a given format
+func (c ClientConfig) Render(format string) (string, error) {
+	switch format {
+	case FormatWgQuick:
+		return c.WgQuick(), nil
+	case FormatMikroTik:
+		return c.MikroTik()
+	case FormatOpenWRT:
+		return c.OpenWRT()
+	default:
+		return "", fmt.Errorf("unknown config format '%s', should be one of: %s", format, strings.Join(Formats, ", "))
+	}
+}
+
+// Render wg-quick config
+func (c ClientConfig) WgQuick() string {
+	lines := []string{
+		"[Interface]",
+		fmt.Sprintf("PrivateKey = %s", c.PrivateKey),
+		fmt.Sprintf("Address = %s", strings.Join(c.Addresses, ", ")),
+	}
+
+	if len(c.DNS) > 0 {
+		lines = append(lines, fmt.Sprintf("DNS = %s", strings.Join(c.DNS, ", ")))
+	}
+
+	lines = append(lines,
+		"",
+		"[Peer]",
+		fmt.Sprintf("PublicKey = %s", c.ServerKey),
+	)
+
+	if c.PresharedKey != "" {
+		lines = append(lines, fmt.Sprintf("PresharedKey = %s", c.PresharedKey))
+	}
+
+	lines = append(lines,
+		fmt.Sprintf("Endpoint = %s", c.Endpoint),
+		fmt.Sprintf("AllowedIPs = %s", strings.Join(c.AllowedIPs, ", ")),
+		fmt.Sprintf("PersistentKeepalive = %d", defaultKeepalive),
+	)
+
+	return strings.Join(lines, "\n") + "\n"
+}
+
+// Render RouterOS 7 script
+func (c ClientConfig) MikroTik() (string, error) {
+	host, port, err := net.SplitHostPort(c.Endpoint)
+
+	if err != nil {
+		return "", errors.New("invalid server endpoint")
+	}
+
+	lines := []string{
+		fmt.Sprintf(`/interface/wireguard add name=%s private-key="%s" comment="WireJump: main interface"`, mikrotikInterface, c.PrivateKey),
+	}
+
+	for _, address := range c.Addresses {
+		command := "/ip/address add"
+
+		if strings.Contains(address, ":") {
+			command = "/ipv6/address add advertise=no"
+		}
+
+		lines = append(lines, fmt.Sprintf(`%s address=%s interface=%s comment="WireJump: local peer"`, command, address, mikrotikInterface))
+	}
+
+	psk := ""
+
+	if c.PresharedKey != "" {
+		psk = fmt.Sprintf(` preshared-key="%s"`, c.PresharedKey)
+	}
+
+	lines = append(lines,
+		fmt.Sprintf(
+			`/interface/wireguard/peers add allowed-address=%s endpoint-address=%s endpoint-port=%s interface=%s public-key="%s"%s persistent-keepalive=%ds comment="WireJump: server"`,
+			strings.Join(c.AllowedIPs, ","), host, port, mikrotikInterface, c.ServerKey, psk, defaultKeepalive,
+		),
+		fmt.Sprintf(`/ip/firewall/nat add action=masquerade chain=srcnat out-interface=%s comment="WireJump: masquerade"`, mikrotikInterface),
+	)
+
+	return strings.Join(lines, "\n") + "\n", nil
+}
+
+// Render OpenWRT UCI commands. Allowed IPs are not routed,
+// same as 'Table = off' in the guide, so routing is up to user
+func (c ClientConfig) OpenWRT() (string, error) {
+	host, port, err := net.SplitHostPort(c.Endpoint)
+
+	if err != nil {
+		return "", errors.New("invalid server endpoint")
+	}
+
+	iface := "network." + openwrtInterface
+	peer := fmt.Sprintf("network.@wireguard_%s[-1]", openwrtInterface)
+
+	lines := []string{
+		fmt.Sprintf("uci set %s=interface", iface),
+		fmt.Sprintf("uci set %s.proto='wireguard'", iface),
+		fmt.Sprintf("uci set %s.private_key='%s'", iface, c.PrivateKey),
+	}
+
+	for _, address := range c.Addresses {
+		lines = append(lines, fmt.Sprintf("uci add_list %s.addresses='%s'", iface, address))
+	}
+
+	for _, dns := range c.DNS {
+		lines = append(lines, fmt.Sprintf("uci add_list %s.dns='%s'", iface, dns))
+	}
+
+	lines = append(lines,
+		fmt.Sprintf("uci add network wireguard_%s", openwrtInterface),
+		fmt.Sprintf("uci set %s.description='WireJump server'", peer),
+		fmt.Sprintf("uci set %s.public_key='%s'", peer, c.ServerKey),
+	)
+
+	if c.PresharedKey != "" {
+		lines = append(lines, fmt.Sprintf("uci set %s.preshared_key='%s'", peer, c.PresharedKey))
+	}
+
+	lines = append(lines,
+		fmt.Sprintf("uci set %s.endpoint_host='%s'", peer, host),
+		fmt.Sprintf("uci set %s.endpoint_port='%s'", peer, port),
+		fmt.Sprintf("uci set %s.persistent_keepalive='%d'", peer, defaultKeepalive),
+		fmt.Sprintf("uci set %s.route_allowed_ips='0'", peer),
+	)
+
+	for _, allowed := range c.AllowedIPs {
+		lines = append(lines, fmt.Sprintf("uci add_list %s.allowed_ips='%s'", peer, allowed))
+	}
+
+	lines = append(lines, "uci commit network", "/etc/init.d/network reload")
+
+	return strings.Join(lines, "\n") + "\n", nil
+}
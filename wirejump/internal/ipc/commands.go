@@ -1,5 +1,7 @@
 package ipc
 
+import "wirejump/internal/clientconf"
+
 // Every command consists of Request-Reply pair. Error message
 // is encoded separately, as well as default status message, thus
 // Reply parts only specify information which should be printed
@@ -15,9 +17,16 @@ type ConnectionStatus struct {
 
 // AccountStatus contains some account information
 type ProviderStatus struct {
-	Name              *string `json:"name"`
-	PreferredLocation *string `json:"preferred" pretty:"Preferred location"`
-	AccountExpires    *int64  `json:"expires" pretty:"Account expires" timefield:""`
+	Name               *string  `json:"name"`
+	PreferredLocations []string `json:"preferred" pretty:"Preferred locations"`
+	AccountExpires     *int64   `json:"expires" pretty:"Account expires" timefield:""`
+	AccountState       *string  `json:"account_state" pretty:"Account state"`
+}
+
+// RotationStatus represents scheduled rotation status
+type RotationStatus struct {
+	Enabled      bool   `json:"enabled"`
+	NextRotation *int64 `json:"next" pretty:"Next rotation" timefield:""`
 }
 
 // Command with no params
@@ -30,6 +39,17 @@ type EmptyCommandReply struct {
 	Empty int
 }
 
+// StandbyStatus represents standby upstream status
+type StandbyStatus struct {
+	Enabled     bool    `json:"enabled"`
+	Online      bool    `json:"online"`
+	Promoted    bool    `json:"promoted" pretty:"Carries traffic"`
+	Location    *string `json:"location"`
+	ActiveSince *int64  `json:"active_since" pretty:"Active since" timefield:""`
+	Country     *string `json:"country"`
+	City        *string `json:"city"`
+}
+
 // Version command
 type VersionCommandRequest EmptyCommandRequest
 
@@ -45,6 +65,8 @@ type StatusCommandRequest EmptyCommandRequest
 type StatusCommandReply struct {
 	Upstream ConnectionStatus `json:"upstream" pretty:"Upstream connection"`
 	Provider ProviderStatus   `json:"provider"`
+	Rotation RotationStatus   `json:"rotation" pretty:"Scheduled rotation"`
+	Standby  StandbyStatus    `json:"standby" pretty:"Standby upstream"`
 }
 
 // List command
@@ -55,20 +77,59 @@ type ListProvidersReply struct {
 	Providers []string `json:"providers"`
 }
 
+// Server filter changes, unset ones are kept as is; lists
+// are comma separated, and 'none' value clears them
+type FilterOptions struct {
+	OnlyOwned       *bool
+	ExcludeHosting  *string
+	RequireFeatures *string
+}
+
 // Servers command
 type ServersCommandRequest struct {
 	ForceUpdate bool
 	Preferred   string
+	Exclude     *string
 	Reset       bool
+	Location    string
+	BestServers *int
+	ResetBest   bool
+	Latency     bool
+	Strategy    string
+	Tree        bool
+	Filter      FilterOptions
 }
 
 // Server reply
 type ServersCommandReply struct {
-	Servers           []string `json:"servers"`
-	LastUpdated       *int64   `json:"updated" pretty:"Last updated" timefield:""`
-	PreferredLocation *string  `json:"preferred" pretty:"Preferred location"`
+	Servers            []string `json:"servers"`
+	LastUpdated        *int64   `json:"updated" pretty:"Last updated" timefield:""`
+	PreferredLocations []string `json:"preferred" pretty:"Preferred locations"`
+	Excluded           []string `json:"excluded" pretty:"Excluded locations"`
+	Selection          []string `json:"selection" pretty:"Server selection"`
 }
 
+// Measured server latency
+type ServerLatency struct {
+	Hostname string `json:"hostname"`
+	City     string `json:"city"`
+	Latency  string `json:"latency"`
+	Checked  *int64 `json:"checked" timefield:""`
+}
+
+// Server latency reply, fastest servers first
+type ServersLatencyReply []ServerLatency
+
+// Location tree node: country, city or a single server
+type LocationNode struct {
+	Location string         `json:"location"`
+	Name     string         `json:"name"`
+	Children []LocationNode `json:"children"`
+}
+
+// Location tree reply
+type ServersTreeReply []LocationNode
+
 // Setup command
 type SetupCommandRequest struct {
 	Provider string `json:"provider"`
@@ -84,6 +145,11 @@ type ConnectCommandRequest struct {
 	LocationOverride *string
 	PreserveKeys     bool
 	Disconnect       bool
+	BestServers      *int
+	Strategy         string
+	Filter           FilterOptions
+	// Set by supervisor when current server stopped responding
+	Failed bool
 }
 
 // Connect reply
@@ -91,24 +157,138 @@ type ConnectCommandReply EmptyCommandReply
 
 const PeerCommandAddPeer = 1
 const PeerCommandDeletePeer = 2
+const PeerCommandUpdatePeer = 3
+
+// Peer isolation changes for update command
+const PeerIsolationKeep = 0
+const PeerIsolationOn = 1
+const PeerIsolationOff = 2
 
 // Peer command
 type PeerCommandRequest struct {
-	Operation int
-	Pubkey    string
-	Isolated  bool
+	Operation   int
+	Pubkey      string
+	Isolated    bool
+	Name        string
+	Description string
+	Owner       string
+	Generate    bool
+	Format      string
+	NeedConfig  bool
+	Preshared   bool
+	Addresses   []string
+	Allocation  string
+	NewPubkey   string
+	NewName     string
+	Isolation   int
+	Exit        string
 }
 
 // Peer reply
 type PeerCommandReply struct {
 	Peer struct {
-		IPv4Address string `json:"ipv4_address" pretty:"IPv4 Address"`
-		Isolated    bool   `json:"isolated"`
+		Name         *string `json:"name"`
+		Pubkey       string  `json:"pubkey" pretty:"Public key"`
+		IPv4Address  string  `json:"ipv4_address" pretty:"IPv4 Address"`
+		IPv6Address  *string `json:"ipv6_address" pretty:"IPv6 Address"`
+		Isolated     bool    `json:"isolated"`
+		PresharedKey *string `json:"preshared_key" pretty:"Preshared key"`
+		Exit         *string `json:"exit" pretty:"Exit group"`
 	} `json:"peer"`
+	Config *string                  `json:"config" pretty:"-"`
+	Client *clientconf.ClientConfig `json:"client" pretty:"-"`
 }
 
+// Peer list command; name is optional
+type PeerListRequest struct {
+	Name string
+}
+
+// Single downstream peer with its runtime stats
+type PeerInfo struct {
+	Name            *string `json:"name"`
+	Pubkey          string  `json:"pubkey" pretty:"Public key"`
+	IPv4Address     *string `json:"ipv4_address" pretty:"IPv4 Address"`
+	IPv6Address     *string `json:"ipv6_address" pretty:"IPv6 Address"`
+	Isolated        bool    `json:"isolated"`
+	Endpoint        *string `json:"endpoint"`
+	LatestHandshake *int64  `json:"latest_handshake" pretty:"Latest handshake" timefield:""`
+	RxBytes         int64   `json:"rx_bytes" pretty:"Received" bytesfield:""`
+	TxBytes         int64   `json:"tx_bytes" pretty:"Sent" bytesfield:""`
+	Description     *string `json:"description"`
+	Owner           *string `json:"owner"`
+	Exit            *string `json:"exit" pretty:"Exit group"`
+}
+
+// Peer list reply
+type PeerListReply []PeerInfo
+
+const ExitCommandAdd = 1
+const ExitCommandRemove = 2
+const ExitCommandConnect = 3
+const ExitCommandDisconnect = 4
+
+// Exit group command; location is optional
+type ExitCommandRequest struct {
+	Operation int
+	Name      string
+	Location  string
+}
+
+// Single exit group with its connection status
+type ExitInfo struct {
+	Name        string  `json:"name"`
+	Interface   string  `json:"interface"`
+	Location    *string `json:"location"`
+	Online      bool    `json:"online"`
+	ActiveSince *int64  `json:"active_since" pretty:"Active since" timefield:""`
+	Country     *string `json:"country"`
+	City        *string `json:"city"`
+	Peers       int     `json:"peers"`
+}
+
+// Exit group list command
+type ExitListRequest EmptyCommandRequest
+
+// Exit group list reply
+type ExitListReply []ExitInfo
+
+// Standby command; status is shown if nothing is requested
+type StandbyCommandRequest struct {
+	Enable   bool
+	Disable  bool
+	Connect  bool
+	Failover bool
+	Location *string
+}
+
+// Standby reply
+type StandbyCommandReply StandbyStatus
+
 // Reset command
 type ResetCommandRequest EmptyCommandRequest
 
 // Reset reply
 type ResetCommandReply EmptyCommandReply
+
+// Schedule command. Pointers are used for the settings
+// which should be left intact if they are not provided
+type ScheduleCommandRequest struct {
+	Enable     bool
+	Disable    bool
+	Reset      bool
+	Interval   *string
+	Window     *string
+	Locations  *string
+	RotateKeys *bool
+}
+
+// Schedule reply
+type ScheduleCommandReply struct {
+	Enabled      bool     `json:"enabled"`
+	Interval     string   `json:"interval"`
+	Window       *string  `json:"window" pretty:"Time window"`
+	Locations    []string `json:"locations"`
+	RotateKeys   bool     `json:"rotate_keys" pretty:"Rotate keys"`
+	NextRotation *int64   `json:"next" pretty:"Next rotation" timefield:""`
+}
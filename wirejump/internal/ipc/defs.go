@@ -10,6 +10,13 @@ type IpcWrappedHandler int
 // IpcHandler will be used by actual (unwrapped) RPC methods
 type IpcHandler struct{}
 
+// IpcPreparer can be implemented by handler to do slow work, like network
+// probes, before a command is executed. It's called without server lock and
+// app state lock held, so state should only be accessed via LockedExec
+type IpcPreparer interface {
+	Prepare(name string, params interface{})
+}
+
 // IpcCommand is a command request struct sent by client wrapper
 type IpcCommand struct {
 	Function   string
@@ -4,6 +4,7 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
+	"log"
 	"net/rpc"
 	"reflect"
 	"wirejump/internal/state"
@@ -15,10 +16,48 @@ var rpcClient *rpc.Client
 // Used to check if server is already busy
 var isLocked = make(chan bool, 1)
 
+// Returned by LockedExec if server is busy
+var ErrLocked = errors.New("server is locked by another operation")
+
 func init() {
 	isLocked <- false
 }
 
+// Try to acquire server lock without blocking
+func tryLock() bool {
+	select {
+	case <-isLocked:
+		return true
+	default:
+		return false
+	}
+}
+
+// Release server lock
+func unlock() {
+	isLocked <- false
+}
+
+// LockedExec will run given function with the same guarantees as
+// IPC handlers have: server lock is held and app state is locked.
+// It's supposed to be used by background tasks of the server, which
+// should never run concurrently with user commands. Returns ErrLocked
+// if server is busy, so caller can retry later.
+func LockedExec(fn func(*state.AppState) error) error {
+	if !tryLock() {
+		return ErrLocked
+	}
+
+	defer unlock()
+
+	appState := state.GetStateInstance()
+
+	appState.Mutex.Lock()
+	defer appState.Mutex.Unlock()
+
+	return fn(&appState.State)
+}
+
 func GetRPCClient() *rpc.Client {
 	return rpcClient
 }
@@ -85,17 +124,22 @@ func LocalExec(handler interface{}, request IpcCommand, reply *IpcReply) error {
 		return errors.New("ipc.LocalExec: reply is nil")
 	}
 
+	// Let handler prepare for the command before anything is locked
+	if preparer, ok := handler.(IpcPreparer); ok {
+		if params := GuessParamsType(request.Function); params != nil {
+			if err := json.Unmarshal(request.ParamsJSON, params); err == nil {
+				preparer.Prepare(request.Function, params)
+			}
+		}
+	}
+
 	// Force single user mode: terminate any other operation
 	// with an error, if another handler is already running
-	select {
-	case <-isLocked:
-	default:
+	if !tryLock() {
 		return errors.New("server is currently locked by another operation. Please try again later")
 	}
 
-	defer func() {
-		isLocked <- false
-	}()
+	defer unlock()
 
 	// Lookup desired function
 	name := request.Function
@@ -135,7 +179,13 @@ func LocalExec(handler interface{}, request IpcCommand, reply *IpcReply) error {
 		// Call
 		result := method.Call(args)[0].Interface()
 
-		// Place for post-middleware here
+		// Persist state, so it survives daemon restarts. Failure to do so
+		// is not fatal for the command itself, since it has been executed
+		if IsMutatingCommand(name) {
+			if err := state.SaveState(&appState.State); err != nil {
+				log.Println("failed to save state:", err)
+			}
+		}
 
 		// Create reply
 		output := IpcReply{}
@@ -23,6 +23,14 @@ func GuessParamsType(name string) interface{} {
 		return &ServersCommandRequest{}
 	case "ManagePeers":
 		return &PeerCommandRequest{}
+	case "ListPeers":
+		return &PeerListRequest{}
+	case "ManageExits":
+		return &ExitCommandRequest{}
+	case "ListExits":
+		return &ExitListRequest{}
+	case "ManageStandby":
+		return &StandbyCommandRequest{}
 	case "Status":
 		return &StatusCommandRequest{}
 	case "Connect":
@@ -31,7 +39,20 @@ func GuessParamsType(name string) interface{} {
 		return &ResetCommandRequest{}
 	case "Version":
 		return &VersionCommandRequest{}
+	case "ManageSchedule":
+		return &ScheduleCommandRequest{}
 	default:
 		return nil
 	}
 }
+
+// This function will return true for the commands which change
+// application state, so it has to be saved after they are executed
+func IsMutatingCommand(name string) bool {
+	switch name {
+	case "SetupProvider", "ManageServers", "Connect", "Reset", "ManageSchedule", "ManageExits", "ManageStandby":
+		return true
+	default:
+		return false
+	}
+}
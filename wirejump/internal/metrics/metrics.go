@@ -0,0 +1,251 @@
+package metrics
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Minimal implementation of Prometheus text exposition format:
+// https://prometheus.io/docs/instrumenting/exposition_formats/
+//
+// Only counters, gauges and summaries without quantiles are supported,
+// which is enough for the daemon. Metrics are registered once, and then
+// updated by name; updating unknown metric will register it as untyped.
+
+// Metric kinds
+const (
+	KindCounter = "counter"
+	KindGauge   = "gauge"
+	KindSummary = "summary"
+	KindUntyped = "untyped"
+)
+
+// Summary series suffixes
+const (
+	summarySum   = "_sum"
+	summaryCount = "_count"
+)
+
+// Content type of the text format, should be used in HTTP replies
+const ContentType = "text/plain; version=0.0.4; charset=utf-8"
+
+// Metric labels, like {"peer": "abc"}
+type Labels map[string]string
+
+// Single time series within a metric
+type series struct {
+	Labels string
+	Value  float64
+	Count  float64
+}
+
+// All series of a single metric
+type family struct {
+	Help   string
+	Kind   string
+	Series map[string]*series
+}
+
+// Holds all metrics
+type Registry struct {
+	mutex    sync.Mutex
+	families map[string]*family
+}
+
+// Registry used by the daemon
+var Default = NewRegistry()
+
+// Create empty registry
+func NewRegistry() *Registry {
+	return &Registry{families: map[string]*family{}}
+}
+
+// Escape label value according to the format
+func escapeLabel(value string) string {
+	value = strings.ReplaceAll(value, `\`, `\\`)
+	value = strings.ReplaceAll(value, `"`, `\"`)
+
+	return strings.ReplaceAll(value, "\n", `\n`)
+}
+
+// Format labels in a stable order, so they can be used as series key
+func (l Labels) String() string {
+	if len(l) == 0 {
+		return ""
+	}
+
+	keys := []string{}
+	pairs := []string{}
+
+	for key := range l {
+		keys = append(keys, key)
+	}
+
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		pairs = append(pairs, fmt.Sprintf(`%s="%s"`, key, escapeLabel(l[key])))
+	}
+
+	return "{" + strings.Join(pairs, ",") + "}"
+}
+
+// Format value, special values are spelled as the format requires
+func formatValue(value float64) string {
+	return strconv.FormatFloat(value, 'g', -1, 64)
+}
+
+// Register new metric. Registering existing metric again updates its help and kind
+func (r *Registry) Register(name string, kind string, help string) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	if existing, ok := r.families[name]; ok {
+		existing.Kind = kind
+		existing.Help = help
+
+		return
+	}
+
+	r.families[name] = &family{Help: help, Kind: kind, Series: map[string]*series{}}
+}
+
+// Get series for update, registering metric if needed. Should be called with lock held
+func (r *Registry) getSeries(name string, labels Labels) *series {
+	metric, ok := r.families[name]
+
+	if !ok {
+		metric = &family{Kind: KindUntyped, Series: map[string]*series{}}
+		r.families[name] = metric
+	}
+
+	key := labels.String()
+
+	if _, ok := metric.Series[key]; !ok {
+		metric.Series[key] = &series{Labels: key}
+	}
+
+	return metric.Series[key]
+}
+
+// Increase counter by a given value
+func (r *Registry) Add(name string, labels Labels, value float64) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	r.getSeries(name, labels).Value += value
+}
+
+// Set gauge (or externally maintained counter) to a given value
+func (r *Registry) Set(name string, labels Labels, value float64) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	r.getSeries(name, labels).Value = value
+}
+
+// Add single observation to a summary
+func (r *Registry) Observe(name string, labels Labels, value float64) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	observed := r.getSeries(name, labels)
+	observed.Value += value
+	observed.Count++
+}
+
+// Remove all series of a metric, so stale ones (like removed peers) are gone
+func (r *Registry) Reset(name string) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	if metric, ok := r.families[name]; ok {
+		metric.Series = map[string]*series{}
+	}
+}
+
+// Write all metrics in text format
+func (r *Registry) WriteText(w io.Writer) error {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	names := []string{}
+
+	for name := range r.families {
+		names = append(names, name)
+	}
+
+	sort.Strings(names)
+
+	for _, name := range names {
+		metric := r.families[name]
+		keys := []string{}
+
+		for key := range metric.Series {
+			keys = append(keys, key)
+		}
+
+		sort.Strings(keys)
+
+		if metric.Help != "" {
+			if _, err := fmt.Fprintf(w, "# HELP %s %s\n", name, strings.ReplaceAll(metric.Help, "\n", " ")); err != nil {
+				return err
+			}
+		}
+
+		if _, err := fmt.Fprintf(w, "# TYPE %s %s\n", name, metric.Kind); err != nil {
+			return err
+		}
+
+		for _, key := range keys {
+			current := metric.Series[key]
+
+			if metric.Kind == KindSummary {
+				if _, err := fmt.Fprintf(w, "%s%s%s %s\n", name, summarySum, current.Labels, formatValue(current.Value)); err != nil {
+					return err
+				}
+
+				if _, err := fmt.Fprintf(w, "%s%s%s %s\n", name, summaryCount, current.Labels, formatValue(current.Count)); err != nil {
+					return err
+				}
+
+				continue
+			}
+
+			if _, err := fmt.Fprintf(w, "%s%s %s\n", name, current.Labels, formatValue(current.Value)); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// Shortcuts for the default registry
+func Register(name string, kind string, help string) {
+	Default.Register(name, kind, help)
+}
+
+func Add(name string, labels Labels, value float64) {
+	Default.Add(name, labels, value)
+}
+
+func Set(name string, labels Labels, value float64) {
+	Default.Set(name, labels, value)
+}
+
+func Observe(name string, labels Labels, value float64) {
+	Default.Observe(name, labels, value)
+}
+
+func Reset(name string) {
+	Default.Reset(name)
+}
+
+func WriteText(w io.Writer) error {
+	return Default.WriteText(w)
+}
@@ -0,0 +1,192 @@
+package network
+
+import (
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/netip"
+	"sort"
+)
+
+// Address allocation modes: random free address, or the lowest free one
+const (
+	AllocationRandom = "random"
+	AllocationNext   = "next"
+)
+
+// Check if allocation mode is known; empty one means default
+func IsValidAllocation(mode string) bool {
+	return mode == "" || mode == AllocationRandom || mode == AllocationNext
+}
+
+// Address allocator for a single prefix. Only taken addresses are stored,
+// sorted, so free ones are the gaps between them; this way even IPv6 /64
+// is handled without enumerating it. Network address, IPv4 broadcast
+// address and server address itself are never given out.
+type Allocator struct {
+	prefix netip.Prefix
+	first  netip.Addr
+	taken  []netip.Addr
+}
+
+// Create allocator for a given prefix. Prefix address is the server one,
+// like 172.16.1.1/24; occupied addresses outside of prefix are ignored
+func NewAllocator(prefix netip.Prefix, occupied []netip.Addr) *Allocator {
+	a := Allocator{
+		prefix: prefix.Masked(),
+		first:  prefix.Masked().Addr(),
+		taken:  []netip.Addr{},
+	}
+
+	reserved := []netip.Addr{prefix.Addr()}
+
+	// Tiny point-to-point prefixes have neither network nor broadcast address
+	if a.hostBits() >= 2 {
+		reserved = append(reserved, a.first)
+
+		if a.first.Is4() {
+			reserved = append(reserved, a.last())
+		}
+	}
+
+	for _, addr := range append(reserved, occupied...) {
+		addr = addr.Unmap()
+
+		if a.prefix.Contains(addr) {
+			a.taken = append(a.taken, addr)
+		}
+	}
+
+	sort.Slice(a.taken, func(i, j int) bool {
+		return a.taken[i].Less(a.taken[j])
+	})
+
+	// Remove duplicates, so free addresses are counted right
+	unique := a.taken[:0]
+
+	for i, addr := range a.taken {
+		if i == 0 || addr != a.taken[i-1] {
+			unique = append(unique, addr)
+		}
+	}
+
+	a.taken = unique
+
+	return &a
+}
+
+// Number of host bits in the prefix
+func (a *Allocator) hostBits() int {
+	return a.first.BitLen() - a.prefix.Bits()
+}
+
+// The last address of the prefix
+func (a *Allocator) last() netip.Addr {
+	return a.offset(new(big.Int).Sub(a.size(), big.NewInt(1)))
+}
+
+// Total number of addresses in the prefix
+func (a *Allocator) size() *big.Int {
+	return new(big.Int).Lsh(big.NewInt(1), uint(a.hostBits()))
+}
+
+// Address at the given offset from the prefix start
+func (a *Allocator) offset(n *big.Int) netip.Addr {
+	value := new(big.Int).SetBytes(a.first.AsSlice())
+	value.Add(value, n)
+
+	addr, _ := netip.AddrFromSlice(value.FillBytes(make([]byte, a.first.BitLen()/8)))
+
+	return addr
+}
+
+// Check whether address is taken
+func (a *Allocator) isTaken(addr netip.Addr) bool {
+	index := sort.Search(len(a.taken), func(i int) bool {
+		return !a.taken[i].Less(addr)
+	})
+
+	return index < len(a.taken) && a.taken[index] == addr
+}
+
+// Number of free addresses left
+func (a *Allocator) Free() *big.Int {
+	return new(big.Int).Sub(a.size(), big.NewInt(int64(len(a.taken))))
+}
+
+// Get the lowest free address. Allocation is deterministic, so
+// the same peer gets the same address if it's re-added
+func (a *Allocator) Next() (netip.Addr, error) {
+	if a.Free().Sign() <= 0 {
+		return netip.Addr{}, errors.New("IP pool is exhausted")
+	}
+
+	candidate := a.first
+
+	// Walk taken addresses until there's a gap
+	for _, taken := range a.taken {
+		if taken != candidate {
+			break
+		}
+
+		candidate = candidate.Next()
+	}
+
+	return candidate, nil
+}
+
+// Get random free address. Random index among free addresses is picked,
+// then shifted by the number of taken addresses before it
+func (a *Allocator) Random() (netip.Addr, error) {
+	free := a.Free()
+
+	if free.Sign() <= 0 {
+		return netip.Addr{}, errors.New("IP pool is exhausted")
+	}
+
+	index, err := rand.Int(rand.Reader, free)
+
+	if err != nil {
+		return netip.Addr{}, err
+	}
+
+	candidate := a.offset(index)
+
+	for _, taken := range a.taken {
+		if candidate.Less(taken) {
+			break
+		}
+
+		candidate = candidate.Next()
+	}
+
+	return candidate, nil
+}
+
+// Check whether requested address can be given out
+func (a *Allocator) Check(addr netip.Addr) error {
+	addr = addr.Unmap()
+
+	if !a.prefix.Contains(addr) {
+		return fmt.Errorf("address %s is outside of %s network", addr, a.prefix)
+	}
+
+	if a.isTaken(addr) {
+		return fmt.Errorf("address %s is reserved or already taken", addr)
+	}
+
+	return nil
+}
+
+// Get address using the given allocation mode
+func (a *Allocator) Allocate(mode string) (netip.Addr, error) {
+	switch mode {
+	case "", AllocationRandom:
+		return a.Random()
+	case AllocationNext:
+		return a.Next()
+	default:
+		return netip.Addr{}, fmt.Errorf("unknown allocation mode '%s'", mode)
+	}
+}
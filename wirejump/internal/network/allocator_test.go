@@ -0,0 +1,263 @@
+package network
+
+import (
+	"math/rand"
+	"net/netip"
+	"testing"
+)
+
+// Addresses which must never be given out for a prefix
+func reservedAddrs(prefix netip.Prefix) []netip.Addr {
+	reserved := []netip.Addr{prefix.Addr()}
+	masked := prefix.Masked()
+
+	if masked.Addr().BitLen()-masked.Bits() >= 2 {
+		reserved = append(reserved, masked.Addr())
+
+		if masked.Addr().Is4() {
+			last := masked.Addr()
+
+			for next := last.Next(); masked.Contains(next); next = next.Next() {
+				last = next
+			}
+
+			reserved = append(reserved, last)
+		}
+	}
+
+	return reserved
+}
+
+// Check all allocator guarantees for an address it gave out
+func checkAllocated(t *testing.T, prefix netip.Prefix, addr netip.Addr, taken map[netip.Addr]bool) {
+	t.Helper()
+
+	if !prefix.Contains(addr) {
+		t.Fatalf("%s: allocated %s outside of prefix", prefix, addr)
+	}
+
+	for _, reserved := range reservedAddrs(prefix) {
+		if addr == reserved {
+			t.Fatalf("%s: allocated reserved address %s", prefix, addr)
+		}
+	}
+
+	if taken[addr] {
+		t.Fatalf("%s: allocated taken address %s", prefix, addr)
+	}
+}
+
+func TestAllocatorFree(t *testing.T) {
+	tests := []struct {
+		prefix string
+		free   int64
+	}{
+		{"172.16.1.1/24", 253},
+		{"172.16.1.1/30", 1},
+		{"172.16.1.0/31", 1},
+		{"172.16.1.1/32", 0},
+		{"fd00::1/120", 254},
+		{"fd00::1/127", 1},
+		{"fd00::1/128", 0},
+	}
+
+	for _, test := range tests {
+		a := NewAllocator(netip.MustParsePrefix(test.prefix), nil)
+
+		if free := a.Free().Int64(); free != test.free {
+			t.Errorf("%s: %d addresses free, want %d", test.prefix, free, test.free)
+		}
+	}
+}
+
+func TestAllocatorCheck(t *testing.T) {
+	prefix := netip.MustParsePrefix("172.16.1.1/24")
+	a := NewAllocator(prefix, []netip.Addr{
+		netip.MustParseAddr("172.16.1.10"),
+		netip.MustParseAddr("::ffff:172.16.1.11"),
+		netip.MustParseAddr("10.0.0.1"),
+	})
+
+	tests := []struct {
+		addr string
+		ok   bool
+	}{
+		{"172.16.1.0", false},
+		{"172.16.1.1", false},
+		{"172.16.1.255", false},
+		{"172.16.1.10", false},
+		{"172.16.1.11", false},
+		{"172.16.2.1", false},
+		{"10.0.0.1", false},
+		{"172.16.1.2", true},
+		{"::ffff:172.16.1.12", true},
+		{"172.16.1.254", true},
+	}
+
+	for _, test := range tests {
+		if err := a.Check(netip.MustParseAddr(test.addr)); (err == nil) != test.ok {
+			t.Errorf("%s: got %v, want ok: %v", test.addr, err, test.ok)
+		}
+	}
+
+	// Occupied addresses outside of prefix are not counted
+	if free := a.Free().Int64(); free != 251 {
+		t.Errorf("%d addresses free, want 251", free)
+	}
+}
+
+func TestAllocatorExhaustion(t *testing.T) {
+	prefixes := []string{"172.16.1.1/26", "172.16.1.66/27", "172.16.1.0/31", "fd00::1/122", "fd00::42/126"}
+
+	for _, mode := range []string{AllocationNext, AllocationRandom} {
+		for _, text := range prefixes {
+			prefix := netip.MustParsePrefix(text)
+			occupied := []netip.Addr{}
+			taken := map[netip.Addr]bool{}
+			a := NewAllocator(prefix, nil)
+			want := a.Free().Int64()
+
+			for a.Free().Sign() > 0 {
+				addr, err := a.Allocate(mode)
+
+				if err != nil {
+					t.Fatalf("%s/%s: %s", text, mode, err)
+				}
+
+				checkAllocated(t, prefix, addr, taken)
+
+				if err := a.Check(addr); err != nil {
+					t.Fatalf("%s/%s: allocated address does not pass check: %s", text, mode, err)
+				}
+
+				taken[addr] = true
+				occupied = append(occupied, addr)
+				a = NewAllocator(prefix, occupied)
+			}
+
+			if int64(len(taken)) != want {
+				t.Errorf("%s/%s: allocated %d addresses, want %d", text, mode, len(taken), want)
+			}
+
+			if _, err := a.Allocate(mode); err == nil {
+				t.Errorf("%s/%s: exhausted pool has given out an address", text, mode)
+			}
+		}
+	}
+}
+
+func TestAllocatorNextLowest(t *testing.T) {
+	prefix := netip.MustParsePrefix("172.16.1.1/26")
+	random := rand.New(rand.NewSource(1))
+
+	for i := 0; i < 200; i++ {
+		occupied := []netip.Addr{}
+		taken := map[netip.Addr]bool{}
+
+		// Duplicates are allowed on purpose
+		for j := random.Intn(64); j > 0; j-- {
+			addr := netip.AddrFrom4([4]byte{172, 16, 1, byte(random.Intn(64))})
+			occupied = append(occupied, addr)
+			taken[addr] = true
+		}
+
+		a := NewAllocator(prefix, occupied)
+		addr, err := a.Next()
+
+		// Lowest free address, found the hard way
+		var lowest netip.Addr
+
+		for candidate := prefix.Masked().Addr().Next(); prefix.Contains(candidate); candidate = candidate.Next() {
+			if !taken[candidate] && candidate != prefix.Addr() && candidate != netip.MustParseAddr("172.16.1.63") {
+				lowest = candidate
+				break
+			}
+		}
+
+		if !lowest.IsValid() {
+			if err == nil {
+				t.Fatalf("%v: got %s from exhausted pool", occupied, addr)
+			}
+
+			continue
+		}
+
+		if err != nil || addr != lowest {
+			t.Fatalf("%v: got %s, %v, want %s", occupied, addr, err, lowest)
+		}
+
+		for j := 0; j < 20; j++ {
+			addr, err := a.Random()
+
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			checkAllocated(t, prefix, addr, taken)
+		}
+	}
+}
+
+func TestAllocateUnknownMode(t *testing.T) {
+	a := NewAllocator(netip.MustParsePrefix("172.16.1.1/24"), nil)
+
+	if _, err := a.Allocate("lowest"); err == nil {
+		t.Errorf("unknown allocation mode has been accepted")
+	}
+}
+
+// Allocator with a lot of addresses taken at the prefix start
+func benchmarkAllocator(b *testing.B, prefix string, Taken int) *Allocator {
+	p := netip.MustParsePrefix(prefix)
+	occupied := []netip.Addr{}
+
+	for addr := p.Masked().Addr(); len(occupied) < Taken; addr = addr.Next() {
+		occupied = append(occupied, addr)
+	}
+
+	a := NewAllocator(p, occupied)
+	b.ResetTimer()
+
+	return a
+}
+
+func BenchmarkAllocatorNext4(b *testing.B) {
+	a := benchmarkAllocator(b, "10.0.0.1/8", 10000)
+
+	for i := 0; i < b.N; i++ {
+		a.Next()
+	}
+}
+
+func BenchmarkAllocatorRandom4(b *testing.B) {
+	a := benchmarkAllocator(b, "10.0.0.1/8", 10000)
+
+	for i := 0; i < b.N; i++ {
+		a.Random()
+	}
+}
+
+func BenchmarkAllocatorNext6(b *testing.B) {
+	a := benchmarkAllocator(b, "fd00::1/64", 10000)
+
+	for i := 0; i < b.N; i++ {
+		a.Next()
+	}
+}
+
+func BenchmarkAllocatorRandom6(b *testing.B) {
+	a := benchmarkAllocator(b, "fd00::1/64", 10000)
+
+	for i := 0; i < b.N; i++ {
+		a.Random()
+	}
+}
+
+func BenchmarkAllocatorCheck6(b *testing.B) {
+	a := benchmarkAllocator(b, "fd00::1/64", 10000)
+	addr := netip.MustParseAddr("fd00::ffff")
+
+	for i := 0; i < b.N; i++ {
+		a.Check(addr)
+	}
+}
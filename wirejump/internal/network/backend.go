@@ -0,0 +1,73 @@
+package network
+
+import (
+	"fmt"
+	"sort"
+)
+
+// Available backend names
+const (
+	BackendExec    = "exec"
+	BackendNetlink = "netlink"
+)
+
+// Backend does actual WireGuard interface management
+type Backend interface {
+	// Generate new private key
+	GeneratePrivateKey() (string, error)
+
+	// Derive public key from the private one
+	GeneratePublicKey(string) (string, error)
+
+	// Add peer or replace its allowed IPs; preshared key is optional
+	SetPeer(string, string, string, []string) error
+
+	// Remove peer
+	RemovePeer(string, string) error
+
+	// Bring interface up using its config file
+	BringUp(*InterfaceConfig) error
+
+	// Bring interface down
+	BringDown(*InterfaceConfig) error
+
+	// Get runtime state of all interface peers
+	GetPeers(string) ([]PeerStatus, error)
+
+	// Run interface hook, same as PostUp and others, with %i replaced
+	RunHook(string, string) error
+}
+
+// All known backends; platform-specific ones are registered on init
+var backends = map[string]Backend{
+	BackendExec: execBackend{},
+}
+
+// Backend used by all interfaces
+var activeBackend Backend = execBackend{}
+
+// Select backend which will be used for all interfaces
+func SelectBackend(name string) error {
+	backend, exists := backends[name]
+
+	if !exists {
+		return fmt.Errorf("unknown backend '%s', available: %v", name, GetBackendNames())
+	}
+
+	activeBackend = backend
+
+	return nil
+}
+
+// Get names of all known backends
+func GetBackendNames() []string {
+	names := []string{}
+
+	for name := range backends {
+		names = append(names, name)
+	}
+
+	sort.Strings(names)
+
+	return names
+}
@@ -11,16 +11,27 @@ const UpstreamGatewayConfig = "upstream_gateway"
 // Interface config file extension
 const InterfaceConfigSuffix = "conf"
 
-// Interface is either upstream or downstream
+// Interface is either upstream or downstream. Exit interfaces are
+// additional upstreams, used by some of the downstream peers only;
+// standby interface is a spare upstream, used when main one fails
 const (
 	InterfaceKindUpstream   = "upstream"
 	InterfaceKindDownstream = "downstream"
+	InterfaceKindExit       = "exit"
+	InterfaceKindStandby    = "standby"
 )
 
-// Interface script action can be either up or down
+// Interface script action can be either up or down. Exit interfaces also
+// have sync action to update their routing rules, and remove action to
+// clean them up completely. Standby interface has promote action to take
+// over upstream routing, and demote action to give it up
 const (
-	InterfaceScriptKindUp   = "up"
-	InterfaceScriptKindDown = "down"
+	InterfaceScriptKindUp      = "up"
+	InterfaceScriptKindDown    = "down"
+	InterfaceScriptKindSync    = "sync"
+	InterfaceScriptKindRemove  = "remove"
+	InterfaceScriptKindPromote = "promote"
+	InterfaceScriptKindDemote  = "demote"
 )
 
 // Type of InterfaceKind* settings
@@ -52,15 +63,39 @@ type InterfaceControl interface {
 	GeneratePublicKey() error
 	BringUp() error
 	BringDown() error
-	UpdatePeerConfig(string, string, []string) error
+	UpdatePeerConfig(string, string, string, []string) error
 	IsActive() (bool, error)
 	UpdateDefaultGateway(string) error
 	ReadConfig() (utils.INIFile, error)
 	WriteConfig(utils.INIFile) error
+	GetPeers() ([]PeerStatus, error)
+	RunScript(InterfaceKindType, ...string) error
+}
+
+// Runtime peer state as reported by WireGuard
+type PeerStatus struct {
+	// Peer public key
+	PublicKey string
+
+	// Current peer endpoint, empty if peer has never connected
+	Endpoint string
+
+	// Peer allowed IPs
+	AllowedIPs []string
+
+	// Latest handshake as UNIX timestamp, 0 if there was no handshake yet
+	LatestHandshake int64
+
+	// Received bytes
+	RxBytes int64
+
+	// Transmitted bytes
+	TxBytes int64
 }
 
 // Current interface state
 type NetworkState struct {
 	Upstream   *InterfaceConfig
 	Downstream *InterfaceConfig
+	Standby    *InterfaceConfig
 }
@@ -0,0 +1,158 @@
+package network
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os/exec"
+	"strconv"
+	"strings"
+)
+
+// Backend which uses wg and wg-quick tools. Privileged
+// commands are run via sudo, so sudoers entry is required
+type execBackend struct{}
+
+// Run privileged command and return its stdout or stderr as an error
+func runPrivileged(command ...string) (string, error) {
+	return runPrivilegedWithInput("", command...)
+}
+
+// Same as runPrivileged, but input is passed to the command via stdin.
+// Used for secrets, which should not be visible in process list
+func runPrivilegedWithInput(input string, command ...string) (string, error) {
+	stdout := new(strings.Builder)
+	stderr := new(strings.Builder)
+	cmd := exec.Command("sudo", command...)
+	cmd.Stdin = strings.NewReader(input)
+	cmd.Stdout = stdout
+	cmd.Stderr = stderr
+
+	if err := cmd.Run(); err != nil {
+		return "", errors.New(strings.Trim(stderr.String(), "\r\n"))
+	}
+
+	return stdout.String(), nil
+}
+
+func (b execBackend) GeneratePrivateKey() (string, error) {
+	out, err := exec.Command("wg", "genkey").Output()
+
+	if err != nil {
+		return "", err
+	}
+
+	return string(bytes.Trim(out, "\r\n")), nil
+}
+
+func (b execBackend) GeneratePublicKey(private string) (string, error) {
+	var out bytes.Buffer
+	cmd := exec.Command("wg", "pubkey")
+	cmd.Stdin = bytes.NewBuffer([]byte(private))
+	cmd.Stdout = &out
+
+	if err := cmd.Run(); err != nil {
+		return "", err
+	}
+
+	return strings.Trim(out.String(), "\r\n"), nil
+}
+
+func (b execBackend) SetPeer(name string, pubkey string, psk string, allowed []string) error {
+	joined := strings.Join(allowed, ",")
+
+	if psk != "" {
+		_, err := runPrivilegedWithInput(psk, "wg", "set", name, "peer", pubkey, "preshared-key", "/dev/stdin", "allowed-ips", joined)
+
+		return err
+	}
+
+	_, err := runPrivileged("wg", "set", name, "peer", pubkey, "allowed-ips", joined)
+
+	return err
+}
+
+func (b execBackend) RemovePeer(name string, pubkey string) error {
+	_, err := runPrivileged("wg", "set", name, "peer", pubkey, "remove")
+
+	return err
+}
+
+func (b execBackend) BringUp(i *InterfaceConfig) error {
+	target, err := i.getQuickTarget()
+
+	if err != nil {
+		return err
+	}
+
+	_, err = runPrivileged("wg-quick", "up", target)
+
+	return err
+}
+
+func (b execBackend) BringDown(i *InterfaceConfig) error {
+	target, err := i.getQuickTarget()
+
+	if err != nil {
+		return err
+	}
+
+	_, err = runPrivileged("wg-quick", "down", target)
+
+	return err
+}
+
+func (b execBackend) RunHook(name string, hook string) error {
+	_, err := runPrivileged("bash", "-c", strings.ReplaceAll(hook, "%i", name))
+
+	return err
+}
+
+func (b execBackend) GetPeers(name string) ([]PeerStatus, error) {
+	out, err := runPrivileged("wg", "show", name, "dump")
+
+	if err != nil {
+		return nil, err
+	}
+
+	peers := []PeerStatus{}
+	lines := strings.Split(strings.Trim(out, "\r\n"), "\n")
+
+	// First line describes interface itself, the rest are peers:
+	// pubkey, psk, endpoint, allowed-ips, handshake, rx, tx, keepalive
+	for _, line := range lines[1:] {
+		fields := strings.Split(line, "\t")
+
+		if len(fields) != 8 {
+			return nil, fmt.Errorf("unexpected wg output: %s", line)
+		}
+
+		peer := PeerStatus{
+			PublicKey: fields[0],
+		}
+
+		if fields[2] != "(none)" {
+			peer.Endpoint = fields[2]
+		}
+
+		if fields[3] != "(none)" {
+			peer.AllowedIPs = strings.Split(fields[3], ",")
+		}
+
+		numbers := []*int64{&peer.LatestHandshake, &peer.RxBytes, &peer.TxBytes}
+
+		for index, number := range numbers {
+			value, err := strconv.ParseInt(fields[4+index], 10, 64)
+
+			if err != nil {
+				return nil, fmt.Errorf("unexpected wg output: %s", err)
+			}
+
+			*number = value
+		}
+
+		peers = append(peers, peer)
+	}
+
+	return peers, nil
+}
@@ -0,0 +1,61 @@
+package network
+
+import (
+	"crypto/ecdh"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+)
+
+// Generate new Curve25519 private key, clamped and encoded
+// the same way as 'wg genkey' does
+func GeneratePrivateKey() (string, error) {
+	key := make([]byte, 32)
+
+	if _, err := rand.Read(key); err != nil {
+		return "", err
+	}
+
+	key[0] &= 248
+	key[31] = (key[31] & 127) | 64
+
+	return base64.StdEncoding.EncodeToString(key), nil
+}
+
+// Generate new preshared key, which is just 32 random bytes,
+// same as 'wg genpsk' does
+func GeneratePresharedKey() (string, error) {
+	key := make([]byte, 32)
+
+	if _, err := rand.Read(key); err != nil {
+		return "", err
+	}
+
+	return base64.StdEncoding.EncodeToString(key), nil
+}
+
+// Derive public key from the private one, same as 'wg pubkey' does
+func GeneratePublicKey(private string) (string, error) {
+	decoded, err := DecodeKey(private)
+
+	if err != nil {
+		return "", err
+	}
+
+	key, err := ecdh.X25519().NewPrivateKey(decoded)
+
+	if err != nil {
+		return "", err
+	}
+
+	return base64.StdEncoding.EncodeToString(key.PublicKey().Bytes()), nil
+}
+
+// Decode base64 key into raw bytes
+func DecodeKey(key string) ([]byte, error) {
+	if !IsValidKey(key) {
+		return nil, errors.New("invalid key")
+	}
+
+	return base64.StdEncoding.DecodeString(key)
+}
@@ -0,0 +1,67 @@
+package network
+
+import (
+	"testing"
+)
+
+func TestGeneratePublicKey(t *testing.T) {
+	// RFC 7748 section 6.1 vectors; 'wg pubkey' gives the same output
+	tests := []struct {
+		private string
+		public  string
+	}{
+		{"dwdtCnMYpX08FsFyUbJmRd9ML4frwJkqsXf7pR25LCo=", "hSDwCYkwp1R0i33ctD73Wg2/Og0mOBr066SpjqqbTmo="},
+		{"XasIfmJKikt54X+Lg4AO5m87sSkmGLb9HC+LJ/+I4Os=", "3p7bfXt9wbTTW2HC7OQ1Nz+DQ8hbeGdNrfx+FG+IK08="},
+	}
+
+	for _, test := range tests {
+		public, err := GeneratePublicKey(test.private)
+
+		if err != nil || public != test.public {
+			t.Errorf("%s: got %s, %v, want %s", test.private, public, err, test.public)
+		}
+	}
+
+	for _, invalid := range []string{"", "not a key", "dwdtCnMYpX08FsFyUbJmRd9ML4frwJkqsXf7pR25LA==", "dwdtCnMYpX08FsFyUbJmRd9ML4frwJkqsXf7pR25LCoA"} {
+		if _, err := GeneratePublicKey(invalid); err == nil {
+			t.Errorf("%q: invalid key is accepted", invalid)
+		}
+	}
+}
+
+func TestGeneratePrivateKey(t *testing.T) {
+	for i := 0; i < 100; i++ {
+		private, err := GeneratePrivateKey()
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		key, err := DecodeKey(private)
+
+		if err != nil {
+			t.Fatalf("%s: %s", private, err)
+		}
+
+		// Key must be clamped the same way as 'wg genkey' does
+		if key[0]&7 != 0 || key[31]&128 != 0 || key[31]&64 == 0 {
+			t.Fatalf("%s: key is not clamped", private)
+		}
+
+		if _, err := GeneratePublicKey(private); err != nil {
+			t.Fatalf("%s: %s", private, err)
+		}
+	}
+}
+
+func TestGeneratePresharedKey(t *testing.T) {
+	first, err := GeneratePresharedKey()
+
+	if err != nil || !IsValidKey(first) {
+		t.Fatalf("got %s, %v", first, err)
+	}
+
+	if second, _ := GeneratePresharedKey(); second == first {
+		t.Errorf("same preshared key is generated twice")
+	}
+}
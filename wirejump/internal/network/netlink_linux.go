@@ -0,0 +1,246 @@
+package network
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"sync/atomic"
+	"syscall"
+)
+
+// Minimal netlink implementation: just enough to talk to rtnetlink
+// and generic netlink WireGuard family. Attributes are encoded as
+// plain byte slices to keep things simple.
+
+// Netlink attribute flags
+const (
+	nlaFlagNested   = 0x8000
+	nlaFlagNetOrder = 0x4000
+	nlaTypeMask     = ^uint16(nlaFlagNested | nlaFlagNetOrder)
+)
+
+// Generic netlink controller
+const (
+	genlIdCtrl           = 0x10
+	genlCtrlCmdGetFamily = 3
+	genlCtrlAttrFamilyId = 1
+	genlCtrlAttrName     = 2
+	genlHeaderLen        = 4
+)
+
+// Receive buffer size, enough for any single netlink message
+const nlReceiveBuffer = 65536
+
+// Netlink message sequence number
+var nlSequence uint32
+
+// Single netlink attribute
+type nlAttr struct {
+	Type uint16
+	Data []byte
+}
+
+// Netlink socket wrapper
+type nlSocket struct {
+	fd int
+}
+
+// Align length to 4 bytes
+func nlAlign(length int) int {
+	return (length + syscall.NLA_ALIGNTO - 1) & ^(syscall.NLA_ALIGNTO - 1)
+}
+
+// Encode attribute with given data
+func nlEncodeAttr(kind uint16, data []byte) []byte {
+	length := syscall.SizeofRtAttr + len(data)
+	encoded := make([]byte, nlAlign(length))
+
+	binary.NativeEndian.PutUint16(encoded[0:2], uint16(length))
+	binary.NativeEndian.PutUint16(encoded[2:4], kind)
+	copy(encoded[syscall.SizeofRtAttr:], data)
+
+	return encoded
+}
+
+// Encode nested attribute containing given encoded attributes
+func nlEncodeNested(kind uint16, attrs ...[]byte) []byte {
+	data := []byte{}
+
+	for _, attr := range attrs {
+		data = append(data, attr...)
+	}
+
+	return nlEncodeAttr(kind|nlaFlagNested, data)
+}
+
+// Encode string attribute, null-terminated
+func nlEncodeString(kind uint16, value string) []byte {
+	return nlEncodeAttr(kind, append([]byte(value), 0))
+}
+
+// Encode integer attributes
+func nlEncodeUint16(kind uint16, value uint16) []byte {
+	data := make([]byte, 2)
+	binary.NativeEndian.PutUint16(data, value)
+
+	return nlEncodeAttr(kind, data)
+}
+
+func nlEncodeUint32(kind uint16, value uint32) []byte {
+	data := make([]byte, 4)
+	binary.NativeEndian.PutUint32(data, value)
+
+	return nlEncodeAttr(kind, data)
+}
+
+// Decode all attributes from the buffer
+func nlDecodeAttrs(data []byte) ([]nlAttr, error) {
+	attrs := []nlAttr{}
+
+	for len(data) >= syscall.SizeofRtAttr {
+		length := int(binary.NativeEndian.Uint16(data[0:2]))
+		kind := binary.NativeEndian.Uint16(data[2:4])
+
+		if length < syscall.SizeofRtAttr || length > len(data) {
+			return nil, errors.New("malformed netlink attribute")
+		}
+
+		attrs = append(attrs, nlAttr{
+			Type: kind & nlaTypeMask,
+			Data: data[syscall.SizeofRtAttr:length],
+		})
+
+		if nlAlign(length) >= len(data) {
+			break
+		}
+
+		data = data[nlAlign(length):]
+	}
+
+	return attrs, nil
+}
+
+// Open netlink socket for given protocol
+func nlOpen(protocol int) (*nlSocket, error) {
+	fd, err := syscall.Socket(syscall.AF_NETLINK, syscall.SOCK_RAW|syscall.SOCK_CLOEXEC, protocol)
+
+	if err != nil {
+		return nil, fmt.Errorf("cannot open netlink socket: %s", err)
+	}
+
+	if err := syscall.Bind(fd, &syscall.SockaddrNetlink{Family: syscall.AF_NETLINK}); err != nil {
+		syscall.Close(fd)
+
+		return nil, fmt.Errorf("cannot bind netlink socket: %s", err)
+	}
+
+	return &nlSocket{fd: fd}, nil
+}
+
+// Close netlink socket
+func (s *nlSocket) Close() error {
+	return syscall.Close(s.fd)
+}
+
+// Build acknowledged request message with netlink header
+func nlMessage(kind uint16, flags uint16, sequence uint32, payload []byte) []byte {
+	length := syscall.NLMSG_HDRLEN + len(payload)
+	message := make([]byte, length)
+
+	binary.NativeEndian.PutUint32(message[0:4], uint32(length))
+	binary.NativeEndian.PutUint16(message[4:6], kind)
+	binary.NativeEndian.PutUint16(message[6:8], flags|syscall.NLM_F_REQUEST|syscall.NLM_F_ACK)
+	binary.NativeEndian.PutUint32(message[8:12], sequence)
+	copy(message[syscall.NLMSG_HDRLEN:], payload)
+
+	return message
+}
+
+// Send request and collect all replies. Request is always acknowledged,
+// and for dump requests all parts of the reply are returned. Reply
+// payloads are returned as is, without netlink header
+func (s *nlSocket) Execute(kind uint16, flags uint16, payload []byte) ([][]byte, error) {
+	sequence := atomic.AddUint32(&nlSequence, 1)
+	request := nlMessage(kind, flags, sequence, payload)
+
+	if err := syscall.Sendto(s.fd, request, 0, &syscall.SockaddrNetlink{Family: syscall.AF_NETLINK}); err != nil {
+		return nil, fmt.Errorf("cannot send netlink request: %s", err)
+	}
+
+	replies := [][]byte{}
+	buffer := make([]byte, nlReceiveBuffer)
+
+	for {
+		received, _, err := syscall.Recvfrom(s.fd, buffer, 0)
+
+		if err != nil {
+			return nil, fmt.Errorf("cannot receive netlink reply: %s", err)
+		}
+
+		messages, err := syscall.ParseNetlinkMessage(buffer[:received])
+
+		if err != nil {
+			return nil, fmt.Errorf("cannot parse netlink reply: %s", err)
+		}
+
+		for _, message := range messages {
+			if message.Header.Seq != sequence {
+				continue
+			}
+
+			switch message.Header.Type {
+			case syscall.NLMSG_DONE:
+				return replies, nil
+			case syscall.NLMSG_ERROR:
+				if len(message.Data) < 4 {
+					return nil, errors.New("malformed netlink error")
+				}
+
+				// Zero error code is an acknowledgement
+				if code := int32(binary.NativeEndian.Uint32(message.Data[0:4])); code != 0 {
+					return nil, syscall.Errno(-code)
+				}
+
+				return replies, nil
+			default:
+				// Buffer is reused for the next read, so make a copy
+				replies = append(replies, append([]byte{}, message.Data...))
+			}
+		}
+	}
+}
+
+// Create generic netlink header
+func genlHeader(command uint8, version uint8) []byte {
+	return []byte{command, version, 0, 0}
+}
+
+// Resolve generic netlink family id by its name
+func (s *nlSocket) GetFamilyId(name string) (uint16, error) {
+	payload := append(genlHeader(genlCtrlCmdGetFamily, 1), nlEncodeString(genlCtrlAttrName, name)...)
+	replies, err := s.Execute(genlIdCtrl, 0, payload)
+
+	if err != nil {
+		return 0, fmt.Errorf("cannot resolve netlink family '%s': %s", name, err)
+	}
+
+	for _, reply := range replies {
+		if len(reply) < genlHeaderLen {
+			continue
+		}
+
+		attrs, err := nlDecodeAttrs(reply[genlHeaderLen:])
+
+		if err != nil {
+			return 0, err
+		}
+
+		for _, attr := range attrs {
+			if attr.Type == genlCtrlAttrFamilyId && len(attr.Data) >= 2 {
+				return binary.NativeEndian.Uint16(attr.Data), nil
+			}
+		}
+	}
+
+	return 0, fmt.Errorf("netlink family '%s' is not found", name)
+}
@@ -0,0 +1,151 @@
+package network
+
+import (
+	"bytes"
+	"encoding/binary"
+	"syscall"
+	"testing"
+)
+
+// Attribute header in host byte order
+func attrHeader(length uint16, kind uint16) []byte {
+	header := make([]byte, 4)
+	binary.NativeEndian.PutUint16(header[0:2], length)
+	binary.NativeEndian.PutUint16(header[2:4], kind)
+
+	return header
+}
+
+func TestNlAlign(t *testing.T) {
+	tests := []struct{ length, aligned int }{
+		{0, 0}, {1, 4}, {4, 4}, {5, 8}, {7, 8}, {8, 8}, {13, 16},
+	}
+
+	for _, test := range tests {
+		if aligned := nlAlign(test.length); aligned != test.aligned {
+			t.Errorf("%d: got %d, want %d", test.length, aligned, test.aligned)
+		}
+	}
+}
+
+func TestNlEncodeAttr(t *testing.T) {
+	uint16_data := make([]byte, 2)
+	binary.NativeEndian.PutUint16(uint16_data, 51820)
+
+	uint32_data := make([]byte, 4)
+	binary.NativeEndian.PutUint32(uint32_data, 1420)
+
+	tests := []struct {
+		name    string
+		encoded []byte
+		want    []byte
+	}{
+		{"empty", nlEncodeAttr(1, nil), attrHeader(4, 1)},
+		{"padded", nlEncodeAttr(2, []byte{1, 2, 3}), append(attrHeader(7, 2), 1, 2, 3, 0)},
+		{"aligned", nlEncodeAttr(3, []byte{1, 2, 3, 4}), append(attrHeader(8, 3), 1, 2, 3, 4)},
+		{"string", nlEncodeString(4, "wg0"), append(attrHeader(8, 4), 'w', 'g', '0', 0)},
+		{"uint16", nlEncodeUint16(5, 51820), append(append(attrHeader(6, 5), uint16_data...), 0, 0)},
+		{"uint32", nlEncodeUint32(6, 1420), append(attrHeader(8, 6), uint32_data...)},
+		{"nested", nlEncodeNested(7, nlEncodeAttr(1, []byte{9})), append(append(attrHeader(12, 7|nlaFlagNested), attrHeader(5, 1)...), 9, 0, 0, 0)},
+	}
+
+	for _, test := range tests {
+		if !bytes.Equal(test.encoded, test.want) {
+			t.Errorf("%s: got %v, want %v", test.name, test.encoded, test.want)
+		}
+	}
+}
+
+func TestNlDecodeAttrs(t *testing.T) {
+	encoded := bytes.Join([][]byte{
+		nlEncodeString(1, "wg0"),
+		nlEncodeAttr(2, []byte{1, 2, 3}),
+		nlEncodeNested(3, nlEncodeUint32(1, 7), nlEncodeAttr(2, nil)),
+		nlEncodeAttr(4|nlaFlagNetOrder, []byte{0, 1}),
+	}, nil)
+
+	attrs, err := nlDecodeAttrs(encoded)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := []nlAttr{
+		{Type: 1, Data: []byte{'w', 'g', '0', 0}},
+		{Type: 2, Data: []byte{1, 2, 3}},
+		{Type: 3, Data: bytes.Join([][]byte{nlEncodeUint32(1, 7), nlEncodeAttr(2, nil)}, nil)},
+		{Type: 4, Data: []byte{0, 1}},
+	}
+
+	if len(attrs) != len(want) {
+		t.Fatalf("got %d attributes, want %d", len(attrs), len(want))
+	}
+
+	for i := range want {
+		if attrs[i].Type != want[i].Type || !bytes.Equal(attrs[i].Data, want[i].Data) {
+			t.Errorf("attribute %d: got %v, want %v", i, attrs[i], want[i])
+		}
+	}
+
+	nested, err := nlDecodeAttrs(attrs[2].Data)
+
+	if err != nil || len(nested) != 2 || binary.NativeEndian.Uint32(nested[0].Data) != 7 || len(nested[1].Data) != 0 {
+		t.Errorf("nested attributes are decoded wrong: %v, %v", nested, err)
+	}
+
+	// Trailing bytes shorter than attribute header are ignored
+	if attrs, err := nlDecodeAttrs(append(nlEncodeAttr(1, []byte{1}), 0, 0)); err != nil || len(attrs) != 1 {
+		t.Errorf("got %v, %v, want single attribute", attrs, err)
+	}
+
+	malformed := [][]byte{
+		attrHeader(3, 1),
+		append(attrHeader(9, 1), 1, 2, 3, 4),
+		append(nlEncodeAttr(1, nil), attrHeader(2, 1)...),
+	}
+
+	for _, data := range malformed {
+		if _, err := nlDecodeAttrs(data); err == nil {
+			t.Errorf("%v: malformed attributes are decoded", data)
+		}
+	}
+}
+
+func TestNlMessage(t *testing.T) {
+	payload := append(genlHeader(genlCtrlCmdGetFamily, 1), nlEncodeString(genlCtrlAttrName, "wireguard")...)
+	message := nlMessage(genlIdCtrl, syscall.NLM_F_DUMP, 42, payload)
+
+	if len(message) != syscall.NLMSG_HDRLEN+4+16 {
+		t.Fatalf("message length is %d", len(message))
+	}
+
+	header := syscall.NlMsghdr{
+		Len:   binary.NativeEndian.Uint32(message[0:4]),
+		Type:  binary.NativeEndian.Uint16(message[4:6]),
+		Flags: binary.NativeEndian.Uint16(message[6:8]),
+		Seq:   binary.NativeEndian.Uint32(message[8:12]),
+		Pid:   binary.NativeEndian.Uint32(message[12:16]),
+	}
+
+	want := syscall.NlMsghdr{
+		Len:   uint32(len(message)),
+		Type:  genlIdCtrl,
+		Flags: syscall.NLM_F_DUMP | syscall.NLM_F_REQUEST | syscall.NLM_F_ACK,
+		Seq:   42,
+	}
+
+	if header != want {
+		t.Errorf("got header %+v, want %+v", header, want)
+	}
+
+	if !bytes.Equal(message[syscall.NLMSG_HDRLEN:syscall.NLMSG_HDRLEN+4], []byte{genlCtrlCmdGetFamily, 1, 0, 0}) {
+		t.Errorf("generic netlink header is wrong: %v", message[syscall.NLMSG_HDRLEN:])
+	}
+
+	// Message must be readable by the standard parser
+	parsed, err := syscall.ParseNetlinkMessage(message)
+
+	if err != nil || len(parsed) != 1 || !bytes.Equal(parsed[0].Data, payload) {
+		t.Errorf("message can't be parsed back: %v, %v", parsed, err)
+	}
+}
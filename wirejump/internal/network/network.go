@@ -1,15 +1,12 @@
 package network
 
 import (
-	"bytes"
 	"encoding/base64"
 	"errors"
 	"fmt"
-	"math/rand"
 	"net"
 	"net/netip"
 	"os"
-	"os/exec"
 	"path"
 	"regexp"
 	"strings"
@@ -43,56 +40,19 @@ func IsValidIP(ip string) bool {
 	return err == nil
 }
 
-// Get next free IP address for the given prefix with respect to already taken addresses.
-// Totally inefficient, but fun. Returns error if there are no free addresses left.
-func GetFreeIP(occupied []netip.Addr, prefix netip.Prefix) (netip.Addr, error) {
-	pool := []netip.Addr{}
-	source := prefix.Addr()
-
-	// Return early for huge ranges
-	if prefix.Bits() < 16 {
-		return netip.Addr{}, errors.New("really? you are using more than /16 pool here?")
-	}
-
-	// Get all possible addresses for this prefix, excluding occupied ones
-	for {
-		already := false
-		next := source.Next()
-
-		if prefix.Contains(next) {
-			for _, taken := range occupied {
-				if taken == next {
-					already = true
-					break
-				}
-			}
-
-			// Not occupied yet
-			if !already {
-				pool = append(pool, next)
-			}
-		} else {
-			break
-		}
-
-		// Occupied, advance
-		source = source.Next()
-	}
-
-	total := len(pool)
-
-	if total == 0 {
-		return netip.Addr{}, errors.New("IP pool is exhausted")
+// Check if interface kind is known
+func isKnownKind(kind InterfaceKindType) bool {
+	switch kind {
+	case InterfaceKindUpstream, InterfaceKindDownstream, InterfaceKindExit, InterfaceKindStandby:
+		return true
+	default:
+		return false
 	}
-
-	index := rand.Intn(total)
-
-	return pool[index], nil
 }
 
 // Create initial interface state and generate interface keys
 func CreateInterface(name string, kind InterfaceKindType) (InterfaceConfig, error) {
-	if kind != InterfaceKindUpstream && kind != InterfaceKindDownstream {
+	if !isKnownKind(kind) {
 		return InterfaceConfig{}, errors.New("invalid interface kind")
 	}
 
@@ -178,9 +138,13 @@ func CreateInterfaceFromConfig(name string, kind InterfaceKindType) (InterfaceCo
 		created.Address = addr
 	}
 
-	// Reset address if it's invalid
-	if !IsValidCIDR(created.Address) {
-		created.Address = ""
+	// Reset address if it's invalid; dual-stack interface has two of them
+	for _, part := range strings.Split(created.Address, ",") {
+		if !IsValidCIDR(strings.TrimSpace(part)) {
+			created.Address = ""
+
+			break
+		}
 	}
 
 	return created, nil
@@ -193,13 +157,34 @@ func (i *InterfaceConfig) GetInterfaceConfigPath() (string, error) {
 		return "", errors.New("interface ptr is nil")
 	}
 
-	if i.Kind != InterfaceKindUpstream && i.Kind != InterfaceKindDownstream {
+	// There can be many exit interfaces, so they are named after interface;
+	// same goes for standby, since wg-quick takes interface name from file
+	if i.Kind == InterfaceKindExit || i.Kind == InterfaceKindStandby {
+		if i.Name == "" {
+			return "", errors.New("interface name is undefined")
+		}
+
+		return path.Join(BasePath, "config", fmt.Sprintf("%s.%s", i.Name, InterfaceConfigSuffix)), nil
+	}
+
+	if !isKnownKind(i.Kind) {
 		return "", errors.New("interface kind is undefined")
 	}
 
 	return path.Join(BasePath, "config", fmt.Sprintf("%s.%s", i.Kind, InterfaceConfigSuffix)), nil
 }
 
+// Get wg-quick argument for this interface: either interface name, so config
+// is looked up in /etc/wireguard, or full config path for interfaces which
+// are not linked there. Interface name is derived from config file name then
+func (i *InterfaceConfig) getQuickTarget() (string, error) {
+	if i.Kind == InterfaceKindUpstream || i.Kind == InterfaceKindDownstream {
+		return i.Name, nil
+	}
+
+	return i.GetInterfaceConfigPath()
+}
+
 // Since connection is going to be managed by wg-quick tool, these scripts
 // should be added to interface config file to execute additional actions
 func (i *InterfaceConfig) GetInterfaceScriptPath(action InterfaceKindType) (string, error) {
@@ -207,11 +192,21 @@ func (i *InterfaceConfig) GetInterfaceScriptPath(action InterfaceKindType) (stri
 		return "", errors.New("interface ptr is nil")
 	}
 
-	if action != InterfaceScriptKindUp && action != InterfaceScriptKindDown {
+	switch action {
+	case InterfaceScriptKindUp, InterfaceScriptKindDown:
+	case InterfaceScriptKindSync, InterfaceScriptKindRemove:
+		if i.Kind != InterfaceKindExit {
+			return "", errors.New("interface script action is supported by exit interfaces only")
+		}
+	case InterfaceScriptKindPromote, InterfaceScriptKindDemote:
+		if i.Kind != InterfaceKindStandby {
+			return "", errors.New("interface script action is supported by standby interface only")
+		}
+	default:
 		return "", errors.New("unknown interface script action")
 	}
 
-	if i.Kind != InterfaceKindUpstream && i.Kind != InterfaceKindDownstream {
+	if !isKnownKind(i.Kind) {
 		return "", errors.New("interface kind is undefined")
 	}
 
@@ -224,13 +219,13 @@ func (i *InterfaceConfig) GeneratePrivateKey() error {
 		return errors.New("interface ptr is nil")
 	}
 
-	out, err := exec.Command("wg", "genkey").Output()
+	key, err := activeBackend.GeneratePrivateKey()
 
 	if err != nil {
 		return err
 	}
 
-	i.PrivateKey = string(bytes.Trim(out, "\r\n"))
+	i.PrivateKey = key
 
 	return nil
 }
@@ -241,22 +236,20 @@ func (i *InterfaceConfig) GeneratePublicKey() error {
 		return errors.New("interface ptr is nil")
 	}
 
-	var out bytes.Buffer
-	cmd := exec.Command("wg", "pubkey")
-	cmd.Stdin = bytes.NewBuffer([]byte(i.PrivateKey))
-	cmd.Stdout = &out
+	key, err := activeBackend.GeneratePublicKey(i.PrivateKey)
 
-	if err := cmd.Run(); err != nil {
+	if err != nil {
 		return err
 	}
 
-	i.PublicKey = strings.Trim(out.String(), "\r\n")
+	i.PublicKey = key
 
 	return nil
 }
 
-// Update interface configuration for the particular peer
-func (i *InterfaceConfig) UpdatePeerConfig(operation string, pubkey string, allowed []string) error {
+// Update interface configuration for the particular peer.
+// Preshared key is optional and only used when adding the peer
+func (i *InterfaceConfig) UpdatePeerConfig(operation string, pubkey string, psk string, allowed []string) error {
 	if i == nil {
 		return errors.New("interface ptr is nil")
 	}
@@ -269,24 +262,30 @@ func (i *InterfaceConfig) UpdatePeerConfig(operation string, pubkey string, allo
 		return errors.New("invalid pubkey")
 	}
 
-	command := []string{"wg", "set", i.Name, "peer", pubkey}
+	if psk != "" && !IsValidKey(psk) {
+		return errors.New("invalid preshared key")
+	}
 
 	if operation == "add" {
-		joined := strings.Join(allowed, ",")
-		command = append(command, "allowed-ips", joined)
-	} else {
-		command = append(command, "remove")
+		return activeBackend.SetPeer(i.Name, pubkey, psk, allowed)
 	}
 
-	stderr := new(strings.Builder)
-	cmd := exec.Command("sudo", command...)
-	cmd.Stderr = stderr
+	return activeBackend.RemovePeer(i.Name, pubkey)
+}
 
-	if err := cmd.Run(); err != nil {
-		return errors.New(strings.Trim(stderr.String(), "\r\n"))
+// Run interface script outside of interface up/down, with optional arguments
+func (i *InterfaceConfig) RunScript(action InterfaceKindType, args ...string) error {
+	script, err := i.GetInterfaceScriptPath(action)
+
+	if err != nil {
+		return err
 	}
 
-	return nil
+	for _, arg := range args {
+		script += fmt.Sprintf(" \"%s\"", arg)
+	}
+
+	return activeBackend.RunHook(i.Name, script)
 }
 
 // Bring interface up
@@ -295,15 +294,7 @@ func (i *InterfaceConfig) BringUp() error {
 		return errors.New("interface ptr is nil")
 	}
 
-	stderr := new(strings.Builder)
-	cmd := exec.Command("sudo", "wg-quick", "up", i.Name)
-	cmd.Stderr = stderr
-
-	if err := cmd.Run(); err != nil {
-		return errors.New(strings.Trim(stderr.String(), "\r\n"))
-	}
-
-	return nil
+	return activeBackend.BringUp(i)
 }
 
 // Bring interface down
@@ -312,15 +303,7 @@ func (i *InterfaceConfig) BringDown() error {
 		return errors.New("interface ptr is nil")
 	}
 
-	stderr := new(strings.Builder)
-	cmd := exec.Command("sudo", "wg-quick", "down", i.Name)
-	cmd.Stderr = stderr
-
-	if err := cmd.Run(); err != nil {
-		return errors.New(strings.Trim(stderr.String(), "\r\n"))
-	}
-
-	return nil
+	return activeBackend.BringDown(i)
 }
 
 // Check whether interface is up or not
@@ -344,6 +327,15 @@ func (i *InterfaceConfig) IsActive() (bool, error) {
 	return false, errors.New("interface not found")
 }
 
+// Get runtime state of all interface peers
+func (i *InterfaceConfig) GetPeers() ([]PeerStatus, error) {
+	if i == nil {
+		return nil, errors.New("interface ptr is nil")
+	}
+
+	return activeBackend.GetPeers(i.Name)
+}
+
 // Overwrite default interface gateway file
 func (i *InterfaceConfig) UpdateDefaultGateway(addr string) error {
 	gatewayPath := path.Join(BasePath, "config", UpstreamGatewayConfig)
@@ -0,0 +1,783 @@
+package network
+
+import (
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+	"net/netip"
+	"os/exec"
+	"strconv"
+	"strings"
+	"syscall"
+	"wirejump/internal/utils"
+)
+
+// Backend which talks to the kernel directly: WireGuard devices are managed
+// via generic netlink, links, addresses and routes via rtnetlink. Keys are
+// generated in Go. It mimics wg-quick behaviour for the interface config,
+// including hooks, so it only requires CAP_NET_ADMIN (and whatever hooks need).
+
+// WireGuard generic netlink API, see linux/wireguard.h
+const (
+	wgGenlName    = "wireguard"
+	wgGenlVersion = 1
+
+	wgCmdGetDevice = 0
+	wgCmdSetDevice = 1
+
+	wgDeviceFlagReplacePeers = 1
+
+	wgDeviceAttrIfname     = 2
+	wgDeviceAttrPrivateKey = 3
+	wgDeviceAttrFlags      = 5
+	wgDeviceAttrListenPort = 6
+	wgDeviceAttrPeers      = 8
+
+	wgPeerFlagRemoveMe          = 1
+	wgPeerFlagReplaceAllowedIPs = 2
+
+	wgPeerAttrPublicKey     = 1
+	wgPeerAttrPresharedKey  = 2
+	wgPeerAttrFlags         = 3
+	wgPeerAttrEndpoint      = 4
+	wgPeerAttrKeepalive     = 5
+	wgPeerAttrLastHandshake = 6
+	wgPeerAttrRxBytes       = 7
+	wgPeerAttrTxBytes       = 8
+	wgPeerAttrAllowedIPs    = 9
+
+	wgAllowedIPAttrFamily   = 1
+	wgAllowedIPAttrAddress  = 2
+	wgAllowedIPAttrCidrMask = 3
+)
+
+// Some rtnetlink constants missing from syscall package
+const (
+	iflaInfoKind  = 1
+	rtTableMain   = 254
+	rtaTable      = 15
+	rtProtoBoot   = 3
+	rtScopeLink   = 253
+	rtnUnicast    = 1
+	defaultWgMTU  = 1420
+	linkKindWg    = "wireguard"
+	tableOff      = "off"
+	tableAuto     = "auto"
+	tableMain     = "main"
+	hookInterface = "%i"
+)
+
+type netlinkBackend struct{}
+
+func init() {
+	backends[BackendNetlink] = netlinkBackend{}
+}
+
+// Interface config in wg-quick format
+type quickConfig struct {
+	PrivateKey []byte
+	ListenPort int
+	MTU        int
+	Table      string
+	Addresses  []netip.Prefix
+	PreUp      string
+	PostUp     string
+	PreDown    string
+	PostDown   string
+	Peers      []quickPeer
+}
+
+// Peer config in wg-quick format
+type quickPeer struct {
+	PublicKey    []byte
+	PresharedKey []byte
+	Endpoint     *net.UDPAddr
+	Keepalive    int
+	AllowedIPs   []netip.Prefix
+}
+
+// Parse comma separated list of prefixes; single addresses are allowed too
+func parsePrefixList(value string) ([]netip.Prefix, error) {
+	prefixes := []netip.Prefix{}
+
+	for _, part := range strings.Split(value, ",") {
+		part = strings.TrimSpace(part)
+
+		if part == "" {
+			continue
+		}
+
+		if !strings.Contains(part, "/") {
+			addr, err := netip.ParseAddr(part)
+
+			if err != nil {
+				return nil, err
+			}
+
+			part = fmt.Sprintf("%s/%d", addr, addr.BitLen())
+		}
+
+		prefix, err := netip.ParsePrefix(part)
+
+		if err != nil {
+			return nil, err
+		}
+
+		prefixes = append(prefixes, prefix)
+	}
+
+	return prefixes, nil
+}
+
+// Read and parse interface config file
+func readQuickConfig(i *InterfaceConfig) (quickConfig, error) {
+	file, err := i.ReadConfig()
+
+	if err != nil {
+		return quickConfig{}, err
+	}
+
+	return parseQuickConfig(file)
+}
+
+// Parse interface config
+func parseQuickConfig(file utils.INIFile) (quickConfig, error) {
+	var err error
+	config := quickConfig{}
+
+	if len(file["Interface"]) == 0 {
+		return config, errors.New("interface section is missing")
+	}
+
+	section := file["Interface"][0]
+
+	if config.PrivateKey, err = DecodeKey(strings.TrimSpace(section["PrivateKey"])); err != nil {
+		return config, errors.New("invalid interface private key")
+	}
+
+	if config.Addresses, err = parsePrefixList(section["Address"]); err != nil {
+		return config, fmt.Errorf("invalid interface address: %s", err)
+	}
+
+	if port := strings.TrimSpace(section["ListenPort"]); port != "" {
+		if config.ListenPort, err = strconv.Atoi(port); err != nil {
+			return config, errors.New("invalid interface listen port")
+		}
+	}
+
+	config.MTU = defaultWgMTU
+
+	if mtu := strings.TrimSpace(section["MTU"]); mtu != "" {
+		if config.MTU, err = strconv.Atoi(mtu); err != nil {
+			return config, errors.New("invalid interface MTU")
+		}
+	}
+
+	config.Table = strings.ToLower(strings.TrimSpace(section["Table"]))
+	config.PreUp = strings.TrimSpace(section["PreUp"])
+	config.PostUp = strings.TrimSpace(section["PostUp"])
+	config.PreDown = strings.TrimSpace(section["PreDown"])
+	config.PostDown = strings.TrimSpace(section["PostDown"])
+
+	for _, section := range file["Peer"] {
+		peer := quickPeer{}
+
+		if peer.PublicKey, err = DecodeKey(strings.TrimSpace(section["PublicKey"])); err != nil {
+			return config, errors.New("invalid peer public key")
+		}
+
+		if psk := strings.TrimSpace(section["PresharedKey"]); psk != "" {
+			if peer.PresharedKey, err = DecodeKey(psk); err != nil {
+				return config, errors.New("invalid peer preshared key")
+			}
+		}
+
+		if endpoint := strings.TrimSpace(section["Endpoint"]); endpoint != "" {
+			if peer.Endpoint, err = net.ResolveUDPAddr("udp", endpoint); err != nil {
+				return config, fmt.Errorf("invalid peer endpoint: %s", err)
+			}
+		}
+
+		if keepalive := strings.TrimSpace(section["PersistentKeepalive"]); keepalive != "" && keepalive != "off" {
+			if peer.Keepalive, err = strconv.Atoi(keepalive); err != nil {
+				return config, errors.New("invalid peer keepalive")
+			}
+		}
+
+		if peer.AllowedIPs, err = parsePrefixList(section["AllowedIPs"]); err != nil {
+			return config, fmt.Errorf("invalid peer allowed IPs: %s", err)
+		}
+
+		config.Peers = append(config.Peers, peer)
+	}
+
+	return config, nil
+}
+
+// Run interface hook the same way wg-quick does
+func runHook(name string, hook string) error {
+	if hook == "" {
+		return nil
+	}
+
+	output, err := exec.Command("bash", "-c", strings.ReplaceAll(hook, hookInterface, name)).CombinedOutput()
+
+	if err != nil {
+		return fmt.Errorf("hook has failed: %s: %s", err, strings.Trim(string(output), "\r\n"))
+	}
+
+	return nil
+}
+
+// Encode socket address for WireGuard endpoint
+func encodeSockaddr(addr *net.UDPAddr) []byte {
+	if ipv4 := addr.IP.To4(); ipv4 != nil {
+		encoded := make([]byte, syscall.SizeofSockaddrInet4)
+		binary.NativeEndian.PutUint16(encoded[0:2], syscall.AF_INET)
+		binary.BigEndian.PutUint16(encoded[2:4], uint16(addr.Port))
+		copy(encoded[4:8], ipv4)
+
+		return encoded
+	}
+
+	encoded := make([]byte, syscall.SizeofSockaddrInet6)
+	binary.NativeEndian.PutUint16(encoded[0:2], syscall.AF_INET6)
+	binary.BigEndian.PutUint16(encoded[2:4], uint16(addr.Port))
+	copy(encoded[8:24], addr.IP.To16())
+
+	return encoded
+}
+
+// Decode socket address of WireGuard endpoint
+func decodeSockaddr(data []byte) string {
+	if len(data) < 4 {
+		return ""
+	}
+
+	port := binary.BigEndian.Uint16(data[2:4])
+
+	switch binary.NativeEndian.Uint16(data[0:2]) {
+	case syscall.AF_INET:
+		if len(data) >= 8 {
+			addr := netip.AddrFrom4([4]byte(data[4:8]))
+
+			return netip.AddrPortFrom(addr, port).String()
+		}
+	case syscall.AF_INET6:
+		if len(data) >= 24 {
+			addr := netip.AddrFrom16([16]byte(data[8:24]))
+
+			return netip.AddrPortFrom(addr, port).String()
+		}
+	}
+
+	return ""
+}
+
+// Encode list of allowed IPs
+func encodeAllowedIPs(allowed []netip.Prefix) []byte {
+	encoded := [][]byte{}
+
+	for _, prefix := range allowed {
+		family := uint16(syscall.AF_INET)
+
+		if prefix.Addr().Is6() {
+			family = syscall.AF_INET6
+		}
+
+		encoded = append(encoded, nlEncodeNested(0,
+			nlEncodeUint16(wgAllowedIPAttrFamily, family),
+			nlEncodeAttr(wgAllowedIPAttrAddress, prefix.Addr().AsSlice()),
+			nlEncodeAttr(wgAllowedIPAttrCidrMask, []byte{uint8(prefix.Bits())}),
+		))
+	}
+
+	return nlEncodeNested(wgPeerAttrAllowedIPs, encoded...)
+}
+
+// Encode single peer
+func encodePeer(peer quickPeer, flags uint32) []byte {
+	attrs := [][]byte{
+		nlEncodeAttr(wgPeerAttrPublicKey, peer.PublicKey),
+		nlEncodeUint32(wgPeerAttrFlags, flags),
+	}
+
+	if flags&wgPeerFlagRemoveMe != 0 {
+		return nlEncodeNested(0, attrs...)
+	}
+
+	if peer.PresharedKey != nil {
+		attrs = append(attrs, nlEncodeAttr(wgPeerAttrPresharedKey, peer.PresharedKey))
+	}
+
+	if peer.Endpoint != nil {
+		attrs = append(attrs, nlEncodeAttr(wgPeerAttrEndpoint, encodeSockaddr(peer.Endpoint)))
+	}
+
+	if peer.Keepalive > 0 {
+		attrs = append(attrs, nlEncodeUint16(wgPeerAttrKeepalive, uint16(peer.Keepalive)))
+	}
+
+	attrs = append(attrs, encodeAllowedIPs(peer.AllowedIPs))
+
+	return nlEncodeNested(0, attrs...)
+}
+
+// Execute WireGuard generic netlink command
+func wgExecute(command uint8, flags uint16, attrs ...[]byte) ([][]byte, error) {
+	socket, err := nlOpen(syscall.NETLINK_GENERIC)
+
+	if err != nil {
+		return nil, err
+	}
+
+	defer socket.Close()
+
+	family, err := socket.GetFamilyId(wgGenlName)
+
+	if err != nil {
+		return nil, err
+	}
+
+	payload := genlHeader(command, wgGenlVersion)
+
+	for _, attr := range attrs {
+		payload = append(payload, attr...)
+	}
+
+	return socket.Execute(family, flags, payload)
+}
+
+// Execute rtnetlink command
+func rtExecute(kind uint16, flags uint16, header []byte, attrs ...[]byte) error {
+	socket, err := nlOpen(syscall.NETLINK_ROUTE)
+
+	if err != nil {
+		return err
+	}
+
+	defer socket.Close()
+
+	payload := header
+
+	for _, attr := range attrs {
+		payload = append(payload, attr...)
+	}
+
+	_, err = socket.Execute(kind, flags, payload)
+
+	return err
+}
+
+// Create interface info message header
+func ifInfoHeader(index int, flags uint32, change uint32) []byte {
+	header := make([]byte, syscall.SizeofIfInfomsg)
+	header[0] = syscall.AF_UNSPEC
+	binary.NativeEndian.PutUint32(header[4:8], uint32(index))
+	binary.NativeEndian.PutUint32(header[8:12], flags)
+	binary.NativeEndian.PutUint32(header[12:16], change)
+
+	return header
+}
+
+// Get interface index by its name
+func linkIndex(name string) (int, error) {
+	iface, err := net.InterfaceByName(name)
+
+	if err != nil {
+		return 0, err
+	}
+
+	return iface.Index, nil
+}
+
+// Create WireGuard link
+func createLink(name string, mtu int) error {
+	return rtExecute(syscall.RTM_NEWLINK, syscall.NLM_F_CREATE|syscall.NLM_F_EXCL,
+		ifInfoHeader(0, 0, 0),
+		nlEncodeString(syscall.IFLA_IFNAME, name),
+		nlEncodeUint32(syscall.IFLA_MTU, uint32(mtu)),
+		nlEncodeNested(syscall.IFLA_LINKINFO, nlEncodeString(iflaInfoKind, linkKindWg)),
+	)
+}
+
+// Set link state to up
+func setLinkUp(index int) error {
+	return rtExecute(syscall.RTM_NEWLINK, 0, ifInfoHeader(index, syscall.IFF_UP, syscall.IFF_UP))
+}
+
+// Delete link
+func deleteLink(index int) error {
+	return rtExecute(syscall.RTM_DELLINK, 0, ifInfoHeader(index, 0, 0))
+}
+
+// Get address family for the prefix
+func prefixFamily(prefix netip.Prefix) uint8 {
+	if prefix.Addr().Is4() {
+		return syscall.AF_INET
+	}
+
+	return syscall.AF_INET6
+}
+
+// Add address to the link
+func addAddress(index int, prefix netip.Prefix) error {
+	header := make([]byte, syscall.SizeofIfAddrmsg)
+	header[0] = prefixFamily(prefix)
+	header[1] = uint8(prefix.Bits())
+	binary.NativeEndian.PutUint32(header[4:8], uint32(index))
+
+	addr := prefix.Addr().AsSlice()
+
+	return rtExecute(syscall.RTM_NEWADDR, syscall.NLM_F_CREATE|syscall.NLM_F_REPLACE, header,
+		nlEncodeAttr(syscall.IFA_LOCAL, addr),
+		nlEncodeAttr(syscall.IFA_ADDRESS, addr),
+	)
+}
+
+// Add route via the link to a given table. Existing routes are kept intact
+func addRoute(index int, prefix netip.Prefix, table uint32) error {
+	header := make([]byte, syscall.SizeofRtMsg)
+	header[0] = prefixFamily(prefix)
+	header[1] = uint8(prefix.Bits())
+	header[4] = syscall.RT_TABLE_UNSPEC
+	header[5] = rtProtoBoot
+	header[6] = rtScopeLink
+	header[7] = rtnUnicast
+
+	attrs := [][]byte{
+		nlEncodeUint32(syscall.RTA_OIF, uint32(index)),
+		nlEncodeUint32(rtaTable, table),
+	}
+
+	if prefix.Bits() > 0 {
+		attrs = append(attrs, nlEncodeAttr(syscall.RTA_DST, prefix.Masked().Addr().AsSlice()))
+	}
+
+	err := rtExecute(syscall.RTM_NEWROUTE, syscall.NLM_F_CREATE|syscall.NLM_F_EXCL, header, attrs...)
+
+	if errors.Is(err, syscall.EEXIST) {
+		return nil
+	}
+
+	return err
+}
+
+// Add routes for all peers according to the Table setting. Unlike wg-quick,
+// default routes in main table are not supported, since they would hijack
+// all server traffic; use explicit table for them
+func addPeerRoutes(index int, config quickConfig) error {
+	if config.Table == tableOff {
+		return nil
+	}
+
+	table := uint32(rtTableMain)
+
+	if config.Table != "" && config.Table != tableAuto && config.Table != tableMain {
+		parsed, err := strconv.ParseUint(config.Table, 10, 32)
+
+		if err != nil {
+			return fmt.Errorf("invalid routing table '%s'", config.Table)
+		}
+
+		table = uint32(parsed)
+	}
+
+	for _, peer := range config.Peers {
+		for _, prefix := range peer.AllowedIPs {
+			if prefix.Bits() == 0 && table == rtTableMain {
+				return errors.New("default route requires explicit 'Table' setting")
+			}
+
+			if err := addRoute(index, prefix, table); err != nil {
+				return fmt.Errorf("cannot add route %s: %s", prefix, err)
+			}
+		}
+	}
+
+	return nil
+}
+
+func (b netlinkBackend) GeneratePrivateKey() (string, error) {
+	return GeneratePrivateKey()
+}
+
+func (b netlinkBackend) GeneratePublicKey(private string) (string, error) {
+	return GeneratePublicKey(private)
+}
+
+func (b netlinkBackend) SetPeer(name string, pubkey string, psk string, allowed []string) error {
+	key, err := DecodeKey(pubkey)
+
+	if err != nil {
+		return err
+	}
+
+	var presharedKey []byte
+
+	if psk != "" {
+		if presharedKey, err = DecodeKey(psk); err != nil {
+			return err
+		}
+	}
+
+	prefixes, err := parsePrefixList(strings.Join(allowed, ","))
+
+	if err != nil {
+		return err
+	}
+
+	peer := quickPeer{PublicKey: key, PresharedKey: presharedKey, AllowedIPs: prefixes}
+	_, err = wgExecute(wgCmdSetDevice, 0,
+		nlEncodeString(wgDeviceAttrIfname, name),
+		nlEncodeNested(wgDeviceAttrPeers, encodePeer(peer, wgPeerFlagReplaceAllowedIPs)),
+	)
+
+	return err
+}
+
+func (b netlinkBackend) RemovePeer(name string, pubkey string) error {
+	key, err := DecodeKey(pubkey)
+
+	if err != nil {
+		return err
+	}
+
+	peer := quickPeer{PublicKey: key}
+	_, err = wgExecute(wgCmdSetDevice, 0,
+		nlEncodeString(wgDeviceAttrIfname, name),
+		nlEncodeNested(wgDeviceAttrPeers, encodePeer(peer, wgPeerFlagRemoveMe)),
+	)
+
+	return err
+}
+
+func (b netlinkBackend) RunHook(name string, hook string) error {
+	return runHook(name, hook)
+}
+
+func (b netlinkBackend) BringUp(i *InterfaceConfig) error {
+	config, err := readQuickConfig(i)
+
+	if err != nil {
+		return fmt.Errorf("invalid interface config: %s", err)
+	}
+
+	if _, err := net.InterfaceByName(i.Name); err == nil {
+		return fmt.Errorf("interface '%s' already exists", i.Name)
+	}
+
+	if err := runHook(i.Name, config.PreUp); err != nil {
+		return err
+	}
+
+	if err := createLink(i.Name, config.MTU); err != nil {
+		return fmt.Errorf("cannot create interface: %s", err)
+	}
+
+	index, err := linkIndex(i.Name)
+
+	if err != nil {
+		return err
+	}
+
+	// Interface is half-configured from now on, so remove it on error
+	fail := func(err error) error {
+		deleteLink(index)
+
+		return err
+	}
+
+	peers := [][]byte{}
+
+	for _, peer := range config.Peers {
+		peers = append(peers, encodePeer(peer, wgPeerFlagReplaceAllowedIPs))
+	}
+
+	device := [][]byte{
+		nlEncodeString(wgDeviceAttrIfname, i.Name),
+		nlEncodeAttr(wgDeviceAttrPrivateKey, config.PrivateKey),
+		nlEncodeUint32(wgDeviceAttrFlags, wgDeviceFlagReplacePeers),
+		nlEncodeNested(wgDeviceAttrPeers, peers...),
+	}
+
+	if config.ListenPort != 0 {
+		device = append(device, nlEncodeUint16(wgDeviceAttrListenPort, uint16(config.ListenPort)))
+	}
+
+	if _, err := wgExecute(wgCmdSetDevice, 0, device...); err != nil {
+		return fail(fmt.Errorf("cannot configure interface: %s", err))
+	}
+
+	for _, prefix := range config.Addresses {
+		if err := addAddress(index, prefix); err != nil {
+			return fail(fmt.Errorf("cannot add address %s: %s", prefix, err))
+		}
+	}
+
+	if err := setLinkUp(index); err != nil {
+		return fail(fmt.Errorf("cannot bring interface up: %s", err))
+	}
+
+	if err := addPeerRoutes(index, config); err != nil {
+		return fail(err)
+	}
+
+	if err := runHook(i.Name, config.PostUp); err != nil {
+		return fail(err)
+	}
+
+	return nil
+}
+
+func (b netlinkBackend) BringDown(i *InterfaceConfig) error {
+	config, err := readQuickConfig(i)
+
+	if err != nil {
+		return fmt.Errorf("invalid interface config: %s", err)
+	}
+
+	index, err := linkIndex(i.Name)
+
+	if err != nil {
+		return fmt.Errorf("interface '%s' is not found", i.Name)
+	}
+
+	if err := runHook(i.Name, config.PreDown); err != nil {
+		return err
+	}
+
+	if err := deleteLink(index); err != nil {
+		return fmt.Errorf("cannot delete interface: %s", err)
+	}
+
+	return runHook(i.Name, config.PostDown)
+}
+
+func (b netlinkBackend) GetPeers(name string) ([]PeerStatus, error) {
+	replies, err := wgExecute(wgCmdGetDevice, syscall.NLM_F_DUMP, nlEncodeString(wgDeviceAttrIfname, name))
+
+	if err != nil {
+		return nil, err
+	}
+
+	peers := []PeerStatus{}
+
+	// Large peer lists are split across several replies
+	for _, reply := range replies {
+		if len(reply) < genlHeaderLen {
+			continue
+		}
+
+		attrs, err := nlDecodeAttrs(reply[genlHeaderLen:])
+
+		if err != nil {
+			return nil, err
+		}
+
+		for _, attr := range attrs {
+			if attr.Type != wgDeviceAttrPeers {
+				continue
+			}
+
+			nested, err := nlDecodeAttrs(attr.Data)
+
+			if err != nil {
+				return nil, err
+			}
+
+			for _, encoded := range nested {
+				peer, err := decodePeerStatus(encoded.Data)
+
+				if err != nil {
+					return nil, err
+				}
+
+				peers = append(peers, peer)
+			}
+		}
+	}
+
+	return peers, nil
+}
+
+// Decode single peer from device dump
+func decodePeerStatus(data []byte) (PeerStatus, error) {
+	peer := PeerStatus{}
+	attrs, err := nlDecodeAttrs(data)
+
+	if err != nil {
+		return peer, err
+	}
+
+	for _, attr := range attrs {
+		switch attr.Type {
+		case wgPeerAttrPublicKey:
+			peer.PublicKey = base64.StdEncoding.EncodeToString(attr.Data)
+		case wgPeerAttrEndpoint:
+			peer.Endpoint = decodeSockaddr(attr.Data)
+		case wgPeerAttrLastHandshake:
+			if len(attr.Data) >= 8 {
+				peer.LatestHandshake = int64(binary.NativeEndian.Uint64(attr.Data[0:8]))
+			}
+		case wgPeerAttrRxBytes:
+			if len(attr.Data) >= 8 {
+				peer.RxBytes = int64(binary.NativeEndian.Uint64(attr.Data))
+			}
+		case wgPeerAttrTxBytes:
+			if len(attr.Data) >= 8 {
+				peer.TxBytes = int64(binary.NativeEndian.Uint64(attr.Data))
+			}
+		case wgPeerAttrAllowedIPs:
+			allowed, err := decodeAllowedIPs(attr.Data)
+
+			if err != nil {
+				return peer, err
+			}
+
+			peer.AllowedIPs = allowed
+		}
+	}
+
+	return peer, nil
+}
+
+// Decode allowed IPs list
+func decodeAllowedIPs(data []byte) ([]string, error) {
+	allowed := []string{}
+	nested, err := nlDecodeAttrs(data)
+
+	if err != nil {
+		return nil, err
+	}
+
+	for _, encoded := range nested {
+		var addr netip.Addr
+		var bits int
+
+		attrs, err := nlDecodeAttrs(encoded.Data)
+
+		if err != nil {
+			return nil, err
+		}
+
+		for _, attr := range attrs {
+			switch attr.Type {
+			case wgAllowedIPAttrAddress:
+				addr, _ = netip.AddrFromSlice(attr.Data)
+			case wgAllowedIPAttrCidrMask:
+				if len(attr.Data) > 0 {
+					bits = int(attr.Data[0])
+				}
+			}
+		}
+
+		if addr.IsValid() {
+			allowed = append(allowed, netip.PrefixFrom(addr, bits).String())
+		}
+	}
+
+	return allowed, nil
+}
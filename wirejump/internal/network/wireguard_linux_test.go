@@ -0,0 +1,253 @@
+package network
+
+import (
+	"bytes"
+	"encoding/base64"
+	"net"
+	"net/netip"
+	"os"
+	"path"
+	"slices"
+	"testing"
+	"wirejump/internal/utils"
+)
+
+const (
+	testPrivateKey = "dwdtCnMYpX08FsFyUbJmRd9ML4frwJkqsXf7pR25LCo="
+	testPublicKey  = "hSDwCYkwp1R0i33ctD73Wg2/Og0mOBr066SpjqqbTmo="
+	testPSK        = "XasIfmJKikt54X+Lg4AO5m87sSkmGLb9HC+LJ/+I4Os="
+)
+
+// Write config to a temporary file and parse it back
+func parseConfigText(t *testing.T, text string) (quickConfig, error) {
+	name := path.Join(t.TempDir(), "wg0.conf")
+
+	if err := os.WriteFile(name, []byte(text), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	file, err := utils.ReadINI(name)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return parseQuickConfig(file)
+}
+
+func mustDecodeKey(t *testing.T, key string) []byte {
+	decoded, err := DecodeKey(key)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return decoded
+}
+
+func prefixStrings(prefixes []netip.Prefix) []string {
+	values := []string{}
+
+	for _, prefix := range prefixes {
+		values = append(values, prefix.String())
+	}
+
+	return values
+}
+
+func TestParsePrefixList(t *testing.T) {
+	tests := []struct {
+		value string
+		want  []string
+		ok    bool
+	}{
+		{"", []string{}, true},
+		{"0.0.0.0/0, ::/0", []string{"0.0.0.0/0", "::/0"}, true},
+		{" 172.16.1.1/24 ,fd00::1/64,", []string{"172.16.1.1/24", "fd00::1/64"}, true},
+		{"10.0.0.1, fd00::2", []string{"10.0.0.1/32", "fd00::2/128"}, true},
+		{"10.0.0.1/33", nil, false},
+		{"10.0.0.256", nil, false},
+		{"10.0.0.1, example.com", nil, false},
+	}
+
+	for _, test := range tests {
+		prefixes, err := parsePrefixList(test.value)
+
+		if (err == nil) != test.ok {
+			t.Errorf("%q: got %v, want ok: %v", test.value, err, test.ok)
+			continue
+		}
+
+		if test.ok && !slices.Equal(prefixStrings(prefixes), test.want) {
+			t.Errorf("%q: got %v, want %v", test.value, prefixStrings(prefixes), test.want)
+		}
+	}
+}
+
+func TestParseQuickConfig(t *testing.T) {
+	config, err := parseConfigText(t, `[Interface]
+# Comments are skipped
+PrivateKey = `+testPrivateKey+`
+Address = 172.16.1.2/32, fd00::2/128
+ListenPort = 51820
+Table = 1000
+PostUp = ip rule add from 172.16.1.2 table 1000
+PreDown = ip rule del from 172.16.1.2 table 1000
+
+[Peer]
+PublicKey = `+testPublicKey+`
+PresharedKey = `+testPSK+`
+Endpoint = 192.0.2.1:51820
+PersistentKeepalive = 25
+AllowedIPs = 0.0.0.0/0, ::/0
+
+[Peer]
+PublicKey = `+testPublicKey+`
+AllowedIPs = 10.0.0.0/8
+PersistentKeepalive = off
+`)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !bytes.Equal(config.PrivateKey, mustDecodeKey(t, testPrivateKey)) {
+		t.Errorf("private key is wrong")
+	}
+
+	if !slices.Equal(prefixStrings(config.Addresses), []string{"172.16.1.2/32", "fd00::2/128"}) {
+		t.Errorf("addresses are wrong: %v", config.Addresses)
+	}
+
+	if config.ListenPort != 51820 || config.MTU != defaultWgMTU || config.Table != "1000" {
+		t.Errorf("got port %d, MTU %d, table %s", config.ListenPort, config.MTU, config.Table)
+	}
+
+	if config.PostUp != "ip rule add from 172.16.1.2 table 1000" || config.PreDown != "ip rule del from 172.16.1.2 table 1000" || config.PreUp != "" || config.PostDown != "" {
+		t.Errorf("hooks are wrong: %+v", config)
+	}
+
+	if len(config.Peers) != 2 {
+		t.Fatalf("got %d peers, want 2", len(config.Peers))
+	}
+
+	first := config.Peers[0]
+
+	if !bytes.Equal(first.PublicKey, mustDecodeKey(t, testPublicKey)) || !bytes.Equal(first.PresharedKey, mustDecodeKey(t, testPSK)) {
+		t.Errorf("peer keys are wrong")
+	}
+
+	if first.Endpoint == nil || first.Endpoint.String() != "192.0.2.1:51820" || first.Keepalive != 25 {
+		t.Errorf("got endpoint %v, keepalive %d", first.Endpoint, first.Keepalive)
+	}
+
+	if !slices.Equal(prefixStrings(first.AllowedIPs), []string{"0.0.0.0/0", "::/0"}) {
+		t.Errorf("allowed IPs are wrong: %v", first.AllowedIPs)
+	}
+
+	second := config.Peers[1]
+
+	if second.PresharedKey != nil || second.Endpoint != nil || second.Keepalive != 0 {
+		t.Errorf("optional peer values are set: %+v", second)
+	}
+}
+
+func TestParseQuickConfigErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		text string
+	}{
+		{"no interface", "[Peer]\nPublicKey = " + testPublicKey + "\n"},
+		{"no private key", "[Interface]\nAddress = 172.16.1.2/32\n"},
+		{"bad private key", "[Interface]\nPrivateKey = abc\n"},
+		{"bad address", "[Interface]\nPrivateKey = " + testPrivateKey + "\nAddress = 172.16.1.300/32\n"},
+		{"bad port", "[Interface]\nPrivateKey = " + testPrivateKey + "\nListenPort = port\n"},
+		{"bad MTU", "[Interface]\nPrivateKey = " + testPrivateKey + "\nMTU = 1420b\n"},
+		{"bad peer key", "[Interface]\nPrivateKey = " + testPrivateKey + "\n[Peer]\nPublicKey = abc\n"},
+		{"bad psk", "[Interface]\nPrivateKey = " + testPrivateKey + "\n[Peer]\nPublicKey = " + testPublicKey + "\nPresharedKey = abc\n"},
+		{"bad endpoint", "[Interface]\nPrivateKey = " + testPrivateKey + "\n[Peer]\nPublicKey = " + testPublicKey + "\nEndpoint = 192.0.2.1\n"},
+		{"bad keepalive", "[Interface]\nPrivateKey = " + testPrivateKey + "\n[Peer]\nPublicKey = " + testPublicKey + "\nPersistentKeepalive = often\n"},
+		{"bad allowed IPs", "[Interface]\nPrivateKey = " + testPrivateKey + "\n[Peer]\nPublicKey = " + testPublicKey + "\nAllowedIPs = any\n"},
+	}
+
+	for _, test := range tests {
+		if _, err := parseConfigText(t, test.text); err == nil {
+			t.Errorf("%s: config is accepted", test.name)
+		}
+	}
+}
+
+func TestSockaddr(t *testing.T) {
+	tests := []string{"192.0.2.1:51820", "[2001:db8::1]:443"}
+
+	for _, test := range tests {
+		addr, err := net.ResolveUDPAddr("udp", test)
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if decoded := decodeSockaddr(encodeSockaddr(addr)); decoded != test {
+			t.Errorf("got %s, want %s", decoded, test)
+		}
+	}
+
+	for _, data := range [][]byte{nil, {2, 0}, {0xff, 0xff, 0, 1, 2, 3, 4, 5}} {
+		if decoded := decodeSockaddr(data); decoded != "" {
+			t.Errorf("%v: got %s from malformed address", data, decoded)
+		}
+	}
+}
+
+func TestEncodePeer(t *testing.T) {
+	endpoint, _ := net.ResolveUDPAddr("udp", "192.0.2.1:51820")
+	peer := quickPeer{
+		PublicKey:    mustDecodeKey(t, testPublicKey),
+		PresharedKey: mustDecodeKey(t, testPSK),
+		Endpoint:     endpoint,
+		Keepalive:    25,
+		AllowedIPs:   []netip.Prefix{netip.MustParsePrefix("10.0.0.0/8"), netip.MustParsePrefix("fd00::/64")},
+	}
+
+	attrs, err := nlDecodeAttrs(encodePeer(peer, wgPeerFlagReplaceAllowedIPs))
+
+	if err != nil || len(attrs) != 1 {
+		t.Fatalf("got %v, %v, want single peer", attrs, err)
+	}
+
+	// Peers are decoded the same way as in device dump
+	status, err := decodePeerStatus(attrs[0].Data)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if status.PublicKey != testPublicKey || status.Endpoint != "192.0.2.1:51820" {
+		t.Errorf("got key %s, endpoint %s", status.PublicKey, status.Endpoint)
+	}
+
+	if !slices.Equal(status.AllowedIPs, []string{"10.0.0.0/8", "fd00::/64"}) {
+		t.Errorf("allowed IPs are wrong: %v", status.AllowedIPs)
+	}
+
+	fields, _ := nlDecodeAttrs(attrs[0].Data)
+	kinds := []uint16{}
+
+	for _, field := range fields {
+		kinds = append(kinds, field.Type)
+	}
+
+	want := []uint16{wgPeerAttrPublicKey, wgPeerAttrFlags, wgPeerAttrPresharedKey, wgPeerAttrEndpoint, wgPeerAttrKeepalive, wgPeerAttrAllowedIPs}
+
+	if !slices.Equal(kinds, want) {
+		t.Errorf("got attributes %v, want %v", kinds, want)
+	}
+
+	// Removed peer only has a key and flags
+	attrs, _ = nlDecodeAttrs(encodePeer(peer, wgPeerFlagRemoveMe))
+	fields, _ = nlDecodeAttrs(attrs[0].Data)
+
+	if len(fields) != 2 || base64.StdEncoding.EncodeToString(fields[0].Data) != testPublicKey {
+		t.Errorf("removed peer has %d attributes", len(fields))
+	}
+}
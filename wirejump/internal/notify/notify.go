@@ -0,0 +1,135 @@
+package notify
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"os/exec"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Notifiers deliver daemon events to the outside world. Every event is
+// always logged; script and webhook notifiers are optional and configured
+// by user, so that warnings reach someone who is not reading the logs.
+
+// How long to wait for a script or webhook
+const deliveryTimeout = 30 * time.Second
+
+// Prefix for environment variables passed to scripts
+const envPrefix = "WIREJUMP_"
+
+// Single event, like account expiry warning
+type Event struct {
+	// Short machine-readable name, like 'account_expiring'
+	Name string `json:"event"`
+
+	// Human-readable description
+	Message string `json:"message"`
+
+	// Additional event details
+	Fields map[string]string `json:"fields,omitempty"`
+}
+
+// Something that can deliver events
+type Notifier interface {
+	Notify(Event) error
+}
+
+// Writes events to the daemon log
+type LogNotifier struct{}
+
+func (l LogNotifier) Notify(e Event) error {
+	log.Println(e.Message)
+
+	return nil
+}
+
+// Runs a script with event details passed via environment:
+// WIREJUMP_EVENT, WIREJUMP_MESSAGE and WIREJUMP_<FIELD> for every field
+type ScriptNotifier struct {
+	Path string
+}
+
+func (s ScriptNotifier) Notify(e Event) error {
+	cmd := exec.Command(s.Path)
+	cmd.Env = append(os.Environ(), envPrefix+"EVENT="+e.Name, envPrefix+"MESSAGE="+e.Message)
+
+	keys := []string{}
+
+	for key := range e.Fields {
+		keys = append(keys, key)
+	}
+
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		cmd.Env = append(cmd.Env, envPrefix+strings.ToUpper(key)+"="+e.Fields[key])
+	}
+
+	if err := cmd.Start(); err != nil {
+		return fmt.Errorf("cannot run notify script: %s", err)
+	}
+
+	done := make(chan error, 1)
+
+	go func() {
+		done <- cmd.Wait()
+	}()
+
+	select {
+	case err := <-done:
+		if err != nil {
+			return fmt.Errorf("notify script has failed: %s", err)
+		}
+
+		return nil
+	case <-time.After(deliveryTimeout):
+		cmd.Process.Kill()
+
+		return errors.New("notify script has timed out")
+	}
+}
+
+// Posts event as JSON to a given URL
+type WebhookNotifier struct {
+	URL string
+}
+
+func (w WebhookNotifier) Notify(e Event) error {
+	body, err := json.Marshal(e)
+
+	if err != nil {
+		return err
+	}
+
+	client := http.Client{Timeout: deliveryTimeout}
+	resp, err := client.Post(w.URL, "application/json", bytes.NewReader(body))
+
+	if err != nil {
+		return fmt.Errorf("webhook request has failed: %s", err)
+	}
+
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("webhook has replied with status %d", resp.StatusCode)
+	}
+
+	return nil
+}
+
+// Deliver event using all notifiers. Failures are logged,
+// so that one broken notifier doesn't stop the others
+func Send(notifiers []Notifier, e Event) {
+	for _, notifier := range notifiers {
+		if err := notifier.Notify(e); err != nil {
+			log.Printf("failed to deliver '%s' event: %s\n", e.Name, err)
+		}
+	}
+}
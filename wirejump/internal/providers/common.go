@@ -8,24 +8,42 @@ import (
 	"io"
 	"log"
 	"net/http"
+	"net/url"
+	"sort"
 	"time"
+	"wirejump/internal/metrics"
 )
 
 const RequestTimeout = 10
 
+// Transport used for all API requests. Can be replaced to record
+// or replay API responses, see fixtures.go
+var Transport http.RoundTripper = http.DefaultTransport
+
+// All known providers; each provider registers itself on init
+var registry = map[string]WireguardProviderInitializer{}
+
+// Make provider available to a user. Should be called from provider init()
+func RegisterProvider(name string, initializer WireguardProviderInitializer) {
+	if _, exists := registry[name]; exists {
+		log.Fatal("provider is already registered: ", name)
+	}
+
+	registry[name] = initializer
+}
+
 // Will be called on startup to populate what's available to a user
 func LoadAvailableProviders() ProvidersState {
 	pstate := ProvidersState{}
+	pstate.Available = map[string]WireguardProviderInitializer{}
 
-	pstate.Available = map[string]WireguardProviderInitializer{
-		"mullvad": MullvadInit,
-		// other providers go here
-	}
-
-	for name := range pstate.Available {
+	for name, initializer := range registry {
+		pstate.Available[name] = initializer
 		pstate.Names = append(pstate.Names, name)
 	}
 
+	sort.Strings(pstate.Names)
+
 	return pstate
 }
 
@@ -58,6 +76,19 @@ func FormatURL(base string, addTrailing bool) func(...interface{}) string {
 	}
 }
 
+// Provider API metrics
+const (
+	apiRequestsMetric = "wirejump_provider_api_requests_total"
+	apiErrorsMetric   = "wirejump_provider_api_errors_total"
+	apiLatencyMetric  = "wirejump_provider_api_request_duration_seconds"
+)
+
+func init() {
+	metrics.Register(apiRequestsMetric, metrics.KindCounter, "Provider API requests")
+	metrics.Register(apiErrorsMetric, metrics.KindCounter, "Failed provider API requests, including API errors")
+	metrics.Register(apiLatencyMetric, metrics.KindSummary, "Provider API request duration")
+}
+
 // Generic API Request method. Should be wrapped in order for API errors to be
 // decoded properly. Returns bool for API error and error for generic errors
 func RequestAPI(
@@ -67,6 +98,34 @@ func RequestAPI(
 	Data interface{},
 	Dest interface{},
 	APIError interface{},
+) (bool, error) {
+	labels := metrics.Labels{"host": ""}
+
+	if parsed, err := url.Parse(URL); err == nil {
+		labels["host"] = parsed.Host
+	}
+
+	started := time.Now()
+	failed, err := requestAPI(HTTPMethod, URL, Headers, Data, Dest, APIError)
+
+	metrics.Add(apiRequestsMetric, labels, 1)
+	metrics.Observe(apiLatencyMetric, labels, time.Since(started).Seconds())
+
+	if err != nil {
+		metrics.Add(apiErrorsMetric, labels, 1)
+	}
+
+	return failed, err
+}
+
+// Actual API request, see RequestAPI
+func requestAPI(
+	HTTPMethod string,
+	URL string,
+	Headers http.Header,
+	Data interface{},
+	Dest interface{},
+	APIError interface{},
 ) (bool, error) {
 	var requestData []byte = nil
 
@@ -97,7 +156,7 @@ func RequestAPI(
 		req.Header.Add(k, v[0])
 	}
 
-	client := &http.Client{Timeout: time.Second * RequestTimeout}
+	client := &http.Client{Timeout: time.Second * RequestTimeout, Transport: Transport}
 	resp, err := client.Do(req)
 
 	if err != nil {
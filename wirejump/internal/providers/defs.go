@@ -2,17 +2,35 @@ package providers
 
 // Server entity available from upstream
 type WireguardServer struct {
-	Country string
-	City    string
-	IPv4    string
-	Port    int
-	Pubkey  string
+	Country  string
+	City     string
+	Hostname string
+	IPv4     string
+	IPv6     string
+	Port     int
+	Pubkey   string
+
+	// Short location codes, like "se" and "got"; derived
+	// from country and city names if provider has none
+	CountryID string
+	CityID    string
+
+	// Optional attributes, set by providers which know them:
+	// whether provider owns the server (nil if unknown), hosting
+	// provider, relative weight and features like FeatureDAITA
+	Owned    *bool
+	Hosting  string
+	Weight   int
+	Features []string
 }
 
 // Upstream account details
 type WireguardAccount struct {
 	// Account expiration date as UNIX timestamp
 	Expires int64
+
+	// Whether new keys can be added to the account
+	CanAddDevices bool
 }
 
 // Upstream provider credentials
@@ -21,9 +39,9 @@ type WireguardProviderAccount struct {
 	Password  string
 }
 
-// Upstream provider info
+// Upstream provider info, shared by all providers. Provider-specific
+// settings and API state belong to provider types embedding it
 type WireguardProvider struct {
-	URL             func(...interface{}) string
 	Account         WireguardProviderAccount
 	ValidUntil      int64
 	Initialized     bool
@@ -32,13 +50,12 @@ type WireguardProvider struct {
 }
 
 // Upstream provider factory
-type WireguardProviderInitializer func(WireguardProviderAccount) (WireguardProvider, error)
+type WireguardProviderInitializer func(WireguardProviderAccount) (UpstreamAPI, error)
 
 // Required methods for a provider
 type UpstreamAPI interface {
-	// Ultimately API request needs some provider internal data,
-	// which are being populated on init, so this goes here as well
-	APIRequest(string, string, bool, interface{}, interface{}) error
+	// Details returns provider info shared by all providers.
+	Details() *WireguardProvider
 
 	// GetAccountInfo returns various account related settings, such as validity time.
 	GetAccountInfo() (WireguardAccount, error)
@@ -52,10 +69,47 @@ type UpstreamAPI interface {
 	// RemovePubkey removes WireGuard public key from an account. It should ignore missing keys.
 	RemovePubkey(string) error
 
-	// GetAddress will return IPv4 address of upstream interface with a certain public key.
+	// GetAddress will return addresses of upstream interface with a certain public key,
+	// comma separated: IPv4 address first, then IPv6 address if provider supports it.
 	GetAddress(string) (string, error)
 }
 
+// Optional methods for a provider which dictates upstream interface keys,
+// like imported configs with embedded private keys. Such providers don't
+// support key rotation.
+type StaticKeysAPI interface {
+	// GetPrivateKey returns private key to be used with a particular server.
+	GetPrivateKey(WireguardServer) (string, error)
+}
+
+// Optional methods for a provider which allows several keys (devices) per
+// account at once, each with its own address. Providers without it only
+// support main upstream key, since adding another one replaces it.
+type MultiDeviceAPI interface {
+	// MultiDevice returns how many keys can be used at once, including main upstream one.
+	MultiDevice() int
+}
+
+// Optional methods for a provider which keeps API session, like IVPN does.
+// Session is saved along with application state, so it's continued after
+// restart instead of creating a new one and leaving the old one behind.
+type SessionAPI interface {
+	// Session returns current session, or nil if there's none yet.
+	Session() *ProviderSession
+
+	// RestoreSession continues previously saved session.
+	RestoreSession(ProviderSession)
+}
+
+// Provider API session
+type ProviderSession struct {
+	// Session token
+	Token string `json:"token"`
+
+	// Addresses assigned to keys within the session
+	Addresses map[string]string `json:"addresses"`
+}
+
 // Holds available providers, will be populated on startup
 type ProvidersState struct {
 	Available map[string]WireguardProviderInitializer
@@ -64,10 +118,25 @@ type ProvidersState struct {
 
 // Current upstream state
 type ProviderState struct {
-	Provider          *WireguardProvider
-	ActiveSince       *int64
-	PreferredLocation *string
-	Server            *WireguardServer
+	Provider    UpstreamAPI
+	ActiveSince *int64
+	Server      *WireguardServer
+
+	// Locations to use, in order of preference
+	PreferredLocations []string
+
+	// Locations which are never used
+	Excluded []string
+
+	// Server filter, DefaultServerFilter if nil
+	Filter *ServerFilter
+
+	// Server selection strategy, DefaultStrategy if empty
+	Strategy string
+
+	// How many fastest servers to pick from, per location;
+	// empty location is the default, zero means random server
+	BestServers map[string]int
 }
 
 // Current servers availability state
@@ -76,4 +145,8 @@ type ServersState struct {
 	Locations   []string
 	ProvidedBy  string
 	LastRefresh int64
+	// Relay latency scores by hostname
+	Latency map[string]LatencyScore
+	// When servers were selected last time, by hostname
+	LastUsed map[string]int64
 }
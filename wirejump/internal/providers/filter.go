@@ -0,0 +1,111 @@
+package providers
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+)
+
+// Known server features
+const FeatureDAITA = "daita"
+const FeatureMultihop = "multihop"
+
+// Server filter based on server attributes. Attributes which are not
+// known for a server (like ownership for some providers) never filter it out
+type ServerFilter struct {
+	// Only use servers owned by provider
+	OnlyOwned bool `json:"only_owned"`
+
+	// Hosting providers to avoid, case insensitive
+	ExcludeHosting []string `json:"exclude_hosting"`
+
+	// Features servers must have
+	RequireFeatures []string `json:"require_features"`
+}
+
+// Filter used when nothing else is set: only servers owned by provider
+func DefaultServerFilter() ServerFilter {
+	return ServerFilter{OnlyOwned: true}
+}
+
+// Check if server passes the filter
+func (f ServerFilter) Allows(Server WireguardServer) bool {
+	if f.OnlyOwned && Server.Owned != nil && !*Server.Owned {
+		return false
+	}
+
+	for _, hosting := range f.ExcludeHosting {
+		if Server.Hosting != "" && strings.EqualFold(hosting, Server.Hosting) {
+			return false
+		}
+	}
+
+	for _, feature := range f.RequireFeatures {
+		if !slices.Contains(Server.Features, strings.ToLower(feature)) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// Describe the filter
+func (f ServerFilter) String() string {
+	parts := []string{}
+
+	if f.OnlyOwned {
+		parts = append(parts, "only owned")
+	}
+
+	if len(f.ExcludeHosting) > 0 {
+		parts = append(parts, "excluding "+strings.Join(f.ExcludeHosting, ", "))
+	}
+
+	if len(f.RequireFeatures) > 0 {
+		parts = append(parts, "with "+strings.Join(f.RequireFeatures, ", "))
+	}
+
+	if len(parts) == 0 {
+		return "none"
+	}
+
+	return strings.Join(parts, "; ")
+}
+
+// Describe server attributes, like "owned, 31173, daita"
+func (s WireguardServer) Attributes() string {
+	parts := []string{}
+
+	if s.Owned != nil {
+		if *s.Owned {
+			parts = append(parts, "owned")
+		} else {
+			parts = append(parts, "rented")
+		}
+	}
+
+	if s.Hosting != "" {
+		parts = append(parts, s.Hosting)
+	}
+
+	if s.Weight > 0 {
+		parts = append(parts, fmt.Sprintf("weight %d", s.Weight))
+	}
+
+	parts = append(parts, s.Features...)
+
+	return strings.Join(parts, ", ")
+}
+
+// Drop servers which don't pass the filter
+func filterServers(Servers []WireguardServer, Filter ServerFilter) []WireguardServer {
+	allowed := []WireguardServer{}
+
+	for _, server := range Servers {
+		if Filter.Allows(server) {
+			allowed = append(allowed, server)
+		}
+	}
+
+	return allowed
+}
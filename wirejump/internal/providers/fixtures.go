@@ -0,0 +1,141 @@
+package providers
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path"
+	"regexp"
+	"strings"
+)
+
+// Fixtures are recorded API responses, which allow to validate providers
+// offline. Record them once against a real account, then replay as many
+// times as needed without touching provider API at all:
+//
+//	wirejumpd --config ... --record-fixtures /tmp/ivpn
+//	wirejumpd --config ... --replay-fixtures /tmp/ivpn
+//
+// Each request is stored in a separate file, named after its method and URL,
+// so repeated requests to the same endpoint will replay the latest response.
+// Recorded responses contain account data and tokens, handle them with care.
+
+// Anything which can't be a part of fixture file name
+var fixtureNameFilter = regexp.MustCompile(`[^A-Za-z0-9.-]+`)
+
+// Single recorded API response
+type Fixture struct {
+	Method string `json:"method"`
+	URL    string `json:"url"`
+	Status int    `json:"status"`
+	Body   string `json:"body"`
+}
+
+// Replays previously recorded responses, fails on unknown requests
+type ReplayTransport struct {
+	Directory string
+}
+
+// Performs requests and records their responses
+type RecordTransport struct {
+	Directory string
+	Upstream  http.RoundTripper
+}
+
+// Get fixture file name for a particular request
+func FixtureName(method string, url string) string {
+	url = strings.TrimPrefix(strings.TrimPrefix(url, "https://"), "http://")
+	name := fixtureNameFilter.ReplaceAllString(url, "_")
+
+	return fmt.Sprintf("%s_%s.json", method, strings.Trim(name, "_"))
+}
+
+// Replace API transport with fixtures from the directory
+func UseFixtures(directory string, record bool) error {
+	if record {
+		if err := os.MkdirAll(directory, 0700); err != nil {
+			return fmt.Errorf("cannot create fixtures directory: %s", err)
+		}
+
+		Transport = &RecordTransport{Directory: directory, Upstream: http.DefaultTransport}
+	} else {
+		if _, err := os.Stat(directory); err != nil {
+			return fmt.Errorf("cannot use fixtures directory: %s", err)
+		}
+
+		Transport = &ReplayTransport{Directory: directory}
+	}
+
+	return nil
+}
+
+// Serve recorded response
+func (t *ReplayTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	name := FixtureName(req.Method, req.URL.String())
+	encoded, err := os.ReadFile(path.Join(t.Directory, name))
+
+	if err != nil {
+		return nil, fmt.Errorf("no fixture for %s %s: %s", req.Method, req.URL, err)
+	}
+
+	fixture := Fixture{}
+
+	if err := json.Unmarshal(encoded, &fixture); err != nil {
+		return nil, fmt.Errorf("invalid fixture '%s': %s", name, err)
+	}
+
+	return &http.Response{
+		Status:        fmt.Sprintf("%d %s", fixture.Status, http.StatusText(fixture.Status)),
+		StatusCode:    fixture.Status,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        http.Header{"Content-Type": []string{"application/json"}},
+		Body:          io.NopCloser(strings.NewReader(fixture.Body)),
+		ContentLength: int64(len(fixture.Body)),
+		Request:       req,
+	}, nil
+}
+
+// Make real request and save its response
+func (t *RecordTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	resp, err := t.Upstream.RoundTrip(req)
+
+	if err != nil {
+		return nil, err
+	}
+
+	body, err := io.ReadAll(resp.Body)
+	resp.Body.Close()
+
+	if err != nil {
+		return nil, err
+	}
+
+	// Response is consumed already, so give caller a fresh copy
+	resp.Body = io.NopCloser(bytes.NewReader(body))
+
+	fixture := Fixture{
+		Method: req.Method,
+		URL:    req.URL.String(),
+		Status: resp.StatusCode,
+		Body:   string(body),
+	}
+
+	encoded, err := json.MarshalIndent(fixture, "", "  ")
+
+	if err != nil {
+		return nil, fmt.Errorf("cannot encode fixture: %s", err)
+	}
+
+	name := FixtureName(req.Method, req.URL.String())
+
+	if err := os.WriteFile(path.Join(t.Directory, name), encoded, 0600); err != nil {
+		return nil, fmt.Errorf("cannot write fixture: %s", err)
+	}
+
+	return resp, nil
+}
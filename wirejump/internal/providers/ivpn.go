@@ -0,0 +1,424 @@
+package providers
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"maps"
+)
+
+// IVPN API is session based: each login creates a new session, which
+// holds a token for all protected requests. Sessions are limited per account,
+// same as Mullvad devices. Each session holds a single WireGuard key, which
+// is replaced with a new one on rotation, and an address assigned to this key.
+//
+// Server list is public and contains all the info needed for a connection,
+// including WireGuard ports, which are shared between all servers.
+//
+// Some reference code: https://github.com/ivpn/desktop-app/tree/master/daemon/api
+//
+// Presented here data structures are incomplete in purpose,
+// containing only required fields for this provider.
+
+// API URL
+var ivpnAPIBaseURL = "https://api.ivpn.net"
+
+// IVPN DNS server, available for all WireGuard connections
+const ivpnUpstreamGateway = "172.16.0.1"
+
+// Successful API request status
+const ivpnStatusOK = 200
+
+// Session is unknown to API: it has expired or has been deleted
+const ivpnStatusSessionNotFound = 601
+
+// IVPN provider
+type IvpnProvider struct {
+	WireguardProvider
+
+	// API URL builder
+	URL func(...interface{}) string
+
+	// Current session token
+	session string
+
+	// Addresses assigned to keys within the current session
+	addresses map[string]string
+}
+
+// Make sure provider is complete
+var _ UpstreamAPI = (*IvpnProvider)(nil)
+var _ SessionAPI = (*IvpnProvider)(nil)
+
+func init() {
+	RegisterProvider("ivpn", IvpnInit)
+}
+
+// Every API reply contains status and optional message
+type ivpnAPIStatus struct {
+	Status  int    `json:"status"`
+	Message string `json:"message"`
+}
+
+// Failed API request
+type ivpnAPIError ivpnAPIStatus
+
+func (e ivpnAPIError) Error() string {
+	return fmt.Sprintf("API error [%d]: %s", e.Status, e.Message)
+}
+
+// Account status
+type ivpnServiceStatus struct {
+	Active      bool  `json:"is_active"`
+	ActiveUntil int64 `json:"active_until"`
+}
+
+// Session creation request
+type ivpnSessionNewRequest struct {
+	Username string `json:"username"`
+}
+
+// Session creation reply
+type ivpnSessionNewReply struct {
+	ivpnAPIStatus
+	Token         string            `json:"token"`
+	ServiceStatus ivpnServiceStatus `json:"service_status"`
+}
+
+// Used for all session-only requests
+type ivpnSessionRequest struct {
+	Session string `json:"session_token"`
+}
+
+// Session status reply
+type ivpnSessionStatusReply struct {
+	ivpnAPIStatus
+	ServiceStatus ivpnServiceStatus `json:"service_status"`
+}
+
+// Used for pubkey rotation
+type ivpnKeySetRequest struct {
+	Session   string `json:"session_token"`
+	PublicKey string `json:"public_key"`
+}
+
+// Pubkey rotation reply
+type ivpnKeySetReply struct {
+	ivpnAPIStatus
+	IPAddress string `json:"ip_address"`
+}
+
+// Single WireGuard server
+type ivpnServerHost struct {
+	Hostname  string `json:"hostname"`
+	Host      string `json:"host"`
+	PublicKey string `json:"public_key"`
+	ISP       string `json:"isp"`
+	Multihop  int    `json:"multihop_port"`
+	IPv6      *struct {
+		Host string `json:"host"`
+	} `json:"ipv6"`
+}
+
+// Servers within a single location
+type ivpnServerLocation struct {
+	Gateway     string           `json:"gateway"`
+	CountryCode string           `json:"country_code"`
+	Country     string           `json:"country"`
+	City        string           `json:"city"`
+	Hosts       []ivpnServerHost `json:"hosts"`
+}
+
+// Port or range of ports
+type ivpnPort struct {
+	Type  string `json:"type"`
+	Port  int    `json:"port"`
+	Range *struct {
+		Min int `json:"min"`
+		Max int `json:"max"`
+	} `json:"range"`
+}
+
+// List of WireGuard servers with all required connection info
+type ivpnServersList struct {
+	Wireguard []ivpnServerLocation `json:"wireguard"`
+	Config    struct {
+		Ports struct {
+			Wireguard []ivpnPort `json:"wireguard"`
+		} `json:"ports"`
+	} `json:"config"`
+}
+
+// IVPN-specific API request. All requests are POST with JSON payload,
+// except for servers list. Both HTTP and reply status are checked
+func (i *IvpnProvider) APIRequest(Method string, URL string, Data interface{}, Reply interface{}) error {
+	api_error := ivpnAPIStatus{}
+	api_failed, err := RequestAPI(Method, URL, nil, Data, Reply, &api_error)
+
+	if err != nil {
+		if api_failed {
+			return ivpnAPIError(api_error)
+		}
+
+		return err
+	}
+
+	return nil
+}
+
+// Create new session if there's none yet
+func (i *IvpnProvider) login() error {
+	if i.session != "" {
+		return nil
+	}
+
+	request := ivpnSessionNewRequest{Username: i.Account.AccountID}
+	reply := ivpnSessionNewReply{}
+
+	if err := i.APIRequest("POST", i.URL("v4", "session", "new"), request, &reply); err != nil {
+		return fmt.Errorf("failed to create session: %s", err)
+	}
+
+	if reply.Status != ivpnStatusOK || reply.Token == "" {
+		return fmt.Errorf("failed to create session [%d]: %s", reply.Status, reply.Message)
+	}
+
+	i.session = reply.Token
+	i.addresses = map[string]string{}
+
+	return nil
+}
+
+// Check if request has failed because session is unknown to API
+func ivpnSessionLost(Status ivpnAPIStatus, Err error) bool {
+	api_error := ivpnAPIError{}
+
+	if errors.As(Err, &api_error) {
+		Status = ivpnAPIStatus(api_error)
+	}
+
+	return Status.Status == ivpnStatusSessionNotFound
+}
+
+// Run request within a session. Restored session could expire while
+// daemon was stopped, so in this case request is retried once with
+// a new session. Request returns reply status along with an error
+func (i *IvpnProvider) withSession(Request func() (ivpnAPIStatus, error)) error {
+	for retried := false; ; retried = true {
+		if err := i.login(); err != nil {
+			return err
+		}
+
+		status, err := Request()
+
+		if retried || !ivpnSessionLost(status, err) {
+			return err
+		}
+
+		log.Println("IVPN session is not found, creating a new one")
+
+		i.session = ""
+		i.addresses = map[string]string{}
+	}
+}
+
+// This will be run on setup to create IVPN provider
+func IvpnInit(Account WireguardProviderAccount) (UpstreamAPI, error) {
+	if Account.AccountID == "" {
+		return nil, errors.New("AccountID as string is required for IVPN")
+	}
+
+	p := IvpnProvider{
+		WireguardProvider: WireguardProvider{
+			Account:         Account,
+			Initialized:     true,
+			ProviderName:    "ivpn",
+			UpstreamGateway: ivpnUpstreamGateway,
+		},
+		URL:       FormatURL(ivpnAPIBaseURL, false),
+		addresses: map[string]string{},
+	}
+
+	return &p, nil
+}
+
+// Get provider info
+func (i *IvpnProvider) Details() *WireguardProvider {
+	return &i.WireguardProvider
+}
+
+// Get current session, so it can be continued after restart
+func (i *IvpnProvider) Session() *ProviderSession {
+	if i.session == "" {
+		return nil
+	}
+
+	return &ProviderSession{Token: i.session, Addresses: maps.Clone(i.addresses)}
+}
+
+// Continue saved session. Keys set within it are replaced by new ones,
+// and session is deleted along with the key, so nothing is left behind
+func (i *IvpnProvider) RestoreSession(Session ProviderSession) {
+	i.session = Session.Token
+	i.addresses = map[string]string{}
+
+	maps.Copy(i.addresses, Session.Addresses)
+}
+
+// Fetch account info
+func (i *IvpnProvider) GetAccountInfo() (WireguardAccount, error) {
+	reply := ivpnSessionStatusReply{}
+
+	err := i.withSession(func() (ivpnAPIStatus, error) {
+		request := ivpnSessionRequest{Session: i.session}
+		reply = ivpnSessionStatusReply{}
+		err := i.APIRequest("POST", i.URL("v4", "session", "status"), request, &reply)
+
+		return reply.ivpnAPIStatus, err
+	})
+
+	if err != nil {
+		return WireguardAccount{}, err
+	}
+
+	if reply.Status != ivpnStatusOK {
+		// Session can't be used anymore; delete it, so it doesn't
+		// take a session slot, next request will create a new one
+		request := ivpnSessionRequest{Session: i.session}
+
+		if err := i.APIRequest("POST", i.URL("v4", "session", "delete"), request, &ivpnAPIStatus{}); err != nil {
+			log.Printf("Failed to delete IVPN session: %s", err)
+		}
+
+		i.session = ""
+		i.addresses = map[string]string{}
+
+		return WireguardAccount{}, fmt.Errorf("failed to get account status [%d]: %s", reply.Status, reply.Message)
+	}
+
+	if !reply.ServiceStatus.Active {
+		return WireguardAccount{}, errors.New("this account is not active")
+	}
+
+	return WireguardAccount{
+		Expires:       reply.ServiceStatus.ActiveUntil,
+		CanAddDevices: true,
+	}, nil
+}
+
+// Get all WireGuard servers
+func (i *IvpnProvider) GetAllServers() ([]WireguardServer, error) {
+	all_servers := []WireguardServer{}
+	ivpnObject := ivpnServersList{}
+
+	if err := i.APIRequest("GET", i.URL("v5", "servers.json"), nil, &ivpnObject); err != nil {
+		return []WireguardServer{}, err
+	}
+
+	// Ports are shared by all servers, add them to a single pool
+	var incomingPorts []int
+
+	for _, port := range ivpnObject.Config.Ports.Wireguard {
+		if port.Range != nil {
+			for p := port.Range.Min; p <= port.Range.Max; p++ {
+				incomingPorts = append(incomingPorts, p)
+			}
+		} else if port.Port != 0 {
+			incomingPorts = append(incomingPorts, port.Port)
+		}
+	}
+
+	for _, location := range ivpnObject.Wireguard {
+		for _, host := range location.Hosts {
+			port := GetRandomElement(incomingPorts)
+
+			if port == nil {
+				return []WireguardServer{}, errors.New("can not query random server port")
+			}
+
+			server := WireguardServer{
+				Pubkey:    host.PublicKey,
+				IPv4:      host.Host,
+				Port:      *port,
+				City:      location.City,
+				Country:   location.Country,
+				CountryID: location.CountryCode,
+				Hostname:  host.Hostname,
+				Hosting:   host.ISP,
+			}
+
+			if host.Multihop != 0 {
+				server.Features = append(server.Features, FeatureMultihop)
+			}
+
+			// IPv6 endpoints are not available everywhere
+			if host.IPv6 != nil {
+				server.IPv6 = host.IPv6.Host
+			}
+
+			all_servers = append(all_servers, server)
+		}
+	}
+
+	return all_servers, nil
+}
+
+// Set public key for the current session. Previous key is replaced
+func (i *IvpnProvider) AddPubkey(key string) error {
+	reply := ivpnKeySetReply{}
+
+	err := i.withSession(func() (ivpnAPIStatus, error) {
+		request := ivpnKeySetRequest{Session: i.session, PublicKey: key}
+		reply = ivpnKeySetReply{}
+		err := i.APIRequest("POST", i.URL("v4", "session", "wg", "set"), request, &reply)
+
+		return reply.ivpnAPIStatus, err
+	})
+
+	if err != nil {
+		return fmt.Errorf("[AddPubkey] failed to set pubkey: %s", err)
+	}
+
+	if reply.Status != ivpnStatusOK || reply.IPAddress == "" {
+		return fmt.Errorf("[AddPubkey] failed to set pubkey [%d]: %s", reply.Status, reply.Message)
+	}
+
+	i.addresses[key] = reply.IPAddress
+
+	return nil
+}
+
+// Key can't be removed from a session, but session can be deleted
+// altogether, which frees it for another connection
+func (i *IvpnProvider) RemovePubkey(key string) error {
+	if _, exists := i.addresses[key]; !exists || i.session == "" {
+		return nil
+	}
+
+	request := ivpnSessionRequest{Session: i.session}
+	reply := ivpnAPIStatus{}
+
+	// Session which is already gone doesn't hold the key anymore
+	if err := i.APIRequest("POST", i.URL("v4", "session", "delete"), request, &reply); err != nil && !ivpnSessionLost(reply, err) {
+		return fmt.Errorf("[RemovePubkey] failed to delete session: %s", err)
+	}
+
+	i.session = ""
+	i.addresses = map[string]string{}
+
+	return nil
+}
+
+// Get address assigned to the key. If it's unknown (daemon has been
+// restarted, for example), set the key again to get it back
+func (i *IvpnProvider) GetAddress(key string) (string, error) {
+	if address, exists := i.addresses[key]; exists {
+		return address, nil
+	}
+
+	if err := i.AddPubkey(key); err != nil {
+		return "", fmt.Errorf("[GetAddress] %s", err)
+	}
+
+	return i.addresses[key], nil
+}
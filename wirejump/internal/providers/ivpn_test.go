@@ -0,0 +1,270 @@
+package providers
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+	"slices"
+	"strings"
+	"testing"
+)
+
+const (
+	ivpnTestAccount = "i-TEST-TEST-TEST"
+	ivpnTestToken   = "0f3c7e1b2a9d4c58b6e1f2a3d4c5b6a7"
+	ivpnTestKey     = "hSDwCYkwp1R0i33ctD73Wg2/Og0mOBr066SpjqqbTmo="
+	ivpnTestAddress = "172.28.14.93"
+)
+
+// Replays IVPN fixtures and keeps track of requests. Requests made
+// with expired token are refused the same way IVPN API does it;
+// replies can be replaced by request as well
+type ivpnTransport struct {
+	replay   ReplayTransport
+	expired  string
+	replies  map[string]string
+	requests []string
+}
+
+func (t *ivpnTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	body := []byte{}
+
+	if req.Body != nil {
+		body, _ = io.ReadAll(req.Body)
+		req.Body = io.NopCloser(bytes.NewReader(body))
+	}
+
+	request := req.Method + " " + req.URL.Path
+	t.requests = append(t.requests, request)
+
+	reply, replaced := t.replies[request]
+
+	if t.expired != "" && strings.Contains(string(body), t.expired) {
+		reply, replaced = `{"status":601,"message":"Session not found"}`, true
+	}
+
+	if replaced {
+		return &http.Response{
+			StatusCode: http.StatusOK,
+			Header:     http.Header{"Content-Type": []string{"application/json"}},
+			Body:       io.NopCloser(strings.NewReader(reply)),
+			Request:    req,
+		}, nil
+	}
+
+	return t.replay.RoundTrip(req)
+}
+
+// Create provider which talks to recorded IVPN API
+func ivpnFixtures(t *testing.T) (*IvpnProvider, *ivpnTransport) {
+	transport := &ivpnTransport{replay: ReplayTransport{Directory: "testdata/ivpn"}}
+	previous := Transport
+	Transport = transport
+
+	t.Cleanup(func() { Transport = previous })
+
+	provider, err := IvpnInit(WireguardProviderAccount{AccountID: ivpnTestAccount})
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return provider.(*IvpnProvider), transport
+}
+
+func TestIvpnInit(t *testing.T) {
+	if _, err := IvpnInit(WireguardProviderAccount{}); err == nil {
+		t.Errorf("provider without account is created")
+	}
+
+	provider, _ := ivpnFixtures(t)
+
+	if details := provider.Details(); details.ProviderName != "ivpn" || details.UpstreamGateway != ivpnUpstreamGateway {
+		t.Errorf("wrong provider details: %+v", details)
+	}
+
+	if session := provider.Session(); session != nil {
+		t.Errorf("new provider has a session: %+v", session)
+	}
+}
+
+func TestIvpnAccountInfo(t *testing.T) {
+	provider, transport := ivpnFixtures(t)
+	account, err := provider.GetAccountInfo()
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if account.Expires != 1893456000 || !account.CanAddDevices {
+		t.Errorf("wrong account info: %+v", account)
+	}
+
+	// Session is created on the first request and reused afterwards
+	if _, err := provider.GetAccountInfo(); err != nil {
+		t.Fatal(err)
+	}
+
+	want := []string{"POST /v4/session/new", "POST /v4/session/status", "POST /v4/session/status"}
+
+	if !slices.Equal(transport.requests, want) {
+		t.Errorf("got requests %v, want %v", transport.requests, want)
+	}
+
+	if session := provider.Session(); session == nil || session.Token != ivpnTestToken {
+		t.Errorf("wrong session: %+v", session)
+	}
+}
+
+func TestIvpnAccountInfoFailed(t *testing.T) {
+	provider, transport := ivpnFixtures(t)
+	transport.replies = map[string]string{"POST /v4/session/status": `{"status":500,"message":"Internal server error"}`}
+
+	if _, err := provider.GetAccountInfo(); err == nil {
+		t.Fatal("failed status is not reported")
+	}
+
+	// Unusable session is deleted, so it doesn't take a session slot
+	want := []string{"POST /v4/session/new", "POST /v4/session/status", "POST /v4/session/delete"}
+
+	if !slices.Equal(transport.requests, want) {
+		t.Errorf("got requests %v, want %v", transport.requests, want)
+	}
+
+	if session := provider.Session(); session != nil {
+		t.Errorf("session is kept after failure: %+v", session)
+	}
+}
+
+func TestIvpnServers(t *testing.T) {
+	provider, _ := ivpnFixtures(t)
+	servers, err := provider.GetAllServers()
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(servers) != 3 {
+		t.Fatalf("got %d servers, want 3", len(servers))
+	}
+
+	ports := []int{2049, 30000, 30001, 30002}
+
+	for _, server := range servers {
+		if !slices.Contains(ports, server.Port) {
+			t.Errorf("%s: port %d is not one of %v", server.Hostname, server.Port, ports)
+		}
+	}
+
+	first := servers[0]
+
+	if first.Hostname != "se1.wg.ivpn.net" || first.IPv4 != "80.67.10.141" || first.IPv6 != "2a00:1a28:1410:5::2a" {
+		t.Errorf("wrong server: %+v", first)
+	}
+
+	if first.CountryCode() != "se" || first.City != "Stockholm" || first.Hosting != "GleSYS" || first.Pubkey != "u1wdHrRzgPwKDlTJgHr4plagfFxPyp1bm0OtyzWmuGo=" {
+		t.Errorf("wrong server location or key: %+v", first)
+	}
+
+	if !slices.Contains(first.Features, FeatureMultihop) || slices.Contains(servers[1].Features, FeatureMultihop) {
+		t.Errorf("multihop feature is wrong: %v, %v", first.Features, servers[1].Features)
+	}
+
+	if servers[1].IPv6 != "" {
+		t.Errorf("server without IPv6 has address %s", servers[1].IPv6)
+	}
+}
+
+func TestIvpnPubkeys(t *testing.T) {
+	provider, transport := ivpnFixtures(t)
+
+	if err := provider.AddPubkey(ivpnTestKey); err != nil {
+		t.Fatal(err)
+	}
+
+	if address, err := provider.GetAddress(ivpnTestKey); err != nil || address != ivpnTestAddress {
+		t.Errorf("got address %s, %v, want %s", address, err, ivpnTestAddress)
+	}
+
+	// Unknown keys are not in the session, nothing to remove
+	if err := provider.RemovePubkey("3p7bfXt9wbTTW2HC7OQ1Nz+DQ8hbeGdNrfx+FG+IK08="); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := provider.RemovePubkey(ivpnTestKey); err != nil {
+		t.Fatal(err)
+	}
+
+	want := []string{"POST /v4/session/new", "POST /v4/session/wg/set", "POST /v4/session/delete"}
+
+	if !slices.Equal(transport.requests, want) {
+		t.Errorf("got requests %v, want %v", transport.requests, want)
+	}
+
+	if session := provider.Session(); session != nil {
+		t.Errorf("session is kept after removal: %+v", session)
+	}
+}
+
+func TestIvpnSessionRestore(t *testing.T) {
+	provider, _ := ivpnFixtures(t)
+
+	if err := provider.AddPubkey(ivpnTestKey); err != nil {
+		t.Fatal(err)
+	}
+
+	session := provider.Session()
+
+	if session == nil || session.Token != ivpnTestToken || session.Addresses[ivpnTestKey] != ivpnTestAddress {
+		t.Fatalf("wrong session: %+v", session)
+	}
+
+	// Restarted daemon continues the session, so the key can be removed
+	restarted, transport := ivpnFixtures(t)
+	restarted.RestoreSession(*session)
+
+	if address, err := restarted.GetAddress(ivpnTestKey); err != nil || address != ivpnTestAddress {
+		t.Errorf("got address %s, %v, want %s", address, err, ivpnTestAddress)
+	}
+
+	if err := restarted.RemovePubkey(ivpnTestKey); err != nil {
+		t.Fatal(err)
+	}
+
+	if want := []string{"POST /v4/session/delete"}; !slices.Equal(transport.requests, want) {
+		t.Errorf("got requests %v, want %v", transport.requests, want)
+	}
+}
+
+func TestIvpnSessionExpired(t *testing.T) {
+	provider, transport := ivpnFixtures(t)
+	transport.expired = "expired-token"
+
+	provider.RestoreSession(ProviderSession{Token: "expired-token", Addresses: map[string]string{ivpnTestKey: "172.28.1.1"}})
+
+	// New session is created and request is retried
+	if err := provider.AddPubkey(ivpnTestKey); err != nil {
+		t.Fatal(err)
+	}
+
+	want := []string{"POST /v4/session/wg/set", "POST /v4/session/new", "POST /v4/session/wg/set"}
+
+	if !slices.Equal(transport.requests, want) {
+		t.Errorf("got requests %v, want %v", transport.requests, want)
+	}
+
+	if session := provider.Session(); session == nil || session.Token != ivpnTestToken || session.Addresses[ivpnTestKey] != ivpnTestAddress {
+		t.Errorf("wrong session: %+v", session)
+	}
+
+	// Expired session is gone already, so removal succeeds
+	provider.RestoreSession(ProviderSession{Token: "expired-token", Addresses: map[string]string{ivpnTestKey: ivpnTestAddress}})
+
+	if err := provider.RemovePubkey(ivpnTestKey); err != nil {
+		t.Errorf("expired session is not removed: %s", err)
+	}
+
+	if session := provider.Session(); session != nil {
+		t.Errorf("session is kept after removal: %+v", session)
+	}
+}
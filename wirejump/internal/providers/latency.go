@@ -0,0 +1,184 @@
+package providers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os/exec"
+	"regexp"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Trust measured relay latency for up to this much seconds
+const LatencyCacheTime = 3600
+
+// How many relays are probed at once
+const maxConcurrentProbes = 8
+
+// Measured relay latency; relays which did not reply are kept
+// as well, so they are not probed again on every connect
+type LatencyScore struct {
+	RTT       time.Duration `json:"rtt"`
+	Reachable bool          `json:"reachable"`
+	Checked   int64         `json:"checked"`
+}
+
+// Measures round trip time to a relay
+type Prober interface {
+	Probe(Server WireguardServer) (time.Duration, error)
+}
+
+// Prober used for all measurements, can be replaced with SetProber
+var activeProber Prober = PingProber{Count: 3, Timeout: 5 * time.Second}
+
+// Replace active prober
+func SetProber(p Prober) {
+	if p != nil {
+		activeProber = p
+	}
+}
+
+// Average RTT from ping summary line
+var pingSummary = regexp.MustCompile(`= [0-9.]+/([0-9.]+)/`)
+
+// Prober using system ping utility, since ICMP sockets are
+// not available for unprivileged daemon user
+type PingProber struct {
+	Count   int
+	Timeout time.Duration
+}
+
+// Ping relay IPv4 address and return average RTT
+func (p PingProber) Probe(Server WireguardServer) (time.Duration, error) {
+	if Server.IPv4 == "" {
+		return 0, errors.New("relay has no IPv4 address")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), p.Timeout)
+	defer cancel()
+
+	cmd := exec.CommandContext(ctx, "ping", "-n", "-q", "-c", fmt.Sprint(p.Count), "-i", "0.2", "-W", "1", Server.IPv4)
+	output, err := cmd.Output()
+
+	if err != nil {
+		return 0, fmt.Errorf("ping has failed: %s", err)
+	}
+
+	match := pingSummary.FindSubmatch(output)
+
+	if match == nil {
+		return 0, errors.New("no RTT in ping output")
+	}
+
+	ms, err := strconv.ParseFloat(string(match[1]), 64)
+
+	if err != nil {
+		return 0, fmt.Errorf("invalid RTT in ping output: %s", err)
+	}
+
+	return time.Duration(ms * float64(time.Millisecond)), nil
+}
+
+// Get cached latency score for a server, if it's still fresh
+func (s *ServersState) LatencyOf(Server WireguardServer) (LatencyScore, bool) {
+	score, ok := s.Latency[Server.Hostname]
+
+	if !ok || time.Now().Unix()-score.Checked > LatencyCacheTime {
+		return LatencyScore{}, false
+	}
+
+	return score, true
+}
+
+// Get servers for a particular location which need probing: the ones
+// without a fresh score, or all of them if Force is set
+func PendingLatency(Servers *ServersState, Location string, Force bool) []WireguardServer {
+	var pending []WireguardServer
+
+	if Servers == nil {
+		return pending
+	}
+
+	for _, server := range locationServers(Servers, Location) {
+		if _, fresh := Servers.LatencyOf(server); Force || !fresh {
+			pending = append(pending, server)
+		}
+	}
+
+	return pending
+}
+
+// Probe servers and get their scores by hostname. Probes take a while,
+// and servers state is not used, so it's called without holding app state
+func ProbeLatency(Pending []WireguardServer) map[string]LatencyScore {
+	var lock sync.Mutex
+	var wg sync.WaitGroup
+
+	scores := make(map[string]LatencyScore)
+	slots := make(chan struct{}, maxConcurrentProbes)
+
+	for _, server := range Pending {
+		wg.Add(1)
+		slots <- struct{}{}
+
+		go func(server WireguardServer) {
+			defer wg.Done()
+			defer func() { <-slots }()
+
+			rtt, err := activeProber.Probe(server)
+			score := LatencyScore{
+				RTT:       rtt,
+				Reachable: err == nil,
+				Checked:   time.Now().Unix(),
+			}
+
+			lock.Lock()
+			scores[server.Hostname] = score
+			lock.Unlock()
+		}(server)
+	}
+
+	wg.Wait()
+
+	return scores
+}
+
+// Cache probe results
+func (s *ServersState) ApplyLatency(Scores map[string]LatencyScore) {
+	if len(Scores) == 0 {
+		return
+	}
+
+	if s.Latency == nil {
+		s.Latency = make(map[string]LatencyScore)
+	}
+
+	for hostname, score := range Scores {
+		s.Latency[hostname] = score
+	}
+}
+
+// Get servers for a particular location which replied to probes,
+// fastest first
+func FastestServers(Servers *ServersState, Location string) []WireguardServer {
+	var measured []WireguardServer
+
+	if Servers == nil {
+		return measured
+	}
+
+	for _, server := range locationServers(Servers, Location) {
+		if score, ok := Servers.LatencyOf(server); ok && score.Reachable {
+			measured = append(measured, server)
+		}
+	}
+
+	sort.SliceStable(measured, func(i, j int) bool {
+		return Servers.Latency[measured[i].Hostname].RTT < Servers.Latency[measured[j].Hostname].RTT
+	})
+
+	return measured
+}
@@ -0,0 +1,255 @@
+package providers
+
+import (
+	"errors"
+	"math/rand"
+	"sync"
+	"testing"
+	"time"
+)
+
+// Prober with predefined RTT by hostname; missing servers don't reply
+type fakeProber struct {
+	rtt    map[string]time.Duration
+	lock   sync.Mutex
+	probed []string
+}
+
+func (f *fakeProber) Probe(Server WireguardServer) (time.Duration, error) {
+	f.lock.Lock()
+	f.probed = append(f.probed, Server.Hostname)
+	f.lock.Unlock()
+
+	if rtt, ok := f.rtt[Server.Hostname]; ok {
+		return rtt, nil
+	}
+
+	return 0, errors.New("timeout")
+}
+
+// Replace active prober for a single test
+func useProber(t *testing.T, p Prober) {
+	previous := activeProber
+	SetProber(p)
+
+	t.Cleanup(func() { activeProber = previous })
+}
+
+// Five Swedish servers in two cities and a German one
+func latencyServers() *ServersState {
+	se := []WireguardServer{}
+
+	for _, host := range []struct{ name, city string }{
+		{"se-got-wg-001", "got"},
+		{"se-got-wg-002", "got"},
+		{"se-sto-wg-001", "sto"},
+		{"se-sto-wg-002", "sto"},
+		{"se-sto-wg-003", "sto"},
+	} {
+		se = append(se, WireguardServer{Hostname: host.name, Country: "Sweden", CountryID: "se", CityID: host.city})
+	}
+
+	return &ServersState{
+		Available: map[string][]WireguardServer{
+			"se": se,
+			"de": {{Hostname: "de-fra-wg-001", Country: "Germany", CountryID: "de", CityID: "fra"}},
+		},
+		Locations: []string{"de", "se"},
+	}
+}
+
+func latencyProber() *fakeProber {
+	return &fakeProber{rtt: map[string]time.Duration{
+		"se-got-wg-001": 30 * time.Millisecond,
+		"se-got-wg-002": 10 * time.Millisecond,
+		"se-sto-wg-001": 20 * time.Millisecond,
+		"se-sto-wg-003": 5 * time.Millisecond,
+		"de-fra-wg-001": 1 * time.Millisecond,
+	}}
+}
+
+// Probe a location and cache the results
+func measure(Servers *ServersState, Location string, Force bool) {
+	Servers.ApplyLatency(ProbeLatency(PendingLatency(Servers, Location, Force)))
+}
+
+func hostnames(Servers []WireguardServer) []string {
+	names := []string{}
+
+	for _, server := range Servers {
+		names = append(names, server.Hostname)
+	}
+
+	return names
+}
+
+func equalNames(a []string, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+
+	return true
+}
+
+func TestProbeLatencyScores(t *testing.T) {
+	prober := latencyProber()
+	useProber(t, prober)
+
+	servers := latencyServers()
+	measure(servers, "se", false)
+
+	if len(prober.probed) != 5 {
+		t.Fatalf("probed %d servers, want 5: %v", len(prober.probed), prober.probed)
+	}
+
+	tests := []struct {
+		hostname  string
+		rtt       time.Duration
+		reachable bool
+	}{
+		{"se-got-wg-001", 30 * time.Millisecond, true},
+		{"se-got-wg-002", 10 * time.Millisecond, true},
+		{"se-sto-wg-001", 20 * time.Millisecond, true},
+		{"se-sto-wg-002", 0, false},
+		{"se-sto-wg-003", 5 * time.Millisecond, true},
+	}
+
+	for _, test := range tests {
+		score, ok := servers.LatencyOf(WireguardServer{Hostname: test.hostname})
+
+		if !ok {
+			t.Errorf("%s: no fresh score", test.hostname)
+			continue
+		}
+
+		if score.RTT != test.rtt || score.Reachable != test.reachable {
+			t.Errorf("%s: got %v/%v, want %v/%v", test.hostname, score.RTT, score.Reachable, test.rtt, test.reachable)
+		}
+	}
+
+	// German server is in another location
+	if _, ok := servers.LatencyOf(WireguardServer{Hostname: "de-fra-wg-001"}); ok {
+		t.Errorf("server outside of location has been probed")
+	}
+}
+
+func TestPendingLatency(t *testing.T) {
+	useProber(t, latencyProber())
+
+	servers := latencyServers()
+	measure(servers, "se/sto", false)
+
+	if pending := hostnames(PendingLatency(servers, "se", false)); !equalNames(pending, []string{"se-got-wg-001", "se-got-wg-002"}) {
+		t.Errorf("fresh scores are probed again: %v", pending)
+	}
+
+	if pending := PendingLatency(servers, "se", true); len(pending) != 5 {
+		t.Errorf("forced probe has %d servers, want 5", len(pending))
+	}
+
+	// Stale scores are probed again, unreachable servers as well
+	stale := servers.Latency["se-sto-wg-001"]
+	stale.Checked -= LatencyCacheTime + 1
+	servers.Latency["se-sto-wg-001"] = stale
+
+	if pending := hostnames(PendingLatency(servers, "se/sto", false)); !equalNames(pending, []string{"se-sto-wg-001"}) {
+		t.Errorf("stale scores are not probed: %v", pending)
+	}
+
+	if pending := PendingLatency(nil, "se", false); len(pending) != 0 {
+		t.Errorf("nil servers have pending probes: %v", pending)
+	}
+}
+
+func TestFastestServers(t *testing.T) {
+	useProber(t, latencyProber())
+
+	servers := latencyServers()
+
+	if fastest := FastestServers(servers, "se"); len(fastest) != 0 {
+		t.Errorf("unmeasured servers are listed: %v", hostnames(fastest))
+	}
+
+	measure(servers, "se", false)
+
+	want := []string{"se-sto-wg-003", "se-got-wg-002", "se-sto-wg-001", "se-got-wg-001"}
+
+	if fastest := hostnames(FastestServers(servers, "se")); !equalNames(fastest, want) {
+		t.Errorf("got %v, want %v", fastest, want)
+	}
+}
+
+func TestSelectServerBestN(t *testing.T) {
+	useProber(t, latencyProber())
+
+	servers := latencyServers()
+	measure(servers, "se", false)
+
+	strategy := RandomStrategy{Rand: rand.New(rand.NewSource(1))}
+	picked := map[string]int{}
+
+	for i := 0; i < 100; i++ {
+		server, err := SelectServer(servers, strategy, SelectionParams{Location: "se", BestN: 2})
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		picked[server.Hostname]++
+	}
+
+	if len(picked) != 2 || picked["se-sto-wg-003"] == 0 || picked["se-got-wg-002"] == 0 {
+		t.Errorf("picks are not limited to two fastest servers: %v", picked)
+	}
+
+	// Previous server is kept along with the fastest ones, so sticky strategy keeps it
+	previous := WireguardServer{Hostname: "se-got-wg-001", Country: "Sweden", CountryID: "se", CityID: "got"}
+	sticky := StickyStrategy{Fallback: strategy}
+
+	if server, err := SelectServer(servers, sticky, SelectionParams{Location: "se", BestN: 1, Previous: &previous}); err != nil || server.Hostname != previous.Hostname {
+		t.Errorf("sticky strategy has not kept previous server: %s, %v", server.Hostname, err)
+	}
+
+	// Same for previous server which is not the slowest one
+	previous = WireguardServer{Hostname: "se-got-wg-002", Country: "Sweden", CountryID: "se", CityID: "got"}
+
+	if server, err := SelectServer(servers, sticky, SelectionParams{Location: "se", BestN: 1, Previous: &previous}); err != nil || server.Hostname != previous.Hostname {
+		t.Errorf("sticky strategy has not kept previous server: %s, %v", server.Hostname, err)
+	}
+
+	// Excluded fastest server is never picked
+	for i := 0; i < 20; i++ {
+		server, err := SelectServer(servers, strategy, SelectionParams{Location: "se", BestN: 1, Excluded: []string{"se-sto-wg-003"}})
+
+		if err != nil || server.Hostname != "se-got-wg-002" {
+			t.Fatalf("got %s, %v, want se-got-wg-002", server.Hostname, err)
+		}
+	}
+}
+
+func TestSelectServerBestNUnmeasured(t *testing.T) {
+	servers := latencyServers()
+	strategy := RandomStrategy{Rand: rand.New(rand.NewSource(1))}
+	picked := map[string]bool{}
+
+	// Without scores, all servers in location are candidates
+	for i := 0; i < 200; i++ {
+		server, err := SelectServer(servers, strategy, SelectionParams{Location: "se", BestN: 1})
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		picked[server.Hostname] = true
+	}
+
+	if len(picked) != 5 {
+		t.Errorf("picked %d servers, want all 5: %v", len(picked), picked)
+	}
+}
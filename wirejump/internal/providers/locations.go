@@ -0,0 +1,123 @@
+package providers
+
+import (
+	"sort"
+	"strings"
+)
+
+// Locations are hierarchical: country ("se"), city within a country
+// ("se/got") and a single server, referred by its hostname ("se-got-wg-001").
+// Countries can be referred by their names as well ("Sweden")
+
+// Separates country and city in location
+const LocationSeparator = "/"
+
+// Make location part out of a name, like "new-york" out of "New York"
+func locationCode(name string) string {
+	return strings.ToLower(strings.Join(strings.Fields(name), "-"))
+}
+
+// Get country part of server location, like "se"
+func (s WireguardServer) CountryCode() string {
+	if s.CountryID != "" {
+		return strings.ToLower(s.CountryID)
+	}
+
+	return locationCode(s.Country)
+}
+
+// Get city location of the server, like "se/got"
+func (s WireguardServer) CityLocation() string {
+	city := locationCode(s.City)
+
+	if s.CityID != "" {
+		city = strings.ToLower(s.CityID)
+	}
+
+	return s.CountryCode() + LocationSeparator + city
+}
+
+// Check if server belongs to a location
+func (s WireguardServer) InLocation(Location string) bool {
+	location := strings.ToLower(strings.TrimSpace(Location))
+
+	if location == "" {
+		return false
+	}
+
+	if strings.Contains(location, LocationSeparator) {
+		return s.CityLocation() == location
+	}
+
+	return location == s.CountryCode() || location == strings.ToLower(s.Country) || location == strings.ToLower(s.Hostname)
+}
+
+// Sort servers by hostname, so selection does not depend on map order
+func sortServers(Servers []WireguardServer) {
+	sort.SliceStable(Servers, func(i, j int) bool {
+		return Servers[i].Hostname < Servers[j].Hostname
+	})
+}
+
+// Get all servers in a particular location
+func (s *ServersState) Match(Location string) []WireguardServer {
+	matched := []WireguardServer{}
+
+	for _, servers := range s.Available {
+		for _, server := range servers {
+			if server.InLocation(Location) {
+				matched = append(matched, server)
+			}
+		}
+	}
+
+	sortServers(matched)
+
+	return matched
+}
+
+// Get servers for a particular location, or all of them if there are none
+func locationServers(Servers *ServersState, Location string) []WireguardServer {
+	if matched := Servers.Match(Location); len(matched) > 0 {
+		return matched
+	}
+
+	all_servers := []WireguardServer{}
+
+	for _, servers := range Servers.Available {
+		all_servers = append(all_servers, servers...)
+	}
+
+	sortServers(all_servers)
+
+	return all_servers
+}
+
+// Check if server belongs to any of the locations
+func (s WireguardServer) InAnyLocation(Locations []string) bool {
+	for _, location := range Locations {
+		if s.InLocation(location) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Drop servers which belong to any of excluded locations
+func withoutExcluded(Servers []WireguardServer, Excluded []string) []WireguardServer {
+	allowed := []WireguardServer{}
+
+	for _, server := range Servers {
+		if !server.InAnyLocation(Excluded) {
+			allowed = append(allowed, server)
+		}
+	}
+
+	return allowed
+}
+
+// Get servers in a particular location, except excluded and filtered out ones
+func (s *ServersState) MatchAllowed(Location string, Excluded []string, Filter ServerFilter) []WireguardServer {
+	return filterServers(withoutExcluded(s.Match(Location), Excluded), Filter)
+}
@@ -4,6 +4,7 @@ import (
 	"errors"
 	"fmt"
 	"net/http"
+	"strings"
 	"time"
 )
 
@@ -57,8 +58,27 @@ var mullvadAPIBaseURL = "https://api.mullvad.net"
 // probably exist as a separate setting.
 const HijackDNSOption = true
 
-// Holds auth data
-var authToken *mullvadAuthToken
+// Mullvad allows this many devices per account
+const mullvadMaxDevices = 5
+
+// Mullvad provider
+type MullvadProvider struct {
+	WireguardProvider
+
+	// API URL builder
+	URL func(...interface{}) string
+
+	// Holds auth data
+	token *mullvadAuthToken
+}
+
+// Make sure provider is complete
+var _ UpstreamAPI = (*MullvadProvider)(nil)
+var _ MultiDeviceAPI = (*MullvadProvider)(nil)
+
+func init() {
+	RegisterProvider("mullvad", MullvadInit)
+}
 
 // Represents error returned by API
 type mullvadAPIError struct {
@@ -90,7 +110,11 @@ type mullvadWireguardServer struct {
 	Active   bool   `json:"active"`
 	Owned    bool   `json:"owned"`
 	IPv4Addr string `json:"ipv4_addr_in"`
+	IPv6Addr string `json:"ipv6_addr_in"`
 	Pubkey   string `json:"public_key"`
+	Weight   int    `json:"weight"`
+	Provider string `json:"provider"`
+	DAITA    bool   `json:"daita"`
 }
 
 // Wireguard server wrapper with some additional info
@@ -144,11 +168,11 @@ func parseExpiry(value string) (time.Time, error) {
 }
 
 // Check auth token for validity
-func tokenIsValid() bool {
-	if authToken != nil {
+func (m *MullvadProvider) tokenIsValid() bool {
+	if m.token != nil {
 		// Check expiration date...
-		if authToken.Expiry != "" {
-			expiry, err := parseExpiry(authToken.Expiry)
+		if m.token.Expiry != "" {
+			expiry, err := parseExpiry(m.token.Expiry)
 
 			if err != nil {
 				return false
@@ -165,14 +189,14 @@ func tokenIsValid() bool {
 }
 
 // Mullvad-specific API request. Will update auth token automatically if needed
-func (m *WireguardProvider) APIRequest(Method string, URL string, UseAuth bool, Data interface{}, Reply interface{}) error {
+func (m *MullvadProvider) APIRequest(Method string, URL string, UseAuth bool, Data interface{}, Reply interface{}) error {
 	headers := make(http.Header)
 	api_error := mullvadAPIError{}
 
 	// Check if token is required
 	if UseAuth {
 		// Token needs to be refreshed
-		if !tokenIsValid() {
+		if !m.tokenIsValid() {
 			url := m.URL("auth", "v1", "token")
 			req := mullvadAuthTokenRequest{Account: m.Account.AccountID}
 			token := mullvadAuthToken{}
@@ -187,11 +211,11 @@ func (m *WireguardProvider) APIRequest(Method string, URL string, UseAuth bool,
 				return err
 			}
 
-			authToken = &token
+			m.token = &token
 		}
 
 		// Create auth header
-		headers.Add("Authorization", fmt.Sprintf("Bearer %s", authToken.Token))
+		headers.Add("Authorization", fmt.Sprintf("Bearer %s", m.token.Token))
 	}
 
 	// Make API request
@@ -208,13 +232,14 @@ func (m *WireguardProvider) APIRequest(Method string, URL string, UseAuth bool,
 	return nil
 }
 
-// This will be run on startup to register Mullvad provider
-func MullvadInit(Account WireguardProviderAccount) (p WireguardProvider, e error) {
+// This will be run on setup to create Mullvad provider
+func MullvadInit(Account WireguardProviderAccount) (UpstreamAPI, error) {
 	if Account.AccountID == "" {
-		e = errors.New("AccountID as string is required for Mullvad")
-	} else {
-		e = nil
-		p = WireguardProvider{
+		return nil, errors.New("AccountID as string is required for Mullvad")
+	}
+
+	p := MullvadProvider{
+		WireguardProvider: WireguardProvider{
 			Account:      Account,
 			Initialized:  true,
 			ProviderName: "mullvad",
@@ -222,18 +247,25 @@ func MullvadInit(Account WireguardProviderAccount) (p WireguardProvider, e error
 			// This address seems to be static across the years, although
 			// new API returns upstream gateway address explicitly now
 			UpstreamGateway: "10.64.0.1",
-			URL:             FormatURL(mullvadAPIBaseURL, false),
-		}
-
-		// initialize token
-		authToken = nil
+		},
+		URL: FormatURL(mullvadAPIBaseURL, false),
 	}
 
-	return p, e
+	return &p, nil
+}
+
+// Get provider info
+func (m *MullvadProvider) Details() *WireguardProvider {
+	return &m.WireguardProvider
+}
+
+// Each key is a separate device
+func (m *MullvadProvider) MultiDevice() int {
+	return mullvadMaxDevices
 }
 
 // Fetch account info
-func (m *WireguardProvider) GetAccountInfo() (WireguardAccount, error) {
+func (m *MullvadProvider) GetAccountInfo() (WireguardAccount, error) {
 	acc := mullvadAccount{}
 	url := m.URL("accounts", "v1", "accounts", "me")
 	err := m.APIRequest("GET", url, true, nil, &acc)
@@ -248,17 +280,15 @@ func (m *WireguardProvider) GetAccountInfo() (WireguardAccount, error) {
 		return WireguardAccount{}, err
 	}
 
-	if !acc.CanAddDevices {
-		return WireguardAccount{}, errors.New("this account can not add new devices")
-	}
-
 	return WireguardAccount{
-		Expires: expiry.Unix(),
+		Expires:       expiry.Unix(),
+		CanAddDevices: acc.CanAddDevices,
 	}, nil
 }
 
-// Get all active & owned (as claimed by Mullvad) WireGuard servers
-func (m *WireguardProvider) GetAllServers() ([]WireguardServer, error) {
+// Get all active WireGuard servers; ownership (as claimed by Mullvad)
+// is kept as server attribute, so rented servers can be filtered out
+func (m *MullvadProvider) GetAllServers() ([]WireguardServer, error) {
 	all_servers := []WireguardServer{}
 	mullvadObject := mullvadServersList{}
 	url := m.URL("app", "v1", "relays")
@@ -280,7 +310,7 @@ func (m *WireguardProvider) GetAllServers() ([]WireguardServer, error) {
 
 	// Assemble final list
 	for _, relay := range mullvadObject.Wireguard.Relays {
-		if relay.Active && relay.Owned {
+		if relay.Active {
 			port := GetRandomElement(incomingPorts)
 
 			if port == nil {
@@ -288,12 +318,25 @@ func (m *WireguardProvider) GetAllServers() ([]WireguardServer, error) {
 			}
 
 			location := mullvadObject.Locations[relay.Location]
+			owned := relay.Owned
+			country_id, city_id, _ := strings.Cut(relay.Location, "-")
 			server := WireguardServer{
-				Pubkey:  relay.Pubkey,
-				IPv4:    relay.IPv4Addr,
-				Port:    *port,
-				City:    location.City,
-				Country: location.Country,
+				Pubkey:    relay.Pubkey,
+				IPv4:      relay.IPv4Addr,
+				IPv6:      relay.IPv6Addr,
+				Port:      *port,
+				City:      location.City,
+				Country:   location.Country,
+				CountryID: country_id,
+				CityID:    city_id,
+				Hostname:  relay.Hostname,
+				Weight:    relay.Weight,
+				Owned:     &owned,
+				Hosting:   relay.Provider,
+			}
+
+			if relay.DAITA {
+				server.Features = append(server.Features, FeatureDAITA)
 			}
 
 			all_servers = append(all_servers, server)
@@ -304,7 +347,7 @@ func (m *WireguardProvider) GetAllServers() ([]WireguardServer, error) {
 }
 
 // Add new public key to the account. This will create new mullvad device
-func (m *WireguardProvider) AddPubkey(key string) error {
+func (m *MullvadProvider) AddPubkey(key string) error {
 	url := m.URL("accounts", "v1", "devices")
 	request := mullvadDeviceRequest{
 		Pubkey:    key,
@@ -323,7 +366,7 @@ func (m *WireguardProvider) AddPubkey(key string) error {
 
 // Remove existing public key from the account.
 // This will delete mullvad device.
-func (m *WireguardProvider) RemovePubkey(key string) error {
+func (m *MullvadProvider) RemovePubkey(key string) error {
 	// List all devices
 	devices := []mullvadDevice{}
 	url := m.URL("accounts", "v1", "devices")
@@ -349,7 +392,7 @@ func (m *WireguardProvider) RemovePubkey(key string) error {
 }
 
 // Iterate all devices and fetch an address for a matching pubkey
-func (m *WireguardProvider) GetAddress(key string) (string, error) {
+func (m *MullvadProvider) GetAddress(key string) (string, error) {
 	// List all devices
 	devices := []mullvadDevice{}
 	url := m.URL("accounts", "v1", "devices")
@@ -362,6 +405,10 @@ func (m *WireguardProvider) GetAddress(key string) (string, error) {
 	// Iterate all devices
 	for _, device := range devices {
 		if key == device.Pubkey {
+			if device.IPv6Address != "" {
+				return fmt.Sprintf("%s, %s", device.IPv4Address, device.IPv6Address), nil
+			}
+
 			return device.IPv4Address, nil
 		}
 	}
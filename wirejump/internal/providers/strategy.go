@@ -0,0 +1,310 @@
+package providers
+
+import (
+	"errors"
+	"fmt"
+	"math/rand"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Strategy used when nothing else is set
+const DefaultStrategy = "random"
+
+// Names of available selection strategies
+var StrategyNames = []string{"random", "round-robin", "least-used", "sticky", "weighted"}
+
+// Random source shared by strategies; selection always happens
+// under application state lock, so it's not used concurrently
+var strategyRand = rand.New(rand.NewSource(time.Now().UnixNano()))
+
+// Everything a strategy needs to know to pick a server
+type Selection struct {
+	// Servers to pick from, all in the same location
+	Candidates []WireguardServer
+
+	// Server which was used before, if any
+	Previous *WireguardServer
+
+	// Whether previous server has stopped responding
+	Failed bool
+
+	// When servers were selected last time, UNIX timestamps by hostname
+	LastUsed map[string]int64
+}
+
+// Picks upstream server among candidates
+type SelectionStrategy interface {
+	Select(Choice Selection) (WireguardServer, error)
+}
+
+// Create strategy by name; Rand is used as random source, so
+// selection can be repeated with the same seed
+func NewStrategy(Name string, Rand *rand.Rand) (SelectionStrategy, error) {
+	if Rand == nil {
+		Rand = strategyRand
+	}
+
+	switch Name {
+	case "", "random":
+		return RandomStrategy{Rand: Rand}, nil
+	case "round-robin":
+		return RoundRobinStrategy{Rand: Rand}, nil
+	case "least-used":
+		return LeastUsedStrategy{Rand: Rand}, nil
+	case "sticky":
+		return StickyStrategy{Fallback: RandomStrategy{Rand: Rand}}, nil
+	case "weighted":
+		return WeightedStrategy{Rand: Rand}, nil
+	default:
+		return nil, fmt.Errorf("unknown strategy '%s', use one of: %s", Name, strings.Join(StrategyNames, ", "))
+	}
+}
+
+// Check if both servers are the same relay
+func sameServer(a WireguardServer, b *WireguardServer) bool {
+	return b != nil && a.Hostname == b.Hostname && a.Pubkey == b.Pubkey
+}
+
+// Drop previous server from candidates, unless it's the only one
+func withoutPrevious(Choice Selection) []WireguardServer {
+	other := []WireguardServer{}
+
+	for _, server := range Choice.Candidates {
+		if !sameServer(server, Choice.Previous) {
+			other = append(other, server)
+		}
+	}
+
+	if len(other) == 0 {
+		return Choice.Candidates
+	}
+
+	return other
+}
+
+// Pick random server from the list
+func pickRandom(Rand *rand.Rand, Servers []WireguardServer) (WireguardServer, error) {
+	if len(Servers) == 0 {
+		return WireguardServer{}, errors.New("no upstream servers available")
+	}
+
+	return Servers[Rand.Intn(len(Servers))], nil
+}
+
+// Random server, preferably in another city than the previous one
+type RandomStrategy struct {
+	Rand *rand.Rand
+}
+
+func (s RandomStrategy) Select(Choice Selection) (WireguardServer, error) {
+	other := withoutPrevious(Choice)
+
+	if Choice.Previous != nil {
+		other_cities := []WireguardServer{}
+
+		for _, server := range other {
+			if server.City != Choice.Previous.City {
+				other_cities = append(other_cities, server)
+			}
+		}
+
+		if len(other_cities) > 0 {
+			other = other_cities
+		}
+	}
+
+	return pickRandom(s.Rand, other)
+}
+
+// Cycles through cities in alphabetical order, picking random server in each
+type RoundRobinStrategy struct {
+	Rand *rand.Rand
+}
+
+func (s RoundRobinStrategy) Select(Choice Selection) (WireguardServer, error) {
+	other := withoutPrevious(Choice)
+	by_city := make(map[string][]WireguardServer)
+	cities := []string{}
+
+	for _, server := range other {
+		if _, ok := by_city[server.City]; !ok {
+			cities = append(cities, server.City)
+		}
+
+		by_city[server.City] = append(by_city[server.City], server)
+	}
+
+	if len(cities) == 0 {
+		return WireguardServer{}, errors.New("no upstream servers available")
+	}
+
+	sort.Strings(cities)
+
+	// First city after the previous one, wrapping around
+	next := cities[0]
+
+	if Choice.Previous != nil {
+		for _, city := range cities {
+			if city > Choice.Previous.City {
+				next = city
+				break
+			}
+		}
+	}
+
+	return pickRandom(s.Rand, by_city[next])
+}
+
+// Server which was not selected for the longest time; ties are broken randomly
+type LeastUsedStrategy struct {
+	Rand *rand.Rand
+}
+
+func (s LeastUsedStrategy) Select(Choice Selection) (WireguardServer, error) {
+	oldest := []WireguardServer{}
+	var used int64
+
+	for _, server := range withoutPrevious(Choice) {
+		last := Choice.LastUsed[server.Hostname]
+
+		if len(oldest) == 0 || last < used {
+			oldest = []WireguardServer{server}
+			used = last
+		} else if last == used {
+			oldest = append(oldest, server)
+		}
+	}
+
+	return pickRandom(s.Rand, oldest)
+}
+
+// Keeps previous server while it works, uses fallback strategy otherwise
+type StickyStrategy struct {
+	Fallback SelectionStrategy
+}
+
+func (s StickyStrategy) Select(Choice Selection) (WireguardServer, error) {
+	if !Choice.Failed {
+		for _, server := range Choice.Candidates {
+			if sameServer(server, Choice.Previous) {
+				return server, nil
+			}
+		}
+	}
+
+	return s.Fallback.Select(Choice)
+}
+
+// Random server, with chances proportional to server weight as set by provider;
+// servers without weight count as weight 1
+type WeightedStrategy struct {
+	Rand *rand.Rand
+}
+
+func (s WeightedStrategy) Select(Choice Selection) (WireguardServer, error) {
+	other := withoutPrevious(Choice)
+	total := 0
+
+	for _, server := range other {
+		total += max(server.Weight, 1)
+	}
+
+	if total == 0 {
+		return WireguardServer{}, errors.New("no upstream servers available")
+	}
+
+	point := s.Rand.Intn(total)
+
+	for _, server := range other {
+		point -= max(server.Weight, 1)
+
+		if point < 0 {
+			return server, nil
+		}
+	}
+
+	return other[len(other)-1], nil
+}
+
+// Server selection parameters
+type SelectionParams struct {
+	// Location to pick from; all servers are used if it has none
+	Location string
+
+	// Server which was used before and whether it has failed
+	Previous *WireguardServer
+	Failed   bool
+
+	// Only consider this much fastest servers, if set
+	BestN int
+
+	// Locations which are never used
+	Excluded []string
+
+	// Servers which don't pass the filter are never used
+	Filter ServerFilter
+}
+
+// Select server for a particular location using given strategy. If BestN is
+// set and latency is known, only BestN fastest servers are considered, along
+// with previous one, so sticky strategy can keep it. Selected server is
+// recorded, so least used servers can be found later
+func SelectServer(Servers *ServersState, Strategy SelectionStrategy, Params SelectionParams) (WireguardServer, error) {
+	if Servers == nil {
+		return WireguardServer{}, errors.New("servers state is nil")
+	}
+
+	if len(Servers.Available) == 0 || len(Servers.Locations) == 0 {
+		return WireguardServer{}, errors.New("no upstream servers available")
+	}
+
+	choice := Selection{
+		Candidates: filterServers(withoutExcluded(locationServers(Servers, Params.Location), Params.Excluded), Params.Filter),
+		Previous:   Params.Previous,
+		Failed:     Params.Failed,
+		LastUsed:   Servers.LastUsed,
+	}
+
+	if len(choice.Candidates) == 0 {
+		return WireguardServer{}, fmt.Errorf("all servers in '%s' are excluded or filtered out", Params.Location)
+	}
+
+	if Params.BestN > 0 {
+		fastest := []WireguardServer{}
+		var previous *WireguardServer
+
+		for _, server := range filterServers(withoutExcluded(FastestServers(Servers, Params.Location), Params.Excluded), Params.Filter) {
+			if sameServer(server, Params.Previous) {
+				// copy, since loop variable is reused
+				p := server
+				previous = &p
+			} else if len(fastest) < Params.BestN {
+				fastest = append(fastest, server)
+			}
+		}
+
+		if previous != nil {
+			fastest = append(fastest, *previous)
+		}
+
+		if len(fastest) > 0 {
+			choice.Candidates = fastest
+		}
+	}
+
+	server, err := Strategy.Select(choice)
+
+	if err != nil {
+		return WireguardServer{}, err
+	}
+
+	if Servers.LastUsed == nil {
+		Servers.LastUsed = make(map[string]int64)
+	}
+
+	Servers.LastUsed[server.Hostname] = time.Now().Unix()
+
+	return server, nil
+}
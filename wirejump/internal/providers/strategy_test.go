@@ -0,0 +1,101 @@
+package providers
+
+import (
+	"math/rand"
+	"testing"
+)
+
+// Five Swedish servers in three cities, one of them with higher weight
+func strategyServers() []WireguardServer {
+	return []WireguardServer{
+		{Hostname: "se-got-wg-001", City: "Gothenburg"},
+		{Hostname: "se-got-wg-002", City: "Gothenburg"},
+		{Hostname: "se-mma-wg-001", City: "Malmo"},
+		{Hostname: "se-sto-wg-001", City: "Stockholm", Weight: 10},
+		{Hostname: "se-sto-wg-002", City: "Stockholm"},
+	}
+}
+
+// Select servers in a row, starting from the first candidate, and record
+// their usage the same way SelectServer does
+func rotate(t *testing.T, Strategy SelectionStrategy, Count int) []string {
+	candidates := strategyServers()
+	previous := candidates[0]
+	last_used := map[string]int64{"se-got-wg-001": 1, "se-got-wg-002": 3, "se-mma-wg-001": 3, "se-sto-wg-001": 2, "se-sto-wg-002": 3}
+	picked := []string{}
+
+	for i := 0; i < Count; i++ {
+		server, err := Strategy.Select(Selection{Candidates: candidates, Previous: &previous, LastUsed: last_used})
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		picked = append(picked, server.Hostname)
+		last_used[server.Hostname] = int64(10 + i)
+		previous = server
+	}
+
+	return picked
+}
+
+func TestStrategyPicks(t *testing.T) {
+	tests := []struct {
+		name   string
+		picked []string
+	}{
+		{"random", []string{"se-sto-wg-002", "se-got-wg-001", "se-sto-wg-002", "se-mma-wg-001", "se-got-wg-002", "se-mma-wg-001"}},
+		{"round-robin", []string{"se-mma-wg-001", "se-sto-wg-002", "se-got-wg-002", "se-mma-wg-001", "se-sto-wg-002", "se-got-wg-001"}},
+		{"least-used", []string{"se-sto-wg-001", "se-got-wg-001", "se-sto-wg-002", "se-mma-wg-001", "se-got-wg-002", "se-sto-wg-001"}},
+		{"sticky", []string{"se-got-wg-001", "se-got-wg-001", "se-got-wg-001", "se-got-wg-001", "se-got-wg-001", "se-got-wg-001"}},
+		{"weighted", []string{"se-sto-wg-001", "se-sto-wg-002", "se-sto-wg-001", "se-sto-wg-002", "se-sto-wg-001", "se-mma-wg-001"}},
+	}
+
+	for _, test := range tests {
+		strategy, err := NewStrategy(test.name, rand.New(rand.NewSource(1)))
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if picked := rotate(t, strategy, 6); !equalNames(picked, test.picked) {
+			t.Errorf("%s: got %#v, want %#v", test.name, picked, test.picked)
+		}
+	}
+}
+
+func TestStickyStrategyFailed(t *testing.T) {
+	candidates := strategyServers()
+	strategy, _ := NewStrategy("sticky", rand.New(rand.NewSource(1)))
+
+	server, err := strategy.Select(Selection{Candidates: candidates, Previous: &candidates[0], Failed: true})
+
+	if err != nil || server.Hostname != "se-sto-wg-002" {
+		t.Errorf("got %s, %v, want se-sto-wg-002", server.Hostname, err)
+	}
+
+	// Previous server is kept if it's the only one left, even when failed
+	server, err = strategy.Select(Selection{Candidates: candidates[:1], Previous: &candidates[0], Failed: true})
+
+	if err != nil || server.Hostname != "se-got-wg-001" {
+		t.Errorf("got %s, %v, want se-got-wg-001", server.Hostname, err)
+	}
+}
+
+func TestStrategyErrors(t *testing.T) {
+	if _, err := NewStrategy("fastest", nil); err == nil {
+		t.Errorf("unknown strategy has been created")
+	}
+
+	for _, name := range StrategyNames {
+		strategy, err := NewStrategy(name, rand.New(rand.NewSource(1)))
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if _, err := strategy.Select(Selection{}); err == nil {
+			t.Errorf("%s: server selected without candidates", name)
+		}
+	}
+}
@@ -2,12 +2,11 @@ package providers
 
 import (
 	"errors"
+	"fmt"
 	"math/rand"
+	"net"
 )
 
-// How many times to try to guess new upstream server
-const maxConnectionGuesses = 33
-
 type randomItem interface {
 	WireguardServer | string | int
 }
@@ -21,7 +20,7 @@ func GetRandomElement[T randomItem](elements []T) *T {
 	}
 
 	if total == 1 {
-		return &elements[1]
+		return &elements[0]
 	}
 
 	index := rand.Intn(total)
@@ -29,58 +28,62 @@ func GetRandomElement[T randomItem](elements []T) *T {
 	return &elements[index]
 }
 
-// Given a list of servers, select one of them randomly for a particular location
-func GuessNewUpstream(Servers *ServersState, Previous *WireguardServer, Location string) (WireguardServer, error) {
+// Get server endpoint address. IPv4 is used unless IPv6 is preferred
+// and available; server with IPv6 address only will always use it
+func (s WireguardServer) Endpoint(PreferIPv6 bool) string {
+	host := s.IPv4
+
+	if s.IPv6 != "" && (PreferIPv6 || s.IPv4 == "") {
+		host = s.IPv6
+	}
+
+	return net.JoinHostPort(host, fmt.Sprint(s.Port))
+}
+
+// Select standby server for a particular location: it should be a different
+// relay than the primary one, preferably in a different city, so both are
+// not affected by the same outage. Excluded locations and servers which
+// don't pass the filter are never used
+func GuessStandbyUpstream(Servers *ServersState, Primary *WireguardServer, Location string, Excluded []string, Filter ServerFilter) (WireguardServer, error) {
 	if Servers == nil {
 		return WireguardServer{}, errors.New("servers state is nil")
 	}
 
-	if len(Servers.Available) == 0 || len(Servers.Locations) == 0 {
-		return WireguardServer{}, errors.New("no upstream servers available")
+	if Primary == nil {
+		Primary = &WireguardServer{}
 	}
 
-	var available []WireguardServer
-	var all_servers []WireguardServer
+	var other_relays []WireguardServer
+	var other_cities []WireguardServer
 
-	// Filter available servers by location
-	for location, servers := range Servers.Available {
-		if location == Location {
-			available = append(available, servers...)
+	for _, server := range Servers.MatchAllowed(Location, Excluded, Filter) {
+		if sameServer(server, Primary) {
+			continue
 		}
-		all_servers = append(all_servers, servers...)
-	}
 
-	// If there was no matches, use any servers
-	if len(available) == 0 {
-		available = all_servers
-	}
+		other_relays = append(other_relays, server)
 
-	// If there's only 1 server available, just return it
-	if len(available) == 1 {
-		return available[0], nil
+		if server.City != Primary.City {
+			other_cities = append(other_cities, server)
+		}
 	}
 
-	// If there was no previous server (first run), compare against empty one
-	if Previous == nil {
-		Previous = &WireguardServer{}
+	if candidate := GetRandomElement(other_cities); candidate != nil {
+		return *candidate, nil
 	}
 
-	// So apparently there are some servers available,
-	// shuffle them until new one is selected (up to maxConnectionGuesses times)
-	totalGuesses := 0
-
-	for {
-		if totalGuesses > maxConnectionGuesses {
-			return WireguardServer{}, errors.New("could not guess new upstream after many tries")
-		}
-
-		candidate := GetRandomElement(available)
+	if candidate := GetRandomElement(other_relays); candidate != nil {
+		return *candidate, nil
+	}
 
-		// Force new city
-		if candidate.City != Previous.City {
-			return *candidate, nil
-		}
+	return WireguardServer{}, fmt.Errorf("no standby servers available in '%s' apart from the main one", Location)
+}
 
-		totalGuesses++
+// Get provider session, if provider keeps any
+func GetSession(Provider UpstreamAPI) *ProviderSession {
+	if sessions, ok := Provider.(SessionAPI); ok {
+		return sessions.Session()
 	}
+
+	return nil
 }
@@ -0,0 +1,298 @@
+package providers
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"wirejump/internal/network"
+	"wirejump/internal/utils"
+)
+
+// This provider is meant for VPN providers without any API. Most of them
+// allow to export plain wg-quick configs, one per server, which contain
+// everything needed for a connection: private key, interface address,
+// DNS server (used as upstream gateway) and a single peer.
+//
+// Configs are read from a directory, which is specified as account ID during
+// setup. Location tag is taken from config file name: everything before the
+// first dash is a location, so 'se-sto-001.conf' and 'se-got-002.conf' both
+// belong to 'se'. Files without a dash form a location by themselves.
+//
+// Keys and addresses are dictated by configs, so key management is a no-op
+// and upstream interface is using private key from the selected config.
+
+// Provider name
+const wgconfProviderName = "wgconf"
+
+// Config files extension
+const wgconfExtension = ".conf"
+
+// Default configs location, used if directory is not specified
+var wgconfDefaultDirectory = path.Join(network.BasePath, "config", "wgconf")
+
+// Single imported config
+type wgconfServer struct {
+	Server     WireguardServer
+	PrivateKey string
+	PublicKey  string
+	Address    string
+	Address6   string
+	Gateway    string
+}
+
+// Generic WireGuard config import provider
+type WgconfProvider struct {
+	WireguardProvider
+	Directory string
+	servers   []wgconfServer
+}
+
+// Make sure provider is complete
+var _ UpstreamAPI = (*WgconfProvider)(nil)
+var _ StaticKeysAPI = (*WgconfProvider)(nil)
+
+func init() {
+	RegisterProvider(wgconfProviderName, WgconfInit)
+}
+
+// Get first address of a given family from comma separated list, dropping the mask if asked
+func firstAddress(value string, ipv6 bool, dropMask bool) string {
+	for _, item := range strings.Split(value, ",") {
+		item = strings.TrimSpace(item)
+		addr := strings.Split(item, "/")[0]
+
+		if ip := net.ParseIP(addr); ip != nil && (ip.To4() == nil) == ipv6 {
+			if dropMask {
+				return addr
+			}
+
+			return item
+		}
+	}
+
+	return ""
+}
+
+// Parse single wg-quick config into a server
+func parseWgconf(filename string) (wgconfServer, error) {
+	config, err := utils.ReadINI(filename)
+
+	if err != nil {
+		return wgconfServer{}, err
+	}
+
+	if len(config["Interface"]) != 1 || len(config["Peer"]) != 1 {
+		return wgconfServer{}, errors.New("config must have exactly one interface and one peer")
+	}
+
+	iface := config["Interface"][0]
+	peer := config["Peer"][0]
+
+	if !network.IsValidKey(iface["PrivateKey"]) || !network.IsValidKey(peer["PublicKey"]) {
+		return wgconfServer{}, errors.New("config has no valid keys")
+	}
+
+	host, port, err := net.SplitHostPort(peer["Endpoint"])
+
+	if err != nil {
+		return wgconfServer{}, fmt.Errorf("invalid endpoint: %s", err)
+	}
+
+	portNumber, err := strconv.Atoi(port)
+
+	if err != nil {
+		return wgconfServer{}, fmt.Errorf("invalid endpoint port '%s'", port)
+	}
+
+	pubkey, err := network.GeneratePublicKey(iface["PrivateKey"])
+
+	if err != nil {
+		return wgconfServer{}, fmt.Errorf("invalid private key: %s", err)
+	}
+
+	name := strings.TrimSuffix(path.Base(filename), wgconfExtension)
+	parts := strings.Split(strings.ToLower(name), "-")
+	location := parts[0]
+
+	// Names like se-got-001 have a city, others are distinct servers
+	city := name
+
+	if len(parts) > 2 {
+		city = parts[1]
+	}
+
+	parsed := wgconfServer{
+		Server: WireguardServer{
+			Country:  location,
+			City:     city,
+			Hostname: name,
+			Port:     portNumber,
+			Pubkey:   peer["PublicKey"],
+		},
+		PrivateKey: iface["PrivateKey"],
+		PublicKey:  pubkey,
+		Address:    firstAddress(iface["Address"], false, false),
+		Address6:   firstAddress(iface["Address"], true, false),
+		Gateway:    firstAddress(iface["DNS"], false, true),
+	}
+
+	// Endpoint can be a hostname, which is kept as is
+	if ip := net.ParseIP(host); ip != nil && ip.To4() == nil {
+		parsed.Server.IPv6 = host
+	} else {
+		parsed.Server.IPv4 = host
+	}
+
+	if parsed.Address == "" {
+		return wgconfServer{}, errors.New("config has no IPv4 address")
+	}
+
+	return parsed, nil
+}
+
+// Read all configs from the directory. Broken configs are reported
+// together, since silently skipping them would hide servers
+func readWgconfDirectory(directory string) ([]wgconfServer, error) {
+	entries, err := os.ReadDir(directory)
+
+	if err != nil {
+		return nil, fmt.Errorf("cannot read configs directory: %s", err)
+	}
+
+	servers := []wgconfServer{}
+	broken := []string{}
+
+	for _, entry := range entries {
+		if entry.IsDir() || !strings.HasSuffix(entry.Name(), wgconfExtension) {
+			continue
+		}
+
+		server, err := parseWgconf(path.Join(directory, entry.Name()))
+
+		if err != nil {
+			broken = append(broken, fmt.Sprintf("%s: %s", entry.Name(), err))
+			continue
+		}
+
+		servers = append(servers, server)
+	}
+
+	if len(broken) > 0 {
+		sort.Strings(broken)
+
+		return nil, fmt.Errorf("invalid configs found: %s", strings.Join(broken, "; "))
+	}
+
+	if len(servers) == 0 {
+		return nil, fmt.Errorf("no configs found in '%s'", directory)
+	}
+
+	return servers, nil
+}
+
+// This will be run on setup to create config import provider.
+// Account ID is a directory with configs, password is not used
+func WgconfInit(Account WireguardProviderAccount) (UpstreamAPI, error) {
+	directory := Account.AccountID
+
+	if directory == "" {
+		directory = wgconfDefaultDirectory
+	}
+
+	servers, err := readWgconfDirectory(directory)
+
+	if err != nil {
+		return nil, err
+	}
+
+	p := WgconfProvider{
+		WireguardProvider: WireguardProvider{
+			Account:         Account,
+			Initialized:     true,
+			ProviderName:    wgconfProviderName,
+			UpstreamGateway: servers[0].Gateway,
+		},
+		Directory: directory,
+		servers:   servers,
+	}
+
+	// All configs are expected to come from the same vendor,
+	// so take the first gateway which is actually present
+	for _, server := range servers {
+		if server.Gateway != "" {
+			p.UpstreamGateway = server.Gateway
+			break
+		}
+	}
+
+	return &p, nil
+}
+
+// Get provider info
+func (w *WgconfProvider) Details() *WireguardProvider {
+	return &w.WireguardProvider
+}
+
+// There's no account to check, so configs never expire
+func (w *WgconfProvider) GetAccountInfo() (WireguardAccount, error) {
+	return WireguardAccount{Expires: 0, CanAddDevices: true}, nil
+}
+
+// Reread configs directory, so added or removed configs are picked up
+func (w *WgconfProvider) GetAllServers() ([]WireguardServer, error) {
+	servers, err := readWgconfDirectory(w.Directory)
+
+	if err != nil {
+		return []WireguardServer{}, err
+	}
+
+	w.servers = servers
+	all_servers := []WireguardServer{}
+
+	for _, server := range servers {
+		all_servers = append(all_servers, server.Server)
+	}
+
+	return all_servers, nil
+}
+
+// Keys are dictated by configs, nothing to add
+func (w *WgconfProvider) AddPubkey(key string) error {
+	return nil
+}
+
+// Keys are dictated by configs, nothing to remove
+func (w *WgconfProvider) RemovePubkey(key string) error {
+	return nil
+}
+
+// Find address of a config which has matching public key
+func (w *WgconfProvider) GetAddress(key string) (string, error) {
+	for _, server := range w.servers {
+		if server.PublicKey == key {
+			if server.Address6 != "" {
+				return fmt.Sprintf("%s, %s", server.Address, server.Address6), nil
+			}
+
+			return server.Address, nil
+		}
+	}
+
+	return "", errors.New("[GetAddress] no matching config found")
+}
+
+// Find private key of a config for the selected server
+func (w *WgconfProvider) GetPrivateKey(server WireguardServer) (string, error) {
+	for _, config := range w.servers {
+		if config.Server.Pubkey == server.Pubkey && config.Server.Hostname == server.Hostname {
+			return config.PrivateKey, nil
+		}
+	}
+
+	return "", fmt.Errorf("[GetPrivateKey] no config found for server '%s'", server.Hostname)
+}
@@ -0,0 +1,507 @@
+package qrcode
+
+import (
+	"errors"
+)
+
+// Minimal QR code (model 2) encoder. Only byte mode is supported, which
+// is enough for WireGuard configs; all 40 versions and 4 error correction
+// levels are available. Follows ISO/IEC 18004, with the same structure
+// as most of the reference implementations: encode data into codewords,
+// add Reed-Solomon error correction, place modules and pick best mask.
+
+// Error correction level
+type Level int
+
+const (
+	LevelLow Level = iota
+	LevelMedium
+	LevelQuartile
+	LevelHigh
+)
+
+// Version range
+const (
+	minVersion = 1
+	maxVersion = 40
+)
+
+// Format info bits of each level, in Level order
+var levelBits = [4]int{1, 0, 3, 2}
+
+// Error correction codewords per block, indexed by level and version
+var eccPerBlock = [4][41]int{
+	{-1, 7, 10, 15, 20, 26, 18, 20, 24, 30, 18, 20, 24, 26, 30, 22, 24, 28, 30, 28, 28, 28, 28, 30, 30, 26, 28, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30},
+	{-1, 10, 16, 26, 18, 24, 16, 18, 22, 22, 26, 30, 22, 22, 24, 24, 28, 28, 26, 26, 26, 26, 28, 28, 28, 28, 28, 28, 28, 28, 28, 28, 28, 28, 28, 28, 28, 28, 28, 28, 28},
+	{-1, 13, 22, 18, 26, 18, 24, 18, 22, 20, 24, 28, 26, 24, 20, 30, 24, 28, 28, 26, 30, 28, 30, 30, 30, 30, 28, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30},
+	{-1, 17, 28, 22, 16, 22, 28, 26, 26, 24, 28, 24, 28, 22, 24, 24, 30, 28, 28, 26, 28, 30, 24, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30},
+}
+
+// Number of error correction blocks, indexed by level and version
+var eccBlocks = [4][41]int{
+	{-1, 1, 1, 1, 1, 1, 2, 2, 2, 2, 4, 4, 4, 4, 4, 6, 6, 6, 6, 7, 8, 8, 9, 9, 10, 12, 12, 12, 13, 14, 15, 16, 17, 18, 19, 19, 20, 21, 22, 24, 25},
+	{-1, 1, 1, 1, 2, 2, 4, 4, 4, 5, 5, 5, 8, 9, 9, 10, 10, 11, 13, 14, 16, 17, 17, 18, 20, 21, 23, 25, 26, 28, 29, 31, 33, 35, 37, 38, 40, 43, 45, 47, 49},
+	{-1, 1, 1, 2, 2, 4, 4, 6, 6, 8, 8, 8, 10, 12, 16, 12, 17, 16, 18, 21, 20, 23, 23, 25, 27, 29, 34, 34, 35, 38, 40, 43, 45, 48, 51, 53, 56, 59, 62, 65, 68},
+	{-1, 1, 1, 2, 4, 4, 4, 5, 6, 8, 8, 11, 11, 16, 16, 18, 16, 19, 21, 25, 25, 25, 34, 30, 32, 35, 37, 40, 42, 45, 48, 51, 54, 57, 60, 63, 66, 70, 74, 77, 81},
+}
+
+// Encoded QR code
+type Code struct {
+	// Symbol version, 1 to 40
+	Version int
+
+	// Symbol size in modules, without quiet zone
+	Size int
+
+	modules    [][]bool
+	isFunction [][]bool
+}
+
+// Check if module is dark. Coordinates outside the symbol are light,
+// which makes quiet zone handling trivial
+func (c *Code) Dark(x int, y int) bool {
+	if x < 0 || y < 0 || x >= c.Size || y >= c.Size {
+		return false
+	}
+
+	return c.modules[y][x]
+}
+
+// Number of raw data modules (data and error correction) in a symbol
+func rawDataModules(version int) int {
+	result := (16*version+128)*version + 64
+
+	if version >= 2 {
+		align := version/7 + 2
+		result -= (25*align-10)*align - 55
+
+		if version >= 7 {
+			result -= 36
+		}
+	}
+
+	return result
+}
+
+// Number of data codewords in a symbol
+func dataCodewords(version int, level Level) int {
+	return rawDataModules(version)/8 - eccPerBlock[level][version]*eccBlocks[level][version]
+}
+
+// Bit buffer for data encoding
+type bitBuffer []bool
+
+func (b *bitBuffer) append(value int, length int) {
+	for i := length - 1; i >= 0; i-- {
+		*b = append(*b, (value>>i)&1 != 0)
+	}
+}
+
+// Encode data using byte mode and a given error correction level,
+// picking the smallest version which fits
+func Encode(data []byte, level Level) (*Code, error) {
+	if level < LevelLow || level > LevelHigh {
+		return nil, errors.New("invalid error correction level")
+	}
+
+	version := minVersion
+
+	for ; version <= maxVersion; version++ {
+		countBits := 8
+
+		if version >= 10 {
+			countBits = 16
+		}
+
+		if 4+countBits+len(data)*8 <= dataCodewords(version, level)*8 && len(data) < 1<<countBits {
+			break
+		}
+	}
+
+	if version > maxVersion {
+		return nil, errors.New("data is too long for a QR code")
+	}
+
+	// Mode indicator, character count and data itself
+	bits := bitBuffer{}
+	bits.append(0x4, 4)
+
+	if version >= 10 {
+		bits.append(len(data), 16)
+	} else {
+		bits.append(len(data), 8)
+	}
+
+	for _, b := range data {
+		bits.append(int(b), 8)
+	}
+
+	// Terminator, byte alignment and padding
+	capacity := dataCodewords(version, level) * 8
+	terminator := capacity - len(bits)
+
+	if terminator > 4 {
+		terminator = 4
+	}
+
+	bits.append(0, terminator)
+	bits.append(0, (8-len(bits)%8)%8)
+
+	for pad := 0xEC; len(bits) < capacity; pad ^= 0xEC ^ 0x11 {
+		bits.append(pad, 8)
+	}
+
+	codewords := make([]byte, len(bits)/8)
+
+	for i, bit := range bits {
+		if bit {
+			codewords[i>>3] |= 1 << (7 - i&7)
+		}
+	}
+
+	code := newCode(version)
+	code.drawFunctionPatterns(level)
+	code.drawCodewords(addErrorCorrection(codewords, version, level))
+
+	// Pick the mask with the lowest penalty
+	best := 0
+	bestPenalty := -1
+
+	for mask := 0; mask < 8; mask++ {
+		code.applyMask(mask)
+		code.drawFormatBits(level, mask)
+
+		if penalty := code.penalty(); bestPenalty < 0 || penalty < bestPenalty {
+			best = mask
+			bestPenalty = penalty
+		}
+
+		// Masking is reversible
+		code.applyMask(mask)
+	}
+
+	code.applyMask(best)
+	code.drawFormatBits(level, best)
+
+	return code, nil
+}
+
+// Create empty symbol
+func newCode(version int) *Code {
+	size := version*4 + 17
+	code := &Code{Version: version, Size: size}
+
+	code.modules = make([][]bool, size)
+	code.isFunction = make([][]bool, size)
+
+	for i := range code.modules {
+		code.modules[i] = make([]bool, size)
+		code.isFunction[i] = make([]bool, size)
+	}
+
+	return code
+}
+
+// Set function module, which is never masked
+func (c *Code) setFunction(x int, y int, dark bool) {
+	c.modules[y][x] = dark
+	c.isFunction[y][x] = true
+}
+
+// Alignment pattern center coordinates
+func (c *Code) alignmentPositions() []int {
+	if c.Version == 1 {
+		return []int{}
+	}
+
+	count := c.Version/7 + 2
+	step := (c.Version*4 + count*2 + 1) / (count*2 - 2) * 2
+
+	if c.Version == 32 {
+		step = 26
+	}
+
+	result := make([]int, count)
+	result[0] = 6
+
+	for i, pos := count-1, c.Size-7; i >= 1; i, pos = i-1, pos-step {
+		result[i] = pos
+	}
+
+	return result
+}
+
+// Draw finders, timing and alignment patterns, and reserve format and version areas
+func (c *Code) drawFunctionPatterns(level Level) {
+	// Timing patterns
+	for i := 0; i < c.Size; i++ {
+		c.setFunction(6, i, i%2 == 0)
+		c.setFunction(i, 6, i%2 == 0)
+	}
+
+	// Finder patterns with separators
+	c.drawFinder(3, 3)
+	c.drawFinder(c.Size-4, 3)
+	c.drawFinder(3, c.Size-4)
+
+	// Alignment patterns, except the ones overlapping finders
+	positions := c.alignmentPositions()
+	last := len(positions) - 1
+
+	for i, x := range positions {
+		for j, y := range positions {
+			if (i == 0 && j == 0) || (i == 0 && j == last) || (i == last && j == 0) {
+				continue
+			}
+
+			for dy := -2; dy <= 2; dy++ {
+				for dx := -2; dx <= 2; dx++ {
+					c.setFunction(x+dx, y+dy, max(abs(dx), abs(dy)) != 1)
+				}
+			}
+		}
+	}
+
+	// Reserve format area, actual bits are drawn after masking
+	c.drawFormatBits(level, 0)
+	c.drawVersionBits()
+}
+
+// Draw finder pattern centered at a given point, along with its separator
+func (c *Code) drawFinder(x int, y int) {
+	for dy := -4; dy <= 4; dy++ {
+		for dx := -4; dx <= 4; dx++ {
+			xx, yy := x+dx, y+dy
+
+			if xx < 0 || yy < 0 || xx >= c.Size || yy >= c.Size {
+				continue
+			}
+
+			distance := max(abs(dx), abs(dy))
+			c.setFunction(xx, yy, distance != 2 && distance != 4)
+		}
+	}
+}
+
+// Draw both copies of format bits
+func (c *Code) drawFormatBits(level Level, mask int) {
+	data := levelBits[level]<<3 | mask
+	rem := data
+
+	for i := 0; i < 10; i++ {
+		rem = (rem << 1) ^ ((rem >> 9) * 0x537)
+	}
+
+	bits := (data<<10 | rem) ^ 0x5412
+	bit := func(i int) bool { return (bits>>i)&1 != 0 }
+
+	// Around top left finder
+	for i := 0; i <= 5; i++ {
+		c.setFunction(8, i, bit(i))
+	}
+
+	c.setFunction(8, 7, bit(6))
+	c.setFunction(8, 8, bit(7))
+	c.setFunction(7, 8, bit(8))
+
+	for i := 9; i < 15; i++ {
+		c.setFunction(14-i, 8, bit(i))
+	}
+
+	// Next to top right and bottom left finders
+	for i := 0; i < 8; i++ {
+		c.setFunction(c.Size-1-i, 8, bit(i))
+	}
+
+	for i := 8; i < 15; i++ {
+		c.setFunction(8, c.Size-15+i, bit(i))
+	}
+
+	// Always dark
+	c.setFunction(8, c.Size-8, true)
+}
+
+// Draw both copies of version bits, only present in versions 7 and up
+func (c *Code) drawVersionBits() {
+	if c.Version < 7 {
+		return
+	}
+
+	rem := c.Version
+
+	for i := 0; i < 12; i++ {
+		rem = (rem << 1) ^ ((rem >> 11) * 0x1F25)
+	}
+
+	bits := c.Version<<12 | rem
+
+	for i := 0; i < 18; i++ {
+		dark := (bits>>i)&1 != 0
+		a := c.Size - 11 + i%3
+		b := i / 3
+
+		c.setFunction(a, b, dark)
+		c.setFunction(b, a, dark)
+	}
+}
+
+// Place codewords in zigzag order, skipping function modules
+func (c *Code) drawCodewords(data []byte) {
+	i := 0
+
+	for right := c.Size - 1; right >= 1; right -= 2 {
+		// Vertical timing pattern is skipped entirely
+		if right == 6 {
+			right = 5
+		}
+
+		for vert := 0; vert < c.Size; vert++ {
+			for j := 0; j < 2; j++ {
+				x := right - j
+				y := vert
+
+				if (right+1)&2 == 0 {
+					y = c.Size - 1 - vert
+				}
+
+				if !c.isFunction[y][x] && i < len(data)*8 {
+					c.modules[y][x] = (data[i>>3]>>(7-i&7))&1 != 0
+					i++
+				}
+			}
+		}
+	}
+}
+
+// Toggle data modules using a given mask pattern
+func (c *Code) applyMask(mask int) {
+	for y := 0; y < c.Size; y++ {
+		for x := 0; x < c.Size; x++ {
+			var invert bool
+
+			switch mask {
+			case 0:
+				invert = (x+y)%2 == 0
+			case 1:
+				invert = y%2 == 0
+			case 2:
+				invert = x%3 == 0
+			case 3:
+				invert = (x+y)%3 == 0
+			case 4:
+				invert = (x/3+y/2)%2 == 0
+			case 5:
+				invert = x*y%2+x*y%3 == 0
+			case 6:
+				invert = (x*y%2+x*y%3)%2 == 0
+			case 7:
+				invert = ((x+y)%2+x*y%3)%2 == 0
+			}
+
+			if invert && !c.isFunction[y][x] {
+				c.modules[y][x] = !c.modules[y][x]
+			}
+		}
+	}
+}
+
+// Penalty score of current symbol, lower is better
+func (c *Code) penalty() int {
+	result := 0
+	dark := 0
+
+	// Runs of same color and finder-like patterns in rows and columns
+	for y := 0; y < c.Size; y++ {
+		row := make([]bool, c.Size)
+		column := make([]bool, c.Size)
+
+		for x := 0; x < c.Size; x++ {
+			row[x] = c.modules[y][x]
+			column[x] = c.modules[x][y]
+		}
+
+		result += linePenalty(row) + linePenalty(column)
+	}
+
+	// 2x2 blocks of same color
+	for y := 0; y < c.Size-1; y++ {
+		for x := 0; x < c.Size-1; x++ {
+			color := c.modules[y][x]
+
+			if color == c.modules[y][x+1] && color == c.modules[y+1][x] && color == c.modules[y+1][x+1] {
+				result += 3
+			}
+		}
+	}
+
+	// Dark and light modules balance
+	for y := 0; y < c.Size; y++ {
+		for x := 0; x < c.Size; x++ {
+			if c.modules[y][x] {
+				dark++
+			}
+		}
+	}
+
+	total := c.Size * c.Size
+
+	if k := (abs(dark*20-total*10)+total-1)/total - 1; k > 0 {
+		result += k * 10
+	}
+
+	return result
+}
+
+// Finder-like pattern: 1:1:3:1:1 with four light modules on one side
+var finderLike = [][]bool{
+	{true, false, true, true, true, false, true, false, false, false, false},
+	{false, false, false, false, true, false, true, true, true, false, true},
+}
+
+// Penalty of a single row or column
+func linePenalty(line []bool) int {
+	result := 0
+	run := 1
+
+	for i := 1; i <= len(line); i++ {
+		if i < len(line) && line[i] == line[i-1] {
+			run++
+
+			continue
+		}
+
+		if run >= 5 {
+			result += run - 2
+		}
+
+		run = 1
+	}
+
+	for i := 0; i+len(finderLike[0]) <= len(line); i++ {
+		for _, pattern := range finderLike {
+			matches := true
+
+			for j, module := range pattern {
+				if line[i+j] != module {
+					matches = false
+
+					break
+				}
+			}
+
+			if matches {
+				result += 40
+			}
+		}
+	}
+
+	return result
+}
+
+func abs(value int) int {
+	if value < 0 {
+		return -value
+	}
+
+	return value
+}
@@ -0,0 +1,195 @@
+package qrcode
+
+import (
+	"bytes"
+	"flag"
+	"image/png"
+	"os"
+	"path"
+	"strings"
+	"testing"
+)
+
+// Rewrite golden files with current encoder output
+var update = flag.Bool("update", false, "rewrite golden files")
+
+// WireGuard config similar to what 'peer config' shows
+const testConfig = `[Interface]
+PrivateKey = dwdtCnMYpX08FsFyUbJmRd9ML4frwJkqsXf7pR25LCo=
+Address = 172.16.1.2/32
+
+[Peer]
+PublicKey = hSDwCYkwp1R0i33ctD73Wg2/Og0mOBr066SpjqqbTmo=
+Endpoint = 192.0.2.1:51820
+AllowedIPs = 0.0.0.0/0
+`
+
+// Golden symbols match rsc.io/qr output for the same version and mask
+var goldenTests = []struct {
+	name    string
+	data    string
+	level   Level
+	version int
+}{
+	{"empty-low", "", LevelLow, 1},
+	{"short-high", "wirejump", LevelHigh, 2},
+	{"url-medium", "https://github.com/wirejump/wirejump", LevelMedium, 3},
+	{"url-quartile", "https://github.com/wirejump/wirejump", LevelQuartile, 4},
+	{"config-low", testConfig, LevelLow, 9},
+	{"config-medium", testConfig, LevelMedium, 10},
+	{"config-high", testConfig, LevelHigh, 15},
+}
+
+// Text form of the symbol, one row per line
+func modules(code *Code) string {
+	var builder strings.Builder
+
+	for y := 0; y < code.Size; y++ {
+		for x := 0; x < code.Size; x++ {
+			if code.Dark(x, y) {
+				builder.WriteString("#")
+			} else {
+				builder.WriteString(".")
+			}
+		}
+
+		builder.WriteString("\n")
+	}
+
+	return builder.String()
+}
+
+func TestEncodeGolden(t *testing.T) {
+	for _, test := range goldenTests {
+		code, err := Encode([]byte(test.data), test.level)
+
+		if err != nil {
+			t.Fatalf("%s: %s", test.name, err)
+		}
+
+		if code.Version != test.version || code.Size != test.version*4+17 {
+			t.Errorf("%s: got version %d, size %d, want version %d", test.name, code.Version, code.Size, test.version)
+		}
+
+		golden := path.Join("testdata", test.name+".txt")
+
+		if *update {
+			if err := os.WriteFile(golden, []byte(modules(code)), 0644); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		want, err := os.ReadFile(golden)
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if got := modules(code); got != string(want) {
+			t.Errorf("%s: symbol differs from %s:\n%s", test.name, golden, got)
+		}
+	}
+}
+
+func TestEncodeCapacity(t *testing.T) {
+	// Byte mode capacity of the largest symbols
+	tests := []struct {
+		level    Level
+		capacity int
+	}{
+		{LevelLow, 2953},
+		{LevelMedium, 2331},
+		{LevelQuartile, 1663},
+		{LevelHigh, 1273},
+	}
+
+	for _, test := range tests {
+		code, err := Encode(bytes.Repeat([]byte{'a'}, test.capacity), test.level)
+
+		if err != nil || code.Version != maxVersion {
+			t.Errorf("level %d: %d bytes don't fit into version 40: %v", test.level, test.capacity, err)
+		}
+
+		if _, err := Encode(bytes.Repeat([]byte{'a'}, test.capacity+1), test.level); err == nil {
+			t.Errorf("level %d: %d bytes are encoded", test.level, test.capacity+1)
+		}
+	}
+
+	if _, err := Encode([]byte("wirejump"), LevelHigh+1); err == nil {
+		t.Errorf("invalid level is accepted")
+	}
+}
+
+func TestReedSolomon(t *testing.T) {
+	// Version 1-M "HELLO WORLD" example from thonky.com QR code tutorial
+	data := []byte{32, 91, 11, 120, 209, 114, 220, 77, 67, 64, 236, 17, 236, 17, 236, 17}
+	want := []byte{196, 35, 39, 119, 235, 215, 231, 226, 93, 23}
+
+	if got := rsRemainder(data, rsDivisor(len(want))); !bytes.Equal(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+}
+
+func TestPNG(t *testing.T) {
+	code, err := Encode([]byte(testConfig), LevelMedium)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, scale := range []int{1, 4} {
+		var buffer bytes.Buffer
+
+		if err := code.PNG(&buffer, scale); err != nil {
+			t.Fatal(err)
+		}
+
+		img, err := png.Decode(&buffer)
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		size := (code.Size + imageQuietZone*2) * scale
+
+		if bounds := img.Bounds(); bounds.Dx() != size || bounds.Dy() != size {
+			t.Fatalf("scale %d: image is %v, want %dx%d", scale, bounds, size, size)
+		}
+
+		for y := 0; y < size; y++ {
+			for x := 0; x < size; x++ {
+				r, _, _, _ := img.At(x, y).RGBA()
+
+				if dark := code.Dark(x/scale-imageQuietZone, y/scale-imageQuietZone); dark != (r == 0) {
+					t.Fatalf("scale %d: pixel %d,%d is wrong", scale, x, y)
+				}
+			}
+		}
+	}
+}
+
+func TestTerminal(t *testing.T) {
+	code, err := Encode([]byte("wirejump"), LevelHigh)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	lines := strings.Split(strings.TrimSuffix(code.Terminal(), "\n"), "\n")
+	width := code.Size + terminalQuietZone*2
+
+	if len(lines) != (width+1)/2 {
+		t.Fatalf("got %d lines, want %d", len(lines), (width+1)/2)
+	}
+
+	for i, line := range lines {
+		if length := len([]rune(line)); length != width {
+			t.Errorf("line %d is %d characters long, want %d", i, length, width)
+		}
+	}
+
+	// Quiet zone is light, so it's drawn with full blocks
+	if !strings.HasPrefix(lines[0], strings.Repeat("█", width)) {
+		t.Errorf("quiet zone is missing: %s", lines[0])
+	}
+}
@@ -0,0 +1,97 @@
+package qrcode
+
+// Multiply two elements of GF(2^8) modulo x^8 + x^4 + x^3 + x^2 + 1
+func gfMultiply(x byte, y byte) byte {
+	z := 0
+
+	for i := 7; i >= 0; i-- {
+		z = (z << 1) ^ ((z >> 7) * 0x11D)
+		z ^= int((y>>i)&1) * int(x)
+	}
+
+	return byte(z)
+}
+
+// Generator polynomial of a given degree, highest coefficient is omitted
+func rsDivisor(degree int) []byte {
+	result := make([]byte, degree)
+	result[degree-1] = 1
+	root := byte(1)
+
+	for i := 0; i < degree; i++ {
+		for j := range result {
+			result[j] = gfMultiply(result[j], root)
+
+			if j+1 < len(result) {
+				result[j] ^= result[j+1]
+			}
+		}
+
+		root = gfMultiply(root, 0x02)
+	}
+
+	return result
+}
+
+// Error correction codewords for a given data block
+func rsRemainder(data []byte, divisor []byte) []byte {
+	result := make([]byte, len(divisor))
+
+	for _, b := range data {
+		factor := b ^ result[0]
+		copy(result, result[1:])
+		result[len(result)-1] = 0
+
+		for i, coefficient := range divisor {
+			result[i] ^= gfMultiply(coefficient, factor)
+		}
+	}
+
+	return result
+}
+
+// Split data into blocks, add error correction to each of them
+// and interleave all blocks into final sequence of codewords
+func addErrorCorrection(data []byte, version int, level Level) []byte {
+	blocks := eccBlocks[level][version]
+	eccLength := eccPerBlock[level][version]
+	raw := rawDataModules(version) / 8
+	shortBlocks := blocks - raw%blocks
+	shortLength := raw / blocks
+	divisor := rsDivisor(eccLength)
+
+	all := [][]byte{}
+	offset := 0
+
+	for i := 0; i < blocks; i++ {
+		length := shortLength - eccLength
+
+		if i >= shortBlocks {
+			length++
+		}
+
+		block := append([]byte{}, data[offset:offset+length]...)
+		offset += length
+		ecc := rsRemainder(block, divisor)
+
+		// Short blocks are padded, so all blocks can be walked together
+		if i < shortBlocks {
+			block = append(block, 0)
+		}
+
+		all = append(all, append(block, ecc...))
+	}
+
+	result := []byte{}
+
+	for i := range all[0] {
+		for j, block := range all {
+			// Skip padding of short blocks
+			if i != shortLength-eccLength || j >= shortBlocks {
+				result = append(result, block[i])
+			}
+		}
+	}
+
+	return result
+}
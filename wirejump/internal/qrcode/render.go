@@ -0,0 +1,69 @@
+package qrcode
+
+import (
+	"image"
+	"image/color"
+	"image/png"
+	"io"
+	"strings"
+)
+
+// Quiet zone around the symbol, in modules. Standard asks for 4,
+// but scanners are fine with 2, and terminal space is precious
+const (
+	terminalQuietZone = 2
+	imageQuietZone    = 4
+)
+
+// Render code for a terminal using UTF-8 half blocks, two rows per line.
+// Light modules are drawn with blocks, so the code is readable on usual
+// dark terminal background; scanners handle inverted codes anyway
+func (c *Code) Terminal() string {
+	var builder strings.Builder
+
+	start := -terminalQuietZone
+	end := c.Size + terminalQuietZone
+
+	for y := start; y < end; y += 2 {
+		for x := start; x < end; x++ {
+			top := !c.Dark(x, y)
+			bottom := !c.Dark(x, y+1) && y+1 < end
+
+			switch {
+			case top && bottom:
+				builder.WriteString("█")
+			case top:
+				builder.WriteString("▀")
+			case bottom:
+				builder.WriteString("▄")
+			default:
+				builder.WriteString(" ")
+			}
+		}
+
+		builder.WriteString("\n")
+	}
+
+	return builder.String()
+}
+
+// Write code as a PNG image, each module being scale x scale pixels
+func (c *Code) PNG(w io.Writer, scale int) error {
+	if scale < 1 {
+		scale = 1
+	}
+
+	size := (c.Size + imageQuietZone*2) * scale
+	palette := color.Palette{color.White, color.Black}
+	img := image.NewPaletted(image.Rect(0, 0, size, size), palette)
+
+	for y := 0; y < size; y++ {
+		for x := 0; x < size; x++ {
+			if c.Dark(x/scale-imageQuietZone, y/scale-imageQuietZone) {
+				img.SetColorIndex(x, y, 1)
+			}
+		}
+	}
+
+	return png.Encode(w, img)
+}
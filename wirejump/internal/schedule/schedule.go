@@ -0,0 +1,146 @@
+package schedule
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Time window format, 24-hour clock
+const windowTimeFormat = "15:04"
+
+// Shortest allowed rotation interval, seconds. Each rotation
+// makes several API requests, so don't hammer the provider
+const MinInterval = 600
+
+// Upstream rotation policy
+type Policy struct {
+	// Whether rotation is enabled at all
+	Enabled bool `json:"enabled"`
+
+	// Rotate connection which is older than this, seconds
+	Interval int64 `json:"interval"`
+
+	// Optional daily time window (like 01:00-05:00) to rotate within, local time
+	Window string `json:"window"`
+
+	// Locations to pick from; if empty, location is picked same way as
+	// for a regular connect: first available preferred one, or a random one
+	Locations []string `json:"locations"`
+
+	// Whether to rotate WireGuard keys as well
+	RotateKeys bool `json:"rotate_keys"`
+}
+
+// Parse daily window into start and end offsets from midnight
+func ParseWindow(window string) (time.Duration, time.Duration, error) {
+	parts := strings.Split(window, "-")
+
+	if len(parts) != 2 {
+		return 0, 0, errors.New("window should look like HH:MM-HH:MM")
+	}
+
+	offsets := []time.Duration{}
+
+	for _, part := range parts {
+		parsed, err := time.Parse(windowTimeFormat, strings.TrimSpace(part))
+
+		if err != nil {
+			return 0, 0, fmt.Errorf("invalid window time '%s'", part)
+		}
+
+		offsets = append(offsets, time.Duration(parsed.Hour())*time.Hour+time.Duration(parsed.Minute())*time.Minute)
+	}
+
+	if offsets[0] == offsets[1] {
+		return 0, 0, errors.New("window start and end should differ")
+	}
+
+	return offsets[0], offsets[1], nil
+}
+
+// Parse comma separated list of locations
+func ParseLocations(locations string) []string {
+	parsed := []string{}
+
+	for _, location := range strings.Split(locations, ",") {
+		if trimmed := strings.TrimSpace(location); trimmed != "" {
+			parsed = append(parsed, trimmed)
+		}
+	}
+
+	return parsed
+}
+
+// Check policy for consistency
+func (p *Policy) Validate() error {
+	if p.Interval < MinInterval {
+		return fmt.Errorf("interval should be at least %d seconds", MinInterval)
+	}
+
+	if p.Window != "" {
+		if _, _, err := ParseWindow(p.Window); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Check if given time is within rotation window. Windows can span midnight
+func (p *Policy) InWindow(t time.Time) bool {
+	if p.Window == "" {
+		return true
+	}
+
+	start, end, err := ParseWindow(p.Window)
+
+	if err != nil {
+		return false
+	}
+
+	midnight := time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, t.Location())
+	offset := t.Sub(midnight)
+
+	if start < end {
+		return offset >= start && offset < end
+	}
+
+	return offset >= start || offset < end
+}
+
+// Get time of the next rotation for the connection which
+// is active since given time
+func (p *Policy) NextRotation(activeSince time.Time) time.Time {
+	due := activeSince.Add(time.Duration(p.Interval) * time.Second)
+
+	if p.InWindow(due) {
+		return due
+	}
+
+	// Move to the nearest window start
+	start, _, err := ParseWindow(p.Window)
+
+	if err != nil {
+		return due
+	}
+
+	midnight := time.Date(due.Year(), due.Month(), due.Day(), 0, 0, 0, 0, due.Location())
+	next := midnight.Add(start)
+
+	if next.Before(due) {
+		next = midnight.AddDate(0, 0, 1).Add(start)
+	}
+
+	return next
+}
+
+// Check if connection which is active since given time should be rotated now
+func (p *Policy) IsDue(activeSince time.Time, now time.Time) bool {
+	if !p.Enabled {
+		return false
+	}
+
+	return !now.Before(p.NextRotation(activeSince)) && p.InWindow(now)
+}
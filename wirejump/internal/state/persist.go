@@ -0,0 +1,217 @@
+package state
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"time"
+	"wirejump/internal/network"
+	"wirejump/internal/providers"
+	"wirejump/internal/schedule"
+)
+
+// State file format version. Bump it each time snapshot
+// structure changes in an incompatible way
+const StateVersion = 1
+
+// State file name, will be stored along with interface configs
+const StateFileName = "wirejumpd.state"
+
+// Selected provider details needed to recreate it on startup
+type ProviderSnapshot struct {
+	Name               string                             `json:"name"`
+	Account            providers.WireguardProviderAccount `json:"account"`
+	ValidUntil         int64                              `json:"valid_until"`
+	ActiveSince        *int64                             `json:"active_since"`
+	PreferredLocations []string                           `json:"preferred_locations"`
+	Excluded           []string                           `json:"excluded"`
+	Server             *providers.WireguardServer         `json:"server"`
+	BestServers        map[string]int                     `json:"best_servers"`
+	Strategy           string                             `json:"strategy"`
+	Filter             *providers.ServerFilter            `json:"filter"`
+
+	// API session of providers which keep one, see providers.SessionAPI
+	Session *providers.ProviderSession `json:"session,omitempty"`
+
+	// Single preferred location, saved by older versions
+	PreferredLocation *string `json:"preferred_location,omitempty"`
+}
+
+// On-disk representation of the application state
+type StateSnapshot struct {
+	Version  int                     `json:"version"`
+	Saved    int64                   `json:"saved"`
+	Provider *ProviderSnapshot       `json:"provider"`
+	Servers  *providers.ServersState `json:"servers"`
+	Rotation *schedule.Policy        `json:"rotation"`
+	Exits    []*ExitGroup            `json:"exits"`
+	Standby  *StandbyUpstream        `json:"standby"`
+
+	// Rotation policy is only saved if it has been changed at runtime;
+	// older versions saved it always, so it's ignored without this flag
+	RotationOverride bool `json:"rotation_override"`
+}
+
+// Get state file location
+func GetStateFilePath() string {
+	return path.Join(network.BasePath, "config", StateFileName)
+}
+
+// Create snapshot of the current state
+func (s *AppState) Snapshot() StateSnapshot {
+	snapshot := StateSnapshot{
+		Version: StateVersion,
+		Saved:   time.Now().Unix(),
+		Servers: s.Servers,
+		Exits:   s.Exits,
+		Standby: s.Standby,
+	}
+
+	if s.RotationOverride {
+		snapshot.Rotation = s.Rotation
+		snapshot.RotationOverride = true
+	}
+
+	if s.UpstreamProvider != nil && s.UpstreamProvider.Provider != nil {
+		details := s.UpstreamProvider.Provider.Details()
+		snapshot.Provider = &ProviderSnapshot{
+			Name:               details.ProviderName,
+			Account:            details.Account,
+			ValidUntil:         details.ValidUntil,
+			ActiveSince:        s.UpstreamProvider.ActiveSince,
+			PreferredLocations: s.UpstreamProvider.PreferredLocations,
+			Excluded:           s.UpstreamProvider.Excluded,
+			Server:             s.UpstreamProvider.Server,
+			BestServers:        s.UpstreamProvider.BestServers,
+			Strategy:           s.UpstreamProvider.Strategy,
+			Filter:             s.UpstreamProvider.Filter,
+			Session:            providers.GetSession(s.UpstreamProvider.Provider),
+		}
+	}
+
+	return snapshot
+}
+
+// Write state snapshot to the state file. File is replaced atomically,
+// so partially written state will never be picked up on startup
+func SaveState(s *AppState) error {
+	if s == nil {
+		return errors.New("app state is nil")
+	}
+
+	encoded, err := json.MarshalIndent(s.Snapshot(), "", "  ")
+
+	if err != nil {
+		return fmt.Errorf("cannot encode state: %s", err)
+	}
+
+	target := GetStateFilePath()
+	temp := target + ".tmp"
+
+	// State contains account credentials, so keep it private
+	if err := os.WriteFile(temp, encoded, 0600); err != nil {
+		return fmt.Errorf("cannot write state: %s", err)
+	}
+
+	if err := os.Rename(temp, target); err != nil {
+		os.Remove(temp)
+
+		return fmt.Errorf("cannot replace state: %s", err)
+	}
+
+	return nil
+}
+
+// Read state snapshot from the state file. Returns nil snapshot
+// without an error if there's no state file yet
+func LoadState() (*StateSnapshot, error) {
+	encoded, err := os.ReadFile(GetStateFilePath())
+
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil, nil
+		}
+
+		return nil, err
+	}
+
+	snapshot := StateSnapshot{}
+
+	if err := json.Unmarshal(encoded, &snapshot); err != nil {
+		return nil, fmt.Errorf("cannot decode state: %s", err)
+	}
+
+	if snapshot.Version != StateVersion {
+		return nil, fmt.Errorf("unsupported state version %d, expected %d", snapshot.Version, StateVersion)
+	}
+
+	return &snapshot, nil
+}
+
+// Apply snapshot to the state. Available providers must be populated already,
+// since selected provider will be recreated using its initializer. Account is
+// not verified here, so daemon can be restarted without Internet access
+func (s *AppState) Restore(snapshot *StateSnapshot) error {
+	if snapshot == nil {
+		return nil
+	}
+
+	if snapshot.Provider != nil {
+		initializer, exists := s.AvailableProviders.Available[snapshot.Provider.Name]
+
+		if !exists {
+			return fmt.Errorf("provider '%s' does not exist", snapshot.Provider.Name)
+		}
+
+		provider, err := initializer(snapshot.Provider.Account)
+
+		if err != nil {
+			return fmt.Errorf("failed to initialize provider: %s", err)
+		}
+
+		provider.Details().ValidUntil = snapshot.Provider.ValidUntil
+
+		// Continue saved session, so it's not left behind with its key
+		if sessions, ok := provider.(providers.SessionAPI); ok && snapshot.Provider.Session != nil {
+			sessions.RestoreSession(*snapshot.Provider.Session)
+		}
+
+		s.UpstreamProvider = &providers.ProviderState{
+			Provider:           provider,
+			ActiveSince:        snapshot.Provider.ActiveSince,
+			PreferredLocations: snapshot.Provider.PreferredLocations,
+			Excluded:           snapshot.Provider.Excluded,
+			Server:             snapshot.Provider.Server,
+			BestServers:        snapshot.Provider.BestServers,
+			Strategy:           snapshot.Provider.Strategy,
+			Filter:             snapshot.Provider.Filter,
+		}
+
+		if legacy := snapshot.Provider.PreferredLocation; legacy != nil && len(s.UpstreamProvider.PreferredLocations) == 0 {
+			s.UpstreamProvider.PreferredLocations = []string{*legacy}
+		}
+	}
+
+	// Servers cache is only useful for the provider it came from
+	if snapshot.Servers != nil && s.UpstreamProvider != nil {
+		if snapshot.Servers.ProvidedBy == s.UpstreamProvider.Provider.Details().ProviderName {
+			s.Servers = snapshot.Servers
+		}
+	}
+
+	// Exit groups and standby use provider account, so they are useless without it
+	if s.UpstreamProvider != nil {
+		s.Exits = snapshot.Exits
+		s.Standby = snapshot.Standby
+	}
+
+	// Rotation policy set at runtime overrides the config file
+	if snapshot.RotationOverride && snapshot.Rotation != nil {
+		s.Rotation = snapshot.Rotation
+		s.RotationOverride = true
+	}
+
+	return nil
+}
@@ -2,16 +2,115 @@ package state
 
 import (
 	"sync"
+	"time"
 	"wirejump/internal/network"
 	"wirejump/internal/providers"
+	"wirejump/internal/schedule"
 )
 
 var once sync.Once
 var stateInstance *ProtectedState
 
+// Upstream supervisor settings
+type SupervisorConfig struct {
+	// Whether supervisor is running at all
+	Enabled bool
+
+	// How often to check upstream
+	Interval time.Duration
+
+	// Latest handshake older than this means upstream is dead
+	HandshakeTimeout time.Duration
+
+	// Optional TCP address to dial through the tunnel
+	ProbeAddress string
+
+	// How many failed checks in a row trigger reconnect
+	MaxFailures int
+
+	// How often to check upstream while standby can take over
+	FailoverInterval time.Duration
+
+	// Latest handshake older than this moves traffic to standby
+	FailoverTimeout time.Duration
+}
+
+// Metrics listener settings
+type MetricsConfig struct {
+	// Whether metrics are served at all
+	Enabled bool
+
+	// Listen address, like 127.0.0.1:9586
+	Listen string
+}
+
+// Provider account monitor settings
+type AccountConfig struct {
+	// Whether account is checked periodically
+	Enabled bool
+
+	// How often to check account
+	Interval time.Duration
+
+	// Warn when account expires within this many days
+	WarnDays int
+
+	// Optional script to run on warnings
+	NotifyScript string
+
+	// Optional URL to post warnings to
+	NotifyWebhook string
+}
+
+// Exit group: downstream peers which use their own upstream interface,
+// connected to the same provider account, but possibly to another location
+type ExitGroup struct {
+	// Group name, referenced by peers
+	Name string `json:"name"`
+
+	// Interface slot, defines both interface name and routing table
+	Slot int `json:"slot"`
+
+	// Location to connect to, random one is picked if it's not set
+	Location *string `json:"location"`
+
+	// Current server, nil if group is disconnected
+	Server *providers.WireguardServer `json:"server"`
+
+	// When group has been connected
+	ActiveSince *int64 `json:"active_since"`
+
+	// Exit interface, recreated from its config on startup
+	Interface *network.InterfaceConfig `json:"-"`
+}
+
+// Standby upstream: spare connection to another server, which is kept up,
+// so traffic can be moved to it right away when main upstream fails
+type StandbyUpstream struct {
+	// Location to connect to, main upstream one is used if it's not set
+	Location *string `json:"location"`
+
+	// Current server, nil if standby is disconnected
+	Server *providers.WireguardServer `json:"server"`
+
+	// When standby has been connected
+	ActiveSince *int64 `json:"active_since"`
+
+	// Whether standby carries downstream traffic instead of main upstream
+	Promoted bool `json:"promoted"`
+}
+
 type ConfigurationState struct {
 	UpstreamName   string
 	DownstreamName string
+	Backend        string
+	Endpoint       string
+	DualStack      bool
+	PreferIPv6     bool
+	Supervisor     SupervisorConfig
+	Metrics        MetricsConfig
+	Account        AccountConfig
+	Rotation       schedule.Policy
 }
 
 type AppState struct {
@@ -20,6 +119,13 @@ type AppState struct {
 	UpstreamProvider   *providers.ProviderState
 	Servers            *providers.ServersState
 	AvailableProviders providers.ProvidersState
+	Rotation           *schedule.Policy
+	Exits              []*ExitGroup
+	Standby            *StandbyUpstream
+
+	// Whether rotation policy has been changed at runtime,
+	// so it's saved and overrides the config file one
+	RotationOverride bool
 }
 
 type ProtectedState struct {
@@ -6,10 +6,17 @@ import (
 	"os"
 	"regexp"
 	"sort"
+	"strings"
 )
 
+// Metadata is stored in comments, since WireGuard tools would
+// reject unknown keys. Metadata keys are prefixed with INIMetaPrefix
+// in parsed sections, so '#@Name = laptop' becomes '@Name' key
+const INIMetaPrefix = "@"
+
 var INIEntity = regexp.MustCompile(
-	/* comment */ `^[[:blank:]]*(?P<comment>#.*)|` +
+	/*   meta  */ `^[[:blank:]]*#@(?P<meta>(?P<metakey>[A-Za-z]*)[[:blank:]]*\=[[:blank:]]*(?P<metavalue>.*))|` +
+		/* comment */ `^[[:blank:]]*(?P<comment>#.*)|` +
 		/* section */ `^[[:blank:]]*\[(?P<section>([A-Za-z]*))\]|` +
 		/*  data   */ `^[[:blank:]]*(?P<data>([A-Za-z]*)[[:blank:]]*\=[[:blank:]]*(.*))`)
 
@@ -67,6 +74,14 @@ func ReadINI(path string) (INIFile, error) {
 
 				// Create new section map
 				currentSection = &INIPair{}
+			} else if name == "meta" && matched != "" {
+				// Ignore metadata outside any section
+				if currentSection != nil {
+					k := match[INIEntity.SubexpIndex("metakey")]
+					v := match[INIEntity.SubexpIndex("metavalue")]
+
+					(*currentSection)[INIMetaPrefix+k] = v
+				}
 			} else if name == "data" && matched != "" {
 				// Ignore data outside any section
 				if currentSection != nil {
@@ -130,7 +145,30 @@ func WriteINI(path string, content INIFile) error {
 				return err
 			}
 
+			meta := []string{}
+
+			// Metadata goes first, so it's easy to spot
+			for k := range inside {
+				if strings.HasPrefix(k, INIMetaPrefix) {
+					meta = append(meta, k)
+				}
+			}
+
+			sort.Strings(meta)
+
+			for _, k := range meta {
+				_, e := writer.WriteString(fmt.Sprintf("#%s = %s\n", k, inside[k]))
+
+				if e != nil {
+					return e
+				}
+			}
+
 			for k, v := range inside {
+				if strings.HasPrefix(k, INIMetaPrefix) {
+					continue
+				}
+
 				_, e := writer.WriteString(fmt.Sprintf("%s = %s\n", k, v))
 
 				if e != nil {